@@ -51,6 +51,13 @@ func main() {
 	backendTimeout := flag.Duration("backend-timeout", 5*time.Second, "Backend request timeout")
 	connectTimeout := flag.Duration("connect-timeout", 2*time.Second, "Connection timeout")
 	useLocalhost := flag.Bool("use-localhost", false, "Use localhost instead of host.docker.internal for backends")
+	configPath := flag.String("config", "", "Path to a YAML or JSON gateway config file")
+	configPollInterval := flag.Duration("config-poll-interval", 5*time.Second, "How often to check the config file for changes")
+	reapInterval := flag.Duration("registry-reap-interval", 5*time.Second, "How often to expire registry instances whose TTL lapsed")
+	registryStore := flag.String("registry-store", "memory", "Where registered services are kept: memory or postgres")
+	registrySyncInterval := flag.Duration("registry-sync-interval", 5*time.Second, "How often to reload the registry from postgres to pick up other replicas' changes")
+	breakerWebhook := flag.String("breaker-webhook", "", "URL to post circuit breaker state changes to")
+	breakerWebhookTimeout := flag.Duration("breaker-webhook-timeout", 5*time.Second, "Timeout for posting to the breaker webhook")
 	flag.Parse()
 
 	timeoutConfig := gateway.TimeoutConfig{
@@ -65,10 +72,35 @@ func main() {
 		gatewayConfig = gateway.LocalhostConfig()
 	}
 
+	if *configPath != "" {
+		gatewayConfig, err = gateway.LoadConfig(*configPath)
+		if err != nil {
+			log.Fatalf("could not load gateway config: %v", err)
+		}
+
+		timeoutConfig = timeoutsWithFlagOverrides(gatewayConfig.Timeouts, timeoutConfig)
+
+		log.Printf("Loaded gateway config from %s (%d routes)", *configPath, len(gatewayConfig.Routes))
+	}
+
 	proxy := gateway.NewProxy(gatewayConfig, timeoutConfig)
-	registry := gateway.NewServiceRegistry()
+	var registry *gateway.ServiceRegistry
+	switch *registryStore {
+	case "memory":
+		registry = gateway.NewServiceRegistry()
+	case "postgres":
+		registry, err = gateway.NewServiceRegistryWithStore(context.Background(), gateway.NewRecordRegistryStore(db.NewRegistryRepository(newDB)))
+		if err != nil {
+			log.Fatalf("could not load service registry: %v", err)
+		}
+		go registry.StartSync(context.Background(), *registrySyncInterval)
+	default:
+		log.Fatalf("unknown registry store %q (expected memory or postgres)", *registryStore)
+	}
+	proxy.SetRegistry(registry)
 	healthConfig := gateway.DefaultHealthCheckConfig()
 	healthChecker := gateway.NewHealthChecker(registry, healthConfig)
+	healthChecker.SetProxy(proxy) // declared backends are health checked too
 
 	mux := http.NewServeMux()
 	mux.HandleFunc("GET /health", healthCheck)
@@ -78,20 +110,59 @@ func main() {
 	// Registry endpoints
 	mux.HandleFunc("POST /registry/register", registry.RegisterHandler)
 	mux.HandleFunc("DELETE /registry/deregister/{id}", registry.DeregisterHandler)
+	mux.HandleFunc("PUT /registry/heartbeat/{id}", registry.HeartbeatHandler)
 	mux.HandleFunc("GET /registry/services", registry.GetAllServicesHandler)
 	mux.HandleFunc("GET /registry/services/{route}", registry.GetServicesByRouteHandler)
+	mux.HandleFunc("GET /registry/watch", registry.WatchHandler)
+
+	// Admin endpoints expose and change backend state, so they need the admin token
+	adminToken := os.Getenv("ADMIN_TOKEN")
+	if adminToken == "" {
+		log.Printf("⚠️ ADMIN_TOKEN is not set, admin endpoints will refuse every request")
+	}
+	requireAdmin := middleware.RequireAdmin(adminToken)
+	mux.Handle("GET /admin/routes", requireAdmin(http.HandlerFunc(proxy.RoutesHandler)))
+	mux.Handle("GET /admin/circuit-breakers", requireAdmin(http.HandlerFunc(proxy.BreakersHandler)))
+	mux.Handle("POST /admin/circuit-breakers/state", requireAdmin(http.HandlerFunc(proxy.BreakerStateHandler)))
+	mux.Handle("POST /admin/circuit-breakers/reset", requireAdmin(http.HandlerFunc(proxy.BreakerResetHandler)))
 
 	mux.Handle("GET /protected", middleware.Authenticate(http.HandlerFunc(protectedHandler)))
-	mux.Handle("/", proxy)
+	mux.Handle("/", middleware.IdentifyUser(proxy)) // user ID keeps canary assignments sticky
 
 	go healthChecker.Start(context.Background())
+	go registry.StartReaper(context.Background(), *reapInterval)
+	if *breakerWebhook != "" {
+		go gateway.NewBreakerWebhook(*breakerWebhook, *breakerWebhookTimeout).Run(context.Background(), proxy)
+	}
+
+	if *configPath != "" {
+		reloadConfig := func() {
+			newConfig, err := gateway.LoadConfig(*configPath)
+			if err != nil {
+				log.Printf("❌ Config reload failed, keeping current config: %v", err)
+				return
+			}
+			proxy.Reload(newConfig, timeoutsWithFlagOverrides(newConfig.Timeouts, timeoutConfig))
+		}
+
+		go gateway.NewConfigWatcher(*configPath, *configPollInterval).Start(context.Background(), reloadConfig)
+
+		hangup := make(chan os.Signal, 1)
+		signal.Notify(hangup, syscall.SIGHUP)
+		go func() {
+			for range hangup {
+				log.Println("Received SIGHUP, reloading config...")
+				reloadConfig()
+			}
+		}()
+	}
 
 	server := &http.Server{
 		Addr:         fmt.Sprintf(":%s", port),
 		Handler:      mux,
 		IdleTimeout:  time.Minute,
 		ReadTimeout:  10 * time.Second,
-		WriteTimeout: 30 * time.Second,
+		WriteTimeout: 30 * time.Second, // proxied requests extend it to their route's request timeout
 	}
 
 	go startServer(server, port)
@@ -116,6 +187,21 @@ func main() {
 
 }
 
+// timeouts come from the config file unless explicitly set on the command line
+func timeoutsWithFlagOverrides(fileTimeouts, flagTimeouts gateway.TimeoutConfig) gateway.TimeoutConfig {
+	flag.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "request-timeout":
+			fileTimeouts.RequestTimeout = flagTimeouts.RequestTimeout
+		case "backend-timeout":
+			fileTimeouts.BackendTimeout = flagTimeouts.BackendTimeout
+		case "connect-timeout":
+			fileTimeouts.ConnectTimeout = flagTimeouts.ConnectTimeout
+		}
+	})
+	return fileTimeouts
+}
+
 func healthCheck(w http.ResponseWriter, r *http.Request) {
 	w.WriteHeader(http.StatusOK)
 	w.Write([]byte(`{"status": "healthy", "service": "go-ai-gateway"}`))
@@ -0,0 +1,121 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/aishahsofea/go-ai-gateway/internal/models"
+)
+
+// Keeps registered service instances in Postgres, shared by every gateway replica
+type RegistryRepository struct {
+	db *DB
+}
+
+func NewRegistryRepository(db *DB) *RegistryRepository {
+	return &RegistryRepository{db: db}
+}
+
+func (r *RegistryRepository) LoadInstances(ctx context.Context) ([]models.ServiceInstance, error) {
+	query := `
+		SELECT route, id, url, health, metadata, ttl_ms, last_seen, last_heartbeat, health_check
+		FROM service_instances
+		ORDER BY route, id
+	`
+
+	rows, err := r.db.Query(ctx, query)
+	if err != nil {
+		return nil, fmt.Errorf("error loading service instances: %w", err)
+	}
+	defer rows.Close()
+
+	var instances []models.ServiceInstance
+	for rows.Next() {
+		var instance models.ServiceInstance
+		var ttlMillis int64
+
+		err := rows.Scan(
+			&instance.Route,
+			&instance.ID,
+			&instance.URL,
+			&instance.Health,
+			&instance.Metadata,
+			&ttlMillis,
+			&instance.LastSeen,
+			&instance.LastHeartbeat,
+			&instance.HealthCheck,
+		)
+		if err != nil {
+			return nil, fmt.Errorf("error scanning service instance: %w", err)
+		}
+
+		instance.TTL = time.Duration(ttlMillis) * time.Millisecond
+		instances = append(instances, instance)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error loading service instances: %w", err)
+	}
+
+	return instances, nil
+}
+
+func (r *RegistryRepository) SaveInstance(ctx context.Context, instance models.ServiceInstance) error {
+	query := `
+		INSERT INTO service_instances (route, id, url, health, metadata, ttl_ms, last_seen, last_heartbeat, health_check)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+		ON CONFLICT (route, id) DO UPDATE SET
+			url = EXCLUDED.url,
+			health = EXCLUDED.health,
+			metadata = EXCLUDED.metadata,
+			ttl_ms = EXCLUDED.ttl_ms,
+			last_seen = EXCLUDED.last_seen,
+			last_heartbeat = EXCLUDED.last_heartbeat,
+			health_check = EXCLUDED.health_check
+	`
+
+	metadata := instance.Metadata
+	if metadata == nil {
+		metadata = map[string]string{}
+	}
+
+	var healthCheck any // NULL when the instance uses the checker's defaults
+	if instance.HealthCheck != nil {
+		healthCheck = string(instance.HealthCheck)
+	}
+
+	_, err := r.db.Exec(
+		ctx,
+		query,
+		instance.Route,
+		instance.ID,
+		instance.URL,
+		instance.Health,
+		metadata,
+		instance.TTL.Milliseconds(),
+		instance.LastSeen,
+		instance.LastHeartbeat,
+		healthCheck,
+	)
+
+	if err != nil {
+		return fmt.Errorf("error saving service instance: %w", err)
+	}
+
+	return nil
+}
+
+func (r *RegistryRepository) DeleteInstance(ctx context.Context, route, serviceID string) error {
+	query := `
+		DELETE FROM service_instances
+		WHERE route = $1 AND id = $2
+	`
+
+	_, err := r.db.Exec(ctx, query, route, serviceID)
+	if err != nil {
+		return fmt.Errorf("error deleting service instance: %w", err)
+	}
+
+	return nil
+}
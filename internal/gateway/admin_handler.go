@@ -0,0 +1,223 @@
+package gateway
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/aishahsofea/go-ai-gateway/internal/utils"
+)
+
+type routeInfo struct {
+	Key          string               `json:"key"`
+	Pattern      string               `json:"pattern"`
+	LoadBalancer LoadBalancerStrategy `json:"load_balancer"`
+	Discovery    DiscoveryMode        `json:"discovery"`
+	Overrides    []string             `json:"overrides"` // policy blocks set on the route itself
+	Policy       ResiliencePolicy     `json:"policy"`
+	Mirror       map[string]any       `json:"mirror,omitempty"` // shadow traffic stats
+	Outliers     map[string]any       `json:"outlier_detection,omitempty"`
+	RetryBudget  map[string]any       `json:"retry_budget,omitempty"` // requests and retries in the budget window
+	Hedge        map[string]any       `json:"hedge,omitempty"`        // current hedge delay and hedges sent
+	Backends     []backendInfo        `json:"backends"`
+}
+
+type backendInfo struct {
+	URL            string         `json:"url"`
+	Healthy        bool           `json:"healthy"`
+	CircuitBreaker map[string]any `json:"circuit_breaker"`
+	Bulkhead       map[string]any `json:"bulkhead"`          // concurrency limit and requests in flight
+	Outlier        map[string]any `json:"outlier,omitempty"` // ejection state, routes with outlier detection only
+}
+
+// Lists the active routes with the resilience policy each one effectively runs with,
+// the state of each backend and, for mirrored routes, how the shadow backend compares to the primary
+func (p *Proxy) RoutesHandler(w http.ResponseWriter, r *http.Request) {
+	snapshot := p.snapshot.Load()
+
+	routes := make([]routeInfo, 0, len(snapshot.config.Routes))
+	for i := range snapshot.config.Routes {
+		route := &snapshot.config.Routes[i]
+		info := routeInfo{
+			Key:          route.key(),
+			Pattern:      route.Pattern,
+			LoadBalancer: route.LoadBalancer,
+			Discovery:    route.Discovery,
+			Overrides:    route.policyOverrides(),
+			Policy:       snapshot.policyFor(route),
+		}
+
+		if mirror, exists := snapshot.mirrorFor(info.Key); exists {
+			info.Mirror = mirror.GetStats()
+		}
+
+		if budget, exists := snapshot.retryBudgetFor(info.Key); exists {
+			info.RetryBudget = budget.GetStats()
+		}
+
+		if hedge, exists := snapshot.hedgerFor(info.Key); exists {
+			info.Hedge = hedge.GetStats()
+		}
+
+		outliers, hasOutliers := snapshot.outlierDetectorFor(info.Key)
+		if hasOutliers {
+			info.Outliers = outliers.GetStats()
+		}
+
+		info.Backends = make([]backendInfo, 0)
+		for _, backend := range p.adminBackends(snapshot, route) {
+			backendInfo := backendInfo{
+				URL:            backend.URL,
+				Healthy:        backend.Healthy,
+				CircuitBreaker: backend.CircuitBreaker.GetStats(),
+				Bulkhead:       p.getBulkhead(snapshot, route, backend.URL).GetStats(),
+			}
+			if hasOutliers {
+				backendInfo.Outlier = outliers.backendStats(backend.URL)
+			}
+			info.Backends = append(info.Backends, backendInfo)
+		}
+
+		routes = append(routes, info)
+	}
+
+	utils.WriteJSON(w, http.StatusOK, utils.Envelope{"data": routes})
+}
+
+// The backends a route currently balances over, including split groups and registry instances
+func (p *Proxy) adminBackends(snapshot *proxySnapshot, route *Route) []Backend {
+	if route.Split != nil {
+		return route.Split.allBackends()
+	}
+	return p.routeBackends(snapshot, route)
+}
+
+type breakerInfo struct {
+	Key            string         `json:"key"`
+	Backend        string         `json:"backend"`
+	Routes         []string       `json:"routes"` // routes sharing the breaker
+	CircuitBreaker map[string]any `json:"circuit_breaker"`
+
+	breaker *CircuitBreaker
+}
+
+// Every circuit breaker the active routes use, sorted by key. With backend set,
+// only that backend's breakers, and with route set only those of that route.
+func (p *Proxy) breakers(backend, route string) []*breakerInfo {
+	snapshot := p.snapshot.Load()
+
+	seen := make(map[*CircuitBreaker]*breakerInfo)
+	var breakers []*breakerInfo
+	for i := range snapshot.config.Routes {
+		r := &snapshot.config.Routes[i]
+		if route != "" && route != r.Pattern && route != r.key() {
+			continue
+		}
+
+		for _, b := range p.adminBackends(snapshot, r) {
+			if b.CircuitBreaker == nil || (backend != "" && backend != b.URL) {
+				continue
+			}
+
+			info, exists := seen[b.CircuitBreaker]
+			if !exists {
+				info = &breakerInfo{
+					Key:     backendStateKey(r, b.URL),
+					Backend: b.URL,
+					breaker: b.CircuitBreaker,
+				}
+				seen[b.CircuitBreaker] = info
+				breakers = append(breakers, info)
+			}
+			info.Routes = append(info.Routes, r.key())
+		}
+	}
+
+	sort.Slice(breakers, func(i, j int) bool {
+		return breakers[i].Key < breakers[j].Key
+	})
+	return breakers
+}
+
+// Lists every backend's circuit breaker and how often breakers changed state
+func (p *Proxy) BreakersHandler(w http.ResponseWriter, r *http.Request) {
+	breakers := p.breakers(r.URL.Query().Get("backend"), r.URL.Query().Get("route"))
+	for _, info := range breakers {
+		info.CircuitBreaker = info.breaker.GetStats()
+	}
+
+	utils.WriteJSON(w, http.StatusOK, utils.Envelope{
+		"data":   breakers,
+		"events": p.breakerEvents.GetStats(),
+	})
+}
+
+type breakerRequest struct {
+	Backend string `json:"backend"`
+	Route   string `json:"route"` // pattern or key, all routes using the backend when empty
+	State   string `json:"state"` // open, closed or isolated; state requests only
+}
+
+// Forces the breakers of a backend open, closed or isolated, e.g. for maintenance
+func (p *Proxy) BreakerStateHandler(w http.ResponseWriter, r *http.Request) {
+	var req breakerRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Backend == "" {
+		http.Error(w, "invalid request body, backend is required", http.StatusBadRequest)
+		return
+	}
+
+	var state CircuitState
+	switch strings.ToLower(req.State) {
+	case "open":
+		state = StateOpen
+	case "closed":
+		state = StateClosed
+	case "isolated":
+		state = StateIsolated
+	default:
+		http.Error(w, "state must be open, closed or isolated", http.StatusBadRequest)
+		return
+	}
+
+	breakers := p.breakers(req.Backend, req.Route)
+	if len(breakers) == 0 {
+		http.Error(w, "no circuit breaker found for backend", http.StatusNotFound)
+		return
+	}
+
+	for _, info := range breakers {
+		if err := info.breaker.ForceState(state); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		info.CircuitBreaker = info.breaker.GetStats()
+		log.Printf("🔧 Circuit breaker %s forced %s", info.Key, state)
+	}
+
+	utils.WriteJSON(w, http.StatusOK, utils.Envelope{"data": breakers})
+}
+
+// Clears the counters of a backend's breakers without changing their state
+func (p *Proxy) BreakerResetHandler(w http.ResponseWriter, r *http.Request) {
+	var req breakerRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Backend == "" {
+		http.Error(w, "invalid request body, backend is required", http.StatusBadRequest)
+		return
+	}
+
+	breakers := p.breakers(req.Backend, req.Route)
+	if len(breakers) == 0 {
+		http.Error(w, "no circuit breaker found for backend", http.StatusNotFound)
+		return
+	}
+
+	for _, info := range breakers {
+		info.breaker.Reset()
+		info.CircuitBreaker = info.breaker.GetStats()
+		log.Printf("🔧 Circuit breaker %s reset", info.Key)
+	}
+
+	utils.WriteJSON(w, http.StatusOK, utils.Envelope{"data": breakers})
+}
@@ -0,0 +1,171 @@
+package gateway
+
+import (
+	"math/rand"
+	"time"
+)
+
+// Smallest share of its normal traffic a backend gets right after recovering
+const slowStartMinRamp = 0.1
+
+// What the health checker last concluded about a backend. Declared backends take
+// their health from it, registry instances only their recovery time.
+type backendHealth struct {
+	healthy     bool
+	recoveredAt time.Time // when it last went from unhealthy to healthy, zero if never
+}
+
+// The declared backends of the current config that the health checker should probe,
+// one per URL with the health check of the first route declaring it
+func (p *Proxy) healthCheckTargets() []ServiceInstance {
+	snapshot := p.snapshot.Load()
+
+	seen := make(map[string]bool)
+	var targets []ServiceInstance
+	for i := range snapshot.config.Routes {
+		route := &snapshot.config.Routes[i]
+
+		backends := route.declaredBackends()
+		if len(backends) == 0 && route.Target != "" {
+			backends = []*Backend{{URL: route.Target}}
+		}
+
+		for _, backend := range backends {
+			if seen[backend.URL] {
+				continue
+			}
+			seen[backend.URL] = true
+
+			targets = append(targets, ServiceInstance{
+				ID:          backend.URL,
+				URL:         backend.URL,
+				Route:       route.Pattern,
+				HealthCheck: route.HealthCheck,
+			})
+		}
+	}
+	return targets
+}
+
+// Empty when the backend hasn't been checked yet
+func (p *Proxy) backendHealthOf(url string) string {
+	p.healthMutex.RLock()
+	defer p.healthMutex.RUnlock()
+
+	state, exists := p.health[url]
+	switch {
+	case !exists:
+		return ""
+	case state.healthy:
+		return "healthy"
+	default:
+		return "unhealthy"
+	}
+}
+
+func (p *Proxy) setBackendHealth(url string, healthy bool) {
+	p.healthMutex.Lock()
+	defer p.healthMutex.Unlock()
+
+	state, exists := p.health[url]
+	if healthy && exists && !state.healthy {
+		state.recoveredAt = time.Now()
+	}
+	state.healthy = healthy
+	p.health[url] = state
+}
+
+// Starts the slow start ramp of a registry instance that became healthy again
+func (p *Proxy) markRecovered(url string) {
+	p.healthMutex.Lock()
+	defer p.healthMutex.Unlock()
+
+	p.health[url] = backendHealth{healthy: true, recoveredAt: time.Now()}
+}
+
+// Drops what is known about backends that are neither declared nor registered anymore
+func (p *Proxy) forgetBackendHealth(instances, static []ServiceInstance) {
+	declared := make(map[string]bool, len(static))
+	for _, target := range static {
+		declared[target.URL] = true
+	}
+	registered := make(map[string]bool, len(instances))
+	for _, instance := range instances {
+		registered[instance.URL] = true
+	}
+
+	p.healthMutex.Lock()
+	defer p.healthMutex.Unlock()
+
+	for url, state := range p.health {
+		// registry instances only keep their recovery time, their health lives in the registry
+		if !declared[url] && (!registered[url] || !state.healthy) {
+			delete(p.health, url)
+		}
+	}
+}
+
+// Marks backends the health checker found unhealthy, and holds back recovering
+// backends on a share of requests that grows over the route's slow start window
+func (p *Proxy) checkedBackends(route *Route, backends []Backend) []Backend {
+	p.healthMutex.RLock()
+	defer p.healthMutex.RUnlock()
+
+	if len(p.health) == 0 {
+		return backends
+	}
+
+	now := time.Now()
+	checked := make([]Backend, len(backends))
+	copy(checked, backends)
+
+	var held []int
+	available := 0
+	for i := range checked {
+		state, exists := p.health[checked[i].URL]
+		if exists && !state.healthy {
+			checked[i].Healthy = false
+		}
+		if !checked[i].Healthy {
+			continue
+		}
+
+		if exists && rand.Float64() >= route.slowStartRamp(state.recoveredAt, now) {
+			checked[i].Healthy = false
+			held = append(held, i)
+			continue
+		}
+		available++
+	}
+
+	// a warming backend is still better than none
+	if available == 0 {
+		for _, i := range held {
+			checked[i].Healthy = true
+		}
+	}
+
+	return checked
+}
+
+// Share of traffic a backend that recovered at recoveredAt should get, from
+// slowStartMinRamp right after recovering up to 1 once the window has passed.
+// Hash-based routes always get 1: holding a backend back on random requests
+// would send its keys elsewhere on some requests and not others.
+func (r *Route) slowStartRamp(recoveredAt, now time.Time) float64 {
+	window := time.Duration(r.SlowStart)
+	if window <= 0 || recoveredAt.IsZero() || pinsKeys(r.LoadBalancer) {
+		return 1
+	}
+
+	elapsed := now.Sub(recoveredAt)
+	if elapsed >= window {
+		return 1
+	}
+
+	ramp := float64(elapsed) / float64(window)
+	if ramp < slowStartMinRamp {
+		return slowStartMinRamp
+	}
+	return ramp
+}
@@ -0,0 +1,119 @@
+package gateway
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestBackendHealth(t *testing.T) {
+	t.Run("HealthCheckerDrivesDeclaredBackends", func(t *testing.T) {
+		hits := make(map[string]int)
+		newBackend := func(name string, healthStatus int) *httptest.Server {
+			return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.URL.Path == "/health" {
+					w.WriteHeader(healthStatus)
+					return
+				}
+				hits[name]++
+			}))
+		}
+
+		good := newBackend("good", http.StatusOK)
+		defer good.Close()
+		bad := newBackend("bad", http.StatusServiceUnavailable)
+		defer bad.Close()
+
+		proxy := NewProxy(testConfig(Route{
+			Pattern:  "/api/*",
+			Backends: []Backend{{URL: good.URL, Healthy: true, Weight: 1}, {URL: bad.URL, Healthy: true, Weight: 1}},
+		}), DefaultTimeoutConfig())
+
+		config := DefaultHealthCheckConfig()
+		config.FailureLimit = 1
+		config.Jitter = 0
+		healthChecker := NewHealthChecker(NewServiceRegistry(), config)
+		healthChecker.SetProxy(proxy)
+
+		healthChecker.checkAll(context.Background())
+		waitFor(t, func() bool {
+			return proxy.backendHealthOf(bad.URL) == "unhealthy" && proxy.backendHealthOf(good.URL) == "healthy"
+		})
+
+		for i := 0; i < 4; i++ {
+			proxy.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/api/items", nil))
+		}
+
+		if hits["good"] != 4 || hits["bad"] != 0 {
+			t.Errorf("Expected all 4 requests on the healthy backend, got %v", hits)
+		}
+	})
+
+	t.Run("SlowStartRamp", func(t *testing.T) {
+		route := &Route{SlowStart: Duration(10 * time.Second)}
+		recovered := time.Now()
+
+		tests := []struct {
+			elapsed time.Duration
+			want    float64
+		}{
+			{0, slowStartMinRamp},
+			{5 * time.Second, 0.5},
+			{10 * time.Second, 1},
+		}
+
+		for _, tt := range tests {
+			if got := route.slowStartRamp(recovered, recovered.Add(tt.elapsed)); got != tt.want {
+				t.Errorf("After %v: expected ramp %v, got %v", tt.elapsed, tt.want, got)
+			}
+		}
+
+		if got := (&Route{}).slowStartRamp(recovered, recovered); got != 1 {
+			t.Errorf("Expected ramp 1 without slow start, got %v", got)
+		}
+	})
+
+	t.Run("RecoveringBackendGetsReducedShare", func(t *testing.T) {
+		proxy := NewProxy(testConfig(Route{Pattern: "/api/*", Target: "http://a"}), DefaultTimeoutConfig())
+		route := &Route{SlowStart: Duration(time.Hour)}
+		backends := []Backend{{URL: "http://a", Healthy: true}, {URL: "http://b", Healthy: true}}
+
+		proxy.setBackendHealth("http://a", false)
+		proxy.setBackendHealth("http://a", true)
+
+		available := 0
+		for i := 0; i < 1000; i++ {
+			if proxy.checkedBackends(route, backends)[0].Healthy {
+				available++
+			}
+		}
+
+		if available < 50 || available > 200 {
+			t.Errorf("Expected recovering backend in about 10%% of selections, got %d of 1000", available)
+		}
+
+		// with nothing else to pick from the warming backend is used anyway
+		if !proxy.checkedBackends(route, backends[:1])[0].Healthy {
+			t.Error("Expected lone recovering backend to stay available")
+		}
+	})
+
+	t.Run("HashRoutesKeepRecoveringBackends", func(t *testing.T) {
+		proxy := NewProxy(testConfig(Route{Pattern: "/api/*", Target: "http://a"}), DefaultTimeoutConfig())
+		backends := []Backend{{URL: "http://a", Healthy: true}, {URL: "http://b", Healthy: true}}
+
+		proxy.setBackendHealth("http://a", false)
+		proxy.setBackendHealth("http://a", true)
+
+		for _, strategy := range []LoadBalancerStrategy{RingHash, Maglev} {
+			route := &Route{SlowStart: Duration(time.Hour), LoadBalancer: strategy}
+			for i := 0; i < 100; i++ {
+				if !proxy.checkedBackends(route, backends)[0].Healthy {
+					t.Fatalf("Expected %s to keep the recovering backend so its keys don't move, held back on selection %d", strategy, i+1)
+				}
+			}
+		}
+	})
+}
@@ -0,0 +1,177 @@
+package gateway
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Buffered events per watcher, a watcher that falls further behind is disconnected
+const breakerWatchBuffer = 64
+
+// A circuit breaker changing state
+type BreakerEvent struct {
+	Backend string    `json:"backend"`
+	Key     string    `json:"key"` // backend state key, prefixed with the route for routes with their own breakers
+	From    string    `json:"from"`
+	To      string    `json:"to"`
+	Reason  string    `json:"reason"` // e.g. failure_threshold, timeout_elapsed or forced
+	Time    time.Time `json:"time"`
+}
+
+type breakerEvents struct {
+	mutex       sync.Mutex
+	watchers    map[chan BreakerEvent]struct{}
+	transitions map[string]int64 // "CLOSED->OPEN" -> count
+}
+
+func newBreakerEvents() *breakerEvents {
+	return &breakerEvents{
+		watchers:    make(map[chan BreakerEvent]struct{}),
+		transitions: make(map[string]int64),
+	}
+}
+
+// Counts the transition and fans it out without blocking, breakers call it
+// while holding their lock. Safe to call on nil.
+func (be *breakerEvents) publish(event BreakerEvent) {
+	if be == nil {
+		return
+	}
+
+	be.mutex.Lock()
+	defer be.mutex.Unlock()
+
+	be.transitions[event.From+"->"+event.To]++
+
+	for watch := range be.watchers {
+		select {
+		case watch <- event:
+		default:
+			// too slow to keep up, it can subscribe again
+			close(watch)
+			delete(be.watchers, watch)
+		}
+	}
+}
+
+func (be *breakerEvents) GetStats() map[string]any {
+	be.mutex.Lock()
+	defer be.mutex.Unlock()
+
+	transitions := make(map[string]int64, len(be.transitions))
+	for transition, count := range be.transitions {
+		transitions[transition] = count
+	}
+
+	return map[string]any{
+		"transitions": transitions,
+		"watchers":    len(be.watchers),
+	}
+}
+
+// Subscribes to state changes of every circuit breaker of the proxy. The channel
+// is closed when the watcher falls behind or stop is called.
+func (p *Proxy) WatchBreakers() (<-chan BreakerEvent, func()) {
+	watch := make(chan BreakerEvent, breakerWatchBuffer)
+
+	p.breakerEvents.mutex.Lock()
+	p.breakerEvents.watchers[watch] = struct{}{}
+	p.breakerEvents.mutex.Unlock()
+
+	stop := func() {
+		p.breakerEvents.mutex.Lock()
+		defer p.breakerEvents.mutex.Unlock()
+
+		if _, exists := p.breakerEvents.watchers[watch]; exists {
+			close(watch)
+			delete(p.breakerEvents.watchers, watch)
+		}
+	}
+	return watch, stop
+}
+
+// Points the breakers of a snapshot's declared backends at the proxy's events
+func (p *Proxy) attachBreakers(snapshot *proxySnapshot) {
+	for i := range snapshot.config.Routes {
+		route := &snapshot.config.Routes[i]
+		for _, backend := range route.declaredBackends() {
+			if backend.CircuitBreaker != nil {
+				backend.CircuitBreaker.attach(p.breakerEvents, backendStateKey(route, backend.URL), backend.URL)
+			}
+		}
+	}
+}
+
+// Posts circuit breaker state changes as JSON to a webhook receiver
+type BreakerWebhook struct {
+	url    string
+	client *http.Client
+}
+
+func NewBreakerWebhook(url string, timeout time.Duration) *BreakerWebhook {
+	return &BreakerWebhook{
+		url:    url,
+		client: &http.Client{Timeout: timeout},
+	}
+}
+
+// Posts every state change of the proxy's breakers until ctx is done. Events
+// that come in while the receiver is slow may be dropped.
+func (bw *BreakerWebhook) Run(ctx context.Context, proxy *Proxy) {
+	for {
+		events, stop := proxy.WatchBreakers()
+		bw.deliver(ctx, events)
+		stop()
+
+		if ctx.Err() != nil {
+			return
+		}
+		log.Printf("⚠️ Breaker webhook %s fell behind, resubscribing", bw.url)
+	}
+}
+
+func (bw *BreakerWebhook) deliver(ctx context.Context, events <-chan BreakerEvent) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case event, ok := <-events:
+			if !ok {
+				return
+			}
+			if err := bw.post(ctx, event); err != nil {
+				log.Printf("❌ Breaker webhook %s failed for %s (%s -> %s): %v", bw.url, event.Backend, event.From, event.To, err)
+			}
+		}
+	}
+}
+
+func (bw *BreakerWebhook) post(ctx context.Context, event BreakerEvent) error {
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, bw.url, bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := bw.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("receiver returned %d", resp.StatusCode)
+	}
+	return nil
+}
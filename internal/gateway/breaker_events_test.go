@@ -0,0 +1,222 @@
+package gateway
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func breakerTestProxy() *Proxy {
+	return NewProxy(testConfig(
+		Route{Pattern: "/api/users/*", Backends: []Backend{
+			{URL: "http://backend-a:8000", Healthy: true, Weight: 1},
+			{URL: "http://backend-b:8000", Healthy: true, Weight: 1},
+		}},
+		Route{Pattern: "/api/accounts/*", Backends: []Backend{
+			{URL: "http://backend-a:8000", Healthy: true, Weight: 1},
+		}},
+	), DefaultTimeoutConfig())
+}
+
+func breakerRequestBody(body string) *http.Request {
+	return httptest.NewRequest("POST", "/admin/circuit-breakers/state", strings.NewReader(body))
+}
+
+func TestCircuitBreakerAdmin(t *testing.T) {
+	t.Run("ListsSharedBreakersOnce", func(t *testing.T) {
+		proxy := breakerTestProxy()
+
+		w := httptest.NewRecorder()
+		proxy.BreakersHandler(w, httptest.NewRequest("GET", "/admin/circuit-breakers", nil))
+
+		if w.Code != http.StatusOK {
+			t.Fatalf("Expected status 200, got %d", w.Code)
+		}
+
+		var response struct {
+			Data []struct {
+				Backend        string         `json:"backend"`
+				Routes         []string       `json:"routes"`
+				CircuitBreaker map[string]any `json:"circuit_breaker"`
+			} `json:"data"`
+		}
+		if err := json.NewDecoder(w.Body).Decode(&response); err != nil {
+			t.Fatalf("Expected valid JSON, got %v", err)
+		}
+
+		if len(response.Data) != 2 {
+			t.Fatalf("Expected 2 breakers, got %+v", response.Data)
+		}
+		if response.Data[0].Backend != "http://backend-a:8000" || len(response.Data[0].Routes) != 2 {
+			t.Errorf("Expected backend-a's breaker to be shared by both routes, got %+v", response.Data[0])
+		}
+		if response.Data[1].CircuitBreaker["state"] != "CLOSED" {
+			t.Errorf("Expected backend-b's breaker to be closed, got %v", response.Data[1].CircuitBreaker)
+		}
+	})
+
+	t.Run("IsolatedStaysOpenUntilClosed", func(t *testing.T) {
+		proxy := breakerTestProxy()
+		events, stop := proxy.WatchBreakers()
+		defer stop()
+
+		w := httptest.NewRecorder()
+		proxy.BreakerStateHandler(w, breakerRequestBody(`{"backend": "http://backend-b:8000", "state": "isolated"}`))
+		if w.Code != http.StatusOK {
+			t.Fatalf("Expected status 200, got %d: %s", w.Code, w.Body.String())
+		}
+
+		breaker := proxy.breakers("http://backend-b:8000", "")[0].breaker
+		breaker.config.Timeout = 0 // an open breaker would go half-open right away
+		if breaker.CanRequest() || breaker.GetState() != StateIsolated {
+			t.Fatalf("Expected isolated breaker to reject requests, got %v", breaker.GetState())
+		}
+
+		event := <-events
+		if event.Backend != "http://backend-b:8000" || event.To != "ISOLATED" || event.Reason != "forced" {
+			t.Errorf("Expected a forced ISOLATED event for backend-b, got %+v", event)
+		}
+
+		w = httptest.NewRecorder()
+		proxy.BreakerStateHandler(w, breakerRequestBody(`{"backend": "http://backend-b:8000", "state": "closed"}`))
+		if w.Code != http.StatusOK || !breaker.CanRequest() {
+			t.Errorf("Expected closed breaker to allow requests, got status %d and %v", w.Code, breaker.GetState())
+		}
+	})
+
+	t.Run("ForceOpenOnOneRoute", func(t *testing.T) {
+		config := testConfig(
+			Route{Pattern: "/api/users/*", Backends: []Backend{{URL: "http://backend-a:8000", Healthy: true, Weight: 1}}},
+			Route{Pattern: "/api/llm/*", CircuitBreaker: &CircuitBreakerConfig{FailureThreshold: 1, SuccessThreshold: 1, Timeout: time.Minute, MaxRequests: 1},
+				Backends: []Backend{{URL: "http://backend-a:8000", Healthy: true, Weight: 1}}},
+		)
+		proxy := NewProxy(config, DefaultTimeoutConfig())
+
+		w := httptest.NewRecorder()
+		proxy.BreakerStateHandler(w, breakerRequestBody(`{"backend": "http://backend-a:8000", "route": "/api/llm/*", "state": "open"}`))
+		if w.Code != http.StatusOK {
+			t.Fatalf("Expected status 200, got %d: %s", w.Code, w.Body.String())
+		}
+
+		if state := config.Routes[1].Backends[0].CircuitBreaker.GetState(); state != StateOpen {
+			t.Errorf("Expected the llm route's breaker to be open, got %v", state)
+		}
+		if state := config.Routes[0].Backends[0].CircuitBreaker.GetState(); state != StateClosed {
+			t.Errorf("Expected the users route's breaker to stay closed, got %v", state)
+		}
+	})
+
+	t.Run("ResetKeepsState", func(t *testing.T) {
+		proxy := breakerTestProxy()
+		breaker := proxy.breakers("http://backend-b:8000", "")[0].breaker
+		breaker.RecordFailure()
+		breaker.RecordFailure()
+
+		w := httptest.NewRecorder()
+		proxy.BreakerResetHandler(w, httptest.NewRequest("POST", "/admin/circuit-breakers/reset", strings.NewReader(`{"backend": "http://backend-b:8000"}`)))
+		if w.Code != http.StatusOK {
+			t.Fatalf("Expected status 200, got %d", w.Code)
+		}
+
+		stats := breaker.GetStats()
+		if stats["failure_count"] != 0 || stats["state"] != "CLOSED" {
+			t.Errorf("Expected a closed breaker without failures, got %v", stats)
+		}
+	})
+
+	t.Run("RejectsBadRequests", func(t *testing.T) {
+		proxy := breakerTestProxy()
+
+		for _, test := range []struct {
+			body     string
+			expected int
+		}{
+			{`{"state": "open"}`, http.StatusBadRequest},
+			{`{"backend": "http://backend-a:8000", "state": "half_open"}`, http.StatusBadRequest},
+			{`{"backend": "http://unknown:8000", "state": "open"}`, http.StatusNotFound},
+		} {
+			w := httptest.NewRecorder()
+			proxy.BreakerStateHandler(w, breakerRequestBody(test.body))
+			if w.Code != test.expected {
+				t.Errorf("Expected status %d for %s, got %d", test.expected, test.body, w.Code)
+			}
+		}
+	})
+}
+
+func TestBreakerEvents(t *testing.T) {
+	t.Run("TripsArePublished", func(t *testing.T) {
+		proxy := breakerTestProxy()
+		events, stop := proxy.WatchBreakers()
+		defer stop()
+
+		breaker := proxy.breakers("http://backend-a:8000", "")[0].breaker
+		for i := 0; i < DefaultCircuitBreakerConfig().FailureThreshold; i++ {
+			breaker.RecordFailure()
+		}
+
+		event := <-events
+		if event.From != "CLOSED" || event.To != "OPEN" || event.Reason != "failure_threshold" || event.Key != "http://backend-a:8000" {
+			t.Errorf("Expected a CLOSED -> OPEN event for backend-a, got %+v", event)
+		}
+
+		transitions := proxy.breakerEvents.GetStats()["transitions"].(map[string]int64)
+		if transitions["CLOSED->OPEN"] != 1 {
+			t.Errorf("Expected 1 CLOSED->OPEN transition, got %v", transitions)
+		}
+	})
+
+	t.Run("SlowWatcherIsDisconnected", func(t *testing.T) {
+		proxy := breakerTestProxy()
+		events, stop := proxy.WatchBreakers()
+		defer stop()
+
+		breaker := proxy.breakers("http://backend-a:8000", "")[0].breaker
+		for i := 0; i <= breakerWatchBuffer; i++ {
+			breaker.ForceState(StateIsolated)
+			breaker.ForceState(StateClosed)
+		}
+
+		received := 0
+		for range events {
+			received++
+		}
+		if received != breakerWatchBuffer {
+			t.Errorf("Expected the channel to close after %d events, got %d", breakerWatchBuffer, received)
+		}
+	})
+
+	t.Run("WebhookReceivesTrips", func(t *testing.T) {
+		received := make(chan BreakerEvent, 1)
+		receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			var event BreakerEvent
+			json.NewDecoder(r.Body).Decode(&event)
+			received <- event
+		}))
+		defer receiver.Close()
+
+		proxy := breakerTestProxy()
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		go NewBreakerWebhook(receiver.URL, time.Second).Run(ctx, proxy)
+
+		// wait for the webhook to subscribe
+		for proxy.breakerEvents.GetStats()["watchers"] == 0 {
+			time.Sleep(time.Millisecond)
+		}
+		proxy.breakers("http://backend-b:8000", "")[0].breaker.ForceState(StateOpen)
+
+		select {
+		case event := <-received:
+			if event.Backend != "http://backend-b:8000" || event.To != "OPEN" {
+				t.Errorf("Expected an OPEN event for backend-b, got %+v", event)
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatal("Expected the webhook to post the event")
+		}
+	})
+}
@@ -2,25 +2,59 @@ package gateway
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
 	"sync"
 	"time"
 )
 
 type BulkheadConfig struct {
-	MaxConcurrentRequests int
-	QueueSize             int
-	QueueTimeout          time.Duration
+	Mode                  string              `json:"mode"` // fixed or adaptive
+	MaxConcurrentRequests int                 `json:"max_concurrent_requests"`
+	QueueSize             int                 `json:"queue_size"`
+	QueueTimeout          time.Duration       `json:"queue_timeout"`
+	Adaptive              AdaptiveLimitConfig `json:"adaptive"` // adaptive mode only
 }
 
 func DefaultBulkheadConfig() BulkheadConfig {
 	return BulkheadConfig{
+		Mode:                  LimiterFixed,
 		MaxConcurrentRequests: 10,
 		QueueSize:             5,
 		QueueTimeout:          2 * time.Second,
+		Adaptive:              DefaultAdaptiveLimitConfig(),
 	}
 }
 
+func (c *BulkheadConfig) UnmarshalJSON(data []byte) error {
+	type alias BulkheadConfig
+	aux := struct {
+		*alias
+		QueueTimeout Duration `json:"queue_timeout"`
+	}{
+		alias:        (*alias)(c),
+		QueueTimeout: Duration(c.QueueTimeout),
+	}
+
+	if err := decodeStrict(data, &aux); err != nil {
+		return err
+	}
+
+	c.QueueTimeout = time.Duration(aux.QueueTimeout)
+	return nil
+}
+
+func (c BulkheadConfig) MarshalJSON() ([]byte, error) {
+	type alias BulkheadConfig
+	return json.Marshal(struct {
+		alias
+		QueueTimeout Duration `json:"queue_timeout"`
+	}{
+		alias:        alias(c),
+		QueueTimeout: Duration(c.QueueTimeout),
+	})
+}
+
 type Request struct {
 	ctx      context.Context
 	response chan error
@@ -108,6 +142,7 @@ func (sb *ServiceBulkhead) GetStats() map[string]any {
 	defer sb.mutex.RUnlock()
 
 	return map[string]any{
+		"mode":           LimiterFixed,
 		"max_concurrent": sb.config.MaxConcurrentRequests,
 		"active_count":   sb.activeCount,
 		"queued_count":   sb.queuedCount,
@@ -1,6 +1,8 @@
 package gateway
 
 import (
+	"encoding/json"
+	"fmt"
 	"sync"
 	"time"
 )
@@ -11,6 +13,7 @@ const (
 	StateClosed CircuitState = iota
 	StateOpen
 	StateHalfOpen
+	StateIsolated // held open by an operator, no trial requests until it is forced into another state
 )
 
 func (s CircuitState) String() string {
@@ -21,27 +24,83 @@ func (s CircuitState) String() string {
 		return "OPEN"
 	case StateHalfOpen:
 		return "HALF_OPEN"
+	case StateIsolated:
+		return "ISOLATED"
 	default:
 		return "UNKNOWN"
 	}
 }
 
 type CircuitBreakerConfig struct {
+	Mode             string        `json:"mode"` // consecutive (default), count_window or time_window
 	FailureThreshold int           `json:"failure_threshold"`
 	SuccessThreshold int           `json:"success_threshold"`
 	Timeout          time.Duration `json:"timeout"`
 	MaxRequests      int           `json:"max_requests"`
+
+	// Sliding window modes only
+	WindowSize            int           `json:"window_size"`     // calls, count_window
+	WindowDuration        time.Duration `json:"window_duration"` // whole seconds, time_window
+	MinimumCalls          int           `json:"minimum_calls"`   // calls in the window before the rates are looked at
+	FailureRateThreshold  float64       `json:"failure_rate_threshold"`
+	SlowCallDuration      time.Duration `json:"slow_call_duration"` // calls taking longer count as slow, 0 disables
+	SlowCallRateThreshold float64       `json:"slow_call_rate_threshold"`
 }
 
 func DefaultCircuitBreakerConfig() CircuitBreakerConfig {
 	return CircuitBreakerConfig{
-		FailureThreshold: 5,                // open after 5 failures
-		SuccessThreshold: 2,                // close after 2 successes
-		Timeout:          30 * time.Second, // stays open for 30 seconds
-		MaxRequests:      3,                // allow 3 test requests when half-open
+		Mode:                  BreakerConsecutive,
+		FailureThreshold:      5,                // open after 5 failures
+		SuccessThreshold:      2,                // close after 2 successes
+		Timeout:               30 * time.Second, // stays open for 30 seconds
+		MaxRequests:           3,                // allow 3 test requests when half-open
+		WindowSize:            100,
+		WindowDuration:        60 * time.Second,
+		MinimumCalls:          20,
+		FailureRateThreshold:  50,  // open when half the calls in the window fail
+		SlowCallRateThreshold: 100, // or when all of them are slow
 	}
 }
 
+func (c *CircuitBreakerConfig) UnmarshalJSON(data []byte) error {
+	type alias CircuitBreakerConfig
+	aux := struct {
+		*alias
+		Timeout          Duration `json:"timeout"`
+		WindowDuration   Duration `json:"window_duration"`
+		SlowCallDuration Duration `json:"slow_call_duration"`
+	}{
+		alias:            (*alias)(c),
+		Timeout:          Duration(c.Timeout),
+		WindowDuration:   Duration(c.WindowDuration),
+		SlowCallDuration: Duration(c.SlowCallDuration),
+	}
+
+	if err := decodeStrict(data, &aux); err != nil {
+		return err
+	}
+
+	c.Timeout = time.Duration(aux.Timeout)
+	c.WindowDuration = time.Duration(aux.WindowDuration)
+	c.SlowCallDuration = time.Duration(aux.SlowCallDuration)
+	return nil
+}
+
+func (c CircuitBreakerConfig) MarshalJSON() ([]byte, error) {
+	type alias CircuitBreakerConfig
+	return json.Marshal(struct {
+		alias
+		Timeout          Duration `json:"timeout"`
+		WindowDuration   Duration `json:"window_duration"`
+		SlowCallDuration Duration `json:"slow_call_duration"`
+	}{
+		alias:            alias(c),
+		Timeout:          Duration(c.Timeout),
+		WindowDuration:   Duration(c.WindowDuration),
+		SlowCallDuration: Duration(c.SlowCallDuration),
+	})
+}
+
 type CircuitBreaker struct {
 	config          CircuitBreakerConfig
 	state           CircuitState
@@ -49,14 +108,28 @@ type CircuitBreaker struct {
 	successCount    int
 	requestCount    int
 	lastFailureTime time.Time
+	window          callWindow // recent calls, sliding window modes only
 	mutex           sync.RWMutex
+
+	// where state changes are published, set once the breaker belongs to a proxy
+	events  *breakerEvents
+	key     string // backend state key
+	backend string
 }
 
 func NewCircuitBreaker(config CircuitBreakerConfig) *CircuitBreaker {
-	return &CircuitBreaker{
+	cb := &CircuitBreaker{
 		config: config,
 		state:  StateClosed,
 	}
+
+	switch config.Mode {
+	case BreakerCountWindow:
+		cb.window = newCountWindow(config.WindowSize)
+	case BreakerTimeWindow:
+		cb.window = newTimeWindow(config.WindowDuration)
+	}
+	return cb
 }
 
 func (cb *CircuitBreaker) CanRequest() bool {
@@ -68,7 +141,7 @@ func (cb *CircuitBreaker) CanRequest() bool {
 		return true
 	case StateOpen:
 		if time.Since(cb.lastFailureTime) >= cb.config.Timeout {
-			cb.setState(StateHalfOpen)
+			cb.setState(StateHalfOpen, "timeout_elapsed")
 			return true
 		}
 		return false
@@ -84,6 +157,74 @@ func (cb *CircuitBreaker) CanRequest() bool {
 }
 
 func (cb *CircuitBreaker) RecordSuccess() {
+	cb.RecordCall(true, 0)
+}
+
+func (cb *CircuitBreaker) RecordFailure() {
+	cb.RecordCall(false, 0)
+}
+
+// Records the outcome of a call and how long it took, which only matters for
+// the slow call rate of the sliding window modes
+func (cb *CircuitBreaker) RecordCall(success bool, duration time.Duration) {
+	if cb.window == nil {
+		if success {
+			cb.recordSuccess()
+		} else {
+			cb.recordFailure()
+		}
+		return
+	}
+
+	cb.mutex.Lock()
+	defer cb.mutex.Unlock()
+
+	now := time.Now()
+	slow := cb.config.SlowCallDuration > 0 && duration > cb.config.SlowCallDuration
+	if !success {
+		cb.failureCount++
+		cb.lastFailureTime = now
+	}
+
+	switch cb.state {
+	case StateClosed:
+		cb.window.record(!success, slow, now)
+
+		totals := cb.window.totals(now)
+		if totals.calls < cb.config.MinimumCalls {
+			return
+		}
+
+		failureRate, slowRate := totals.rates()
+		reason := ""
+		switch {
+		case failureRate >= cb.config.FailureRateThreshold:
+			reason = "failure_rate"
+		case cb.config.SlowCallDuration > 0 && slowRate >= cb.config.SlowCallRateThreshold:
+			reason = "slow_call_rate"
+		}
+		if reason != "" {
+			// the open timeout runs from the moment the breaker trips
+			cb.lastFailureTime = now
+			cb.setState(StateOpen, reason)
+		}
+
+	case StateHalfOpen:
+		// a trial call that is slow counts against the backend like a failure
+		if !success || slow {
+			cb.lastFailureTime = now
+			cb.setState(StateOpen, "trial_failed")
+			return
+		}
+
+		cb.successCount++
+		if cb.successCount >= cb.config.SuccessThreshold {
+			cb.setState(StateClosed, "trial_succeeded")
+		}
+	}
+}
+
+func (cb *CircuitBreaker) recordSuccess() {
 	cb.mutex.Lock()
 	defer cb.mutex.Unlock()
 
@@ -93,12 +234,12 @@ func (cb *CircuitBreaker) RecordSuccess() {
 	case StateHalfOpen:
 		cb.successCount++
 		if cb.successCount >= cb.config.SuccessThreshold {
-			cb.setState(StateClosed)
+			cb.setState(StateClosed, "trial_succeeded")
 		}
 	}
 }
 
-func (cb *CircuitBreaker) RecordFailure() {
+func (cb *CircuitBreaker) recordFailure() {
 	cb.mutex.Lock()
 	defer cb.mutex.Unlock()
 
@@ -108,32 +249,85 @@ func (cb *CircuitBreaker) RecordFailure() {
 	switch cb.state {
 	case StateClosed:
 		if cb.failureCount >= cb.config.FailureThreshold {
-			cb.setState(StateOpen)
+			cb.setState(StateOpen, "failure_threshold")
 		}
 	case StateHalfOpen:
-		cb.setState(StateOpen)
+		cb.setState(StateOpen, "trial_failed")
 	}
 }
 
-func (cb *CircuitBreaker) setState(newState CircuitState) {
+func (cb *CircuitBreaker) setState(newState CircuitState, reason string) {
 	if cb.state == newState {
 		return
 	}
 
+	previous := cb.state
 	cb.state = newState
+	cb.events.publish(BreakerEvent{
+		Backend: cb.backend,
+		Key:     cb.key,
+		From:    previous.String(),
+		To:      newState.String(),
+		Reason:  reason,
+		Time:    time.Now(),
+	})
 
 	switch newState {
 	case StateClosed:
 		cb.failureCount = 0
 		cb.successCount = 0
 		cb.requestCount = 0
+		if cb.window != nil {
+			cb.window.reset()
+		}
 
-	case StateOpen, StateHalfOpen:
+	case StateOpen, StateHalfOpen, StateIsolated:
 		cb.requestCount = 0
 		cb.successCount = 0
 	}
 }
 
+// Puts the breaker into state by hand. Open breakers go half-open after the
+// timeout as usual, isolated ones stay open until forced into another state.
+func (cb *CircuitBreaker) ForceState(state CircuitState) error {
+	cb.mutex.Lock()
+	defer cb.mutex.Unlock()
+
+	switch state {
+	case StateOpen:
+		cb.lastFailureTime = time.Now()
+	case StateClosed, StateIsolated:
+	default:
+		return fmt.Errorf("cannot force a circuit breaker %s", state)
+	}
+
+	cb.setState(state, "forced")
+	return nil
+}
+
+// Clears the counters and the sliding window, the state stays as it is
+func (cb *CircuitBreaker) Reset() {
+	cb.mutex.Lock()
+	defer cb.mutex.Unlock()
+
+	cb.failureCount = 0
+	cb.successCount = 0
+	cb.requestCount = 0
+	if cb.window != nil {
+		cb.window.reset()
+	}
+}
+
+// Publishes the breaker's state changes to events as those of backend
+func (cb *CircuitBreaker) attach(events *breakerEvents, key, backend string) {
+	cb.mutex.Lock()
+	defer cb.mutex.Unlock()
+
+	cb.events = events
+	cb.key = key
+	cb.backend = backend
+}
+
 func (cb *CircuitBreaker) GetState() CircuitState {
 	cb.mutex.RLock()
 	defer cb.mutex.RUnlock()
@@ -144,10 +338,21 @@ func (cb *CircuitBreaker) GetStats() map[string]interface{} {
 	cb.mutex.RLock()
 	defer cb.mutex.RUnlock()
 
-	return map[string]interface{}{
+	stats := map[string]interface{}{
 		"state":         cb.state.String(),
 		"failure_count": cb.failureCount,
 		"success_count": cb.successCount,
 		"request_count": cb.requestCount,
 	}
+
+	if cb.window != nil {
+		totals := cb.window.totals(time.Now())
+		failureRate, slowRate := totals.rates()
+		stats["mode"] = cb.config.Mode
+		stats["window_calls"] = totals.calls
+		stats["failure_rate"] = failureRate
+		stats["slow_call_rate"] = slowRate
+	}
+
+	return stats
 }
@@ -0,0 +1,224 @@
+package gateway
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func windowBreakerConfig(mode string) CircuitBreakerConfig {
+	config := DefaultCircuitBreakerConfig()
+	config.Mode = mode
+	config.WindowSize = 10
+	config.WindowDuration = 10 * time.Second
+	config.MinimumCalls = 4
+	config.Timeout = time.Hour
+	return config
+}
+
+func TestCircuitBreakerWindows(t *testing.T) {
+	t.Run("CountWindowWaitsForMinimumCalls", func(t *testing.T) {
+		cb := NewCircuitBreaker(windowBreakerConfig(BreakerCountWindow))
+
+		for i := 0; i < 3; i++ {
+			cb.RecordCall(false, 0)
+		}
+		if cb.GetState() != StateClosed {
+			t.Fatalf("Expected closed below minimum calls, got %v", cb.GetState())
+		}
+
+		cb.RecordCall(false, 0)
+		if cb.GetState() != StateOpen {
+			t.Errorf("Expected open at a 100%% failure rate, got %v", cb.GetState())
+		}
+	})
+
+	t.Run("SparseFailuresStayClosed", func(t *testing.T) {
+		cb := NewCircuitBreaker(windowBreakerConfig(BreakerCountWindow))
+
+		// every third call fails, below the default 50% threshold
+		for i := 0; i < 30; i++ {
+			cb.RecordCall(i%3 != 2, 0)
+		}
+		if cb.GetState() != StateClosed {
+			t.Errorf("Expected closed at a 33%% failure rate, got %v", cb.GetState())
+		}
+	})
+
+	t.Run("OldCallsLeaveTheCountWindow", func(t *testing.T) {
+		cb := NewCircuitBreaker(windowBreakerConfig(BreakerCountWindow))
+
+		for i := 0; i < 4; i++ {
+			cb.RecordCall(true, 0)
+			cb.RecordCall(true, 0)
+			cb.RecordCall(false, 0)
+		}
+		for i := 0; i < 10; i++ {
+			cb.RecordCall(true, 0)
+		}
+
+		stats := cb.GetStats()
+		if stats["window_calls"] != 10 || stats["failure_rate"] != 0.0 {
+			t.Errorf("Expected 10 calls without failures in the window, got %v", stats)
+		}
+	})
+
+	t.Run("SlowCallRateOpens", func(t *testing.T) {
+		config := windowBreakerConfig(BreakerCountWindow)
+		config.SlowCallDuration = 100 * time.Millisecond
+		config.SlowCallRateThreshold = 75
+		cb := NewCircuitBreaker(config)
+
+		cb.RecordCall(true, 10*time.Millisecond)
+		for i := 0; i < 2; i++ {
+			cb.RecordCall(true, time.Second)
+		}
+		if cb.GetState() != StateClosed {
+			t.Fatalf("Expected closed below minimum calls, got %v", cb.GetState())
+		}
+
+		cb.RecordCall(true, time.Second)
+		if cb.GetState() != StateOpen {
+			t.Errorf("Expected open at a 75%% slow call rate, got %v", cb.GetState())
+		}
+	})
+
+	t.Run("SlowCallsIgnoredWithoutDuration", func(t *testing.T) {
+		cb := NewCircuitBreaker(windowBreakerConfig(BreakerCountWindow))
+
+		for i := 0; i < 10; i++ {
+			cb.RecordCall(true, time.Minute)
+		}
+		if cb.GetState() != StateClosed {
+			t.Errorf("Expected closed without slow_call_duration, got %v", cb.GetState())
+		}
+	})
+
+	t.Run("TimeWindowForgetsOldSeconds", func(t *testing.T) {
+		window := newTimeWindow(10 * time.Second)
+		start := time.Unix(1000, 0)
+
+		for i := 0; i < 5; i++ {
+			window.record(true, false, start)
+		}
+		window.record(false, false, start.Add(5*time.Second))
+
+		if totals := window.totals(start.Add(9 * time.Second)); totals.calls != 6 || totals.failures != 5 {
+			t.Errorf("Expected 6 calls and 5 failures within the window, got %+v", totals)
+		}
+		if totals := window.totals(start.Add(10 * time.Second)); totals.calls != 1 || totals.failures != 0 {
+			t.Errorf("Expected only the later call once the first second expired, got %+v", totals)
+		}
+
+		// the bucket of the first second is reused
+		window.record(false, false, start.Add(10*time.Second))
+		if totals := window.totals(start.Add(10 * time.Second)); totals.calls != 2 || totals.failures != 0 {
+			t.Errorf("Expected 2 calls after reusing the bucket, got %+v", totals)
+		}
+	})
+
+	t.Run("SlowTrialCallReopens", func(t *testing.T) {
+		config := windowBreakerConfig(BreakerTimeWindow)
+		config.Timeout = time.Millisecond
+		config.SlowCallDuration = 100 * time.Millisecond
+		cb := NewCircuitBreaker(config)
+
+		for i := 0; i < 4; i++ {
+			cb.RecordCall(false, 0)
+		}
+		time.Sleep(5 * time.Millisecond)
+
+		if !cb.CanRequest() || cb.GetState() != StateHalfOpen {
+			t.Fatalf("Expected half-open after the timeout, got %v", cb.GetState())
+		}
+		cb.RecordCall(true, time.Second)
+		if cb.GetState() != StateOpen {
+			t.Errorf("Expected a slow trial call to reopen the breaker, got %v", cb.GetState())
+		}
+	})
+
+	t.Run("ConsecutiveModeUnchanged", func(t *testing.T) {
+		config := DefaultCircuitBreakerConfig()
+		config.FailureThreshold = 3
+		cb := NewCircuitBreaker(config)
+
+		cb.RecordFailure()
+		cb.RecordFailure()
+		cb.RecordSuccess()
+		cb.RecordFailure()
+		cb.RecordFailure()
+		if cb.GetState() != StateClosed {
+			t.Fatalf("Expected a success to reset the failure count, got %v", cb.GetState())
+		}
+
+		cb.RecordCall(false, time.Minute)
+		if cb.GetState() != StateOpen {
+			t.Errorf("Expected open after 3 failures in a row, got %v", cb.GetState())
+		}
+	})
+}
+
+func TestCircuitBreakerModeConfig(t *testing.T) {
+	t.Run("PerRouteWindowMode", func(t *testing.T) {
+		data := `
+routes:
+  - pattern: /api/llm/*
+    target: http://llm:9000
+    circuit_breaker:
+      mode: time_window
+      window_duration: 30s
+      slow_call_duration: 20s
+  - pattern: /api/users/*
+    target: http://users:8001
+`
+
+		config, err := ParseConfig([]byte(data), "yaml")
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+
+		llm := config.Routes[0].CircuitBreaker
+		if llm.Mode != BreakerTimeWindow || llm.WindowDuration != 30*time.Second || llm.SlowCallDuration != 20*time.Second {
+			t.Errorf("Expected the route's window settings, got %+v", *llm)
+		}
+		if llm.MinimumCalls != 20 || llm.FailureRateThreshold != 50 {
+			t.Errorf("Expected the other window settings from the defaults, got %+v", *llm)
+		}
+		if config.CircuitBreaker.Mode != BreakerConsecutive {
+			t.Errorf("Expected the global breaker to stay consecutive, got %s", config.CircuitBreaker.Mode)
+		}
+	})
+
+	t.Run("InvalidWindowSettings", func(t *testing.T) {
+		data := `
+circuit_breaker:
+  mode: count_window
+  window_size: 0
+  minimum_calls: 0
+  failure_rate_threshold: 150
+  slow_call_duration: -1s
+routes:
+  - pattern: /api/users/*
+    target: http://users:8001
+    circuit_breaker:
+      mode: rolling
+`
+
+		_, err := ParseConfig([]byte(data), "yaml")
+		if err == nil {
+			t.Fatal("Expected an error, got nil")
+		}
+
+		for _, expected := range []string{
+			"circuit_breaker.window_size",
+			"circuit_breaker.minimum_calls",
+			"circuit_breaker.failure_rate_threshold",
+			"circuit_breaker.slow_call_duration",
+			`routes[0].circuit_breaker.mode: unknown mode "rolling"`,
+		} {
+			if !strings.Contains(err.Error(), expected) {
+				t.Errorf("Expected error to mention %s, got %v", expected, err)
+			}
+		}
+	})
+}
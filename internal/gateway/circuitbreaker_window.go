@@ -0,0 +1,110 @@
+package gateway
+
+import "time"
+
+// How a circuit breaker decides to open
+const (
+	BreakerConsecutive = "consecutive"  // after failure_threshold failures without a success in between
+	BreakerCountWindow = "count_window" // on the failure or slow call rate of the last window_size calls
+	BreakerTimeWindow  = "time_window"  // on the failure or slow call rate of the calls in the last window_duration
+)
+
+// Outcomes of recent calls for the sliding window modes
+type callWindow interface {
+	record(failed, slow bool, now time.Time)
+	totals(now time.Time) callTotals
+	reset()
+}
+
+type callTotals struct {
+	calls    int
+	failures int
+	slow     int
+}
+
+func (ct *callTotals) add(failed, slow bool, sign int) {
+	ct.calls += sign
+	if failed {
+		ct.failures += sign
+	}
+	if slow {
+		ct.slow += sign
+	}
+}
+
+func (ct callTotals) plus(other callTotals) callTotals {
+	return callTotals{
+		calls:    ct.calls + other.calls,
+		failures: ct.failures + other.failures,
+		slow:     ct.slow + other.slow,
+	}
+}
+
+// Percentage of calls that failed and that were slow
+func (ct callTotals) rates() (float64, float64) {
+	if ct.calls == 0 {
+		return 0, 0
+	}
+	return float64(ct.failures) * 100 / float64(ct.calls), float64(ct.slow) * 100 / float64(ct.calls)
+}
+
+type callOutcome struct {
+	failed bool
+	slow   bool
+}
+
+// The last size calls, in a ring buffer with running totals
+type countWindow struct {
+	outcomes []callOutcome
+	next     int
+	filled   bool
+	current  callTotals
+}
+
+func newCountWindow(size int) *countWindow {
+	return &countWindow{outcomes: make([]callOutcome, size)}
+}
+
+func (cw *countWindow) record(failed, slow bool, now time.Time) {
+	if cw.filled {
+		evicted := cw.outcomes[cw.next]
+		cw.current.add(evicted.failed, evicted.slow, -1)
+	}
+
+	cw.outcomes[cw.next] = callOutcome{failed: failed, slow: slow}
+	cw.current.add(failed, slow, 1)
+
+	cw.next = (cw.next + 1) % len(cw.outcomes)
+	if cw.next == 0 {
+		cw.filled = true
+	}
+}
+
+func (cw *countWindow) totals(now time.Time) callTotals {
+	return cw.current
+}
+
+func (cw *countWindow) reset() {
+	*cw = countWindow{outcomes: make([]callOutcome, len(cw.outcomes))}
+}
+
+// Calls of the last seconds of the window, one bucket per second
+type timeWindow struct {
+	counts *secondsWindow[callTotals]
+}
+
+func newTimeWindow(duration time.Duration) *timeWindow {
+	return &timeWindow{counts: newSecondsWindow[callTotals](duration)}
+}
+
+func (tw *timeWindow) record(failed, slow bool, now time.Time) {
+	tw.counts.bucket(now).add(failed, slow, 1)
+}
+
+func (tw *timeWindow) totals(now time.Time) callTotals {
+	return tw.counts.totals(now)
+}
+
+func (tw *timeWindow) reset() {
+	tw.counts.reset()
+}
@@ -0,0 +1,218 @@
+package gateway
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"sync"
+	"time"
+)
+
+// How a backend's concurrent requests are bounded
+const (
+	LimiterFixed    = "fixed"    // max_concurrent_requests, excess waits in a queue
+	LimiterAdaptive = "adaptive" // a limit that follows the backend's latency, excess is shed
+)
+
+// Bounds the requests in flight to a backend
+type ConcurrencyLimiter interface {
+	TryAcquire(ctx context.Context) error
+	Release()
+	GetStats() map[string]any
+}
+
+// Limiters that adjust to how long the backend takes to answer
+type rttObserver interface {
+	ObserveRTT(rtt time.Duration, dropped bool)
+}
+
+func NewConcurrencyLimiter(config BulkheadConfig) ConcurrencyLimiter {
+	if config.Mode == LimiterAdaptive {
+		return NewAdaptiveLimiter(config)
+	}
+	return NewServiceBulkhead(config)
+}
+
+// Settings a limiter was built with, to tell whether it survives a reload
+func limiterConfig(limiter ConcurrencyLimiter) BulkheadConfig {
+	switch l := limiter.(type) {
+	case *ServiceBulkhead:
+		return l.config
+	case *AdaptiveLimiter:
+		return l.config
+	}
+	return BulkheadConfig{}
+}
+
+type AdaptiveLimitConfig struct {
+	InitialLimit int           `json:"initial_limit"`
+	MinLimit     int           `json:"min_limit"`
+	MaxLimit     int           `json:"max_limit"`
+	MinRTTWindow time.Duration `json:"min_rtt_window"` // how long the lowest latency seen is trusted as the backend's baseline
+}
+
+func DefaultAdaptiveLimitConfig() AdaptiveLimitConfig {
+	return AdaptiveLimitConfig{
+		InitialLimit: 20,
+		MinLimit:     1,
+		MaxLimit:     200,
+		MinRTTWindow: 30 * time.Second,
+	}
+}
+
+func (c *AdaptiveLimitConfig) UnmarshalJSON(data []byte) error {
+	type alias AdaptiveLimitConfig
+	aux := struct {
+		*alias
+		MinRTTWindow Duration `json:"min_rtt_window"`
+	}{
+		alias:        (*alias)(c),
+		MinRTTWindow: Duration(c.MinRTTWindow),
+	}
+
+	if err := decodeStrict(data, &aux); err != nil {
+		return err
+	}
+
+	c.MinRTTWindow = time.Duration(aux.MinRTTWindow)
+	return nil
+}
+
+func (c AdaptiveLimitConfig) MarshalJSON() ([]byte, error) {
+	type alias AdaptiveLimitConfig
+	return json.Marshal(struct {
+		alias
+		MinRTTWindow Duration `json:"min_rtt_window"`
+	}{
+		alias:        alias(c),
+		MinRTTWindow: Duration(c.MinRTTWindow),
+	})
+}
+
+// Returned when an adaptive limiter sheds a request
+type LimitExceededError struct {
+	Limit      int
+	RetryAfter time.Duration // when the client could try again
+}
+
+func (e *LimitExceededError) Error() string {
+	return fmt.Sprintf("concurrency limit of %d reached", e.Limit)
+}
+
+// Vegas-style limiter. The lowest latency seen is taken as the backend's
+// latency without queueing; the amount a response took longer than that tells
+// how many requests are waiting at the backend. Few waiting raises the limit,
+// many waiting or overload responses lower it.
+type AdaptiveLimiter struct {
+	config BulkheadConfig
+
+	mutex    sync.Mutex
+	limit    float64
+	inFlight int
+	shed     int64
+
+	// lowest latency of the current and previous min_rtt_window, so the
+	// baseline follows a backend that got slower for good
+	windowStart time.Time
+	windowMin   time.Duration
+	previousMin time.Duration
+	lastRTT     time.Duration
+}
+
+func NewAdaptiveLimiter(config BulkheadConfig) *AdaptiveLimiter {
+	return &AdaptiveLimiter{
+		config:      config,
+		limit:       float64(config.Adaptive.InitialLimit),
+		windowStart: time.Now(),
+	}
+}
+
+func (al *AdaptiveLimiter) TryAcquire(ctx context.Context) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	al.mutex.Lock()
+	defer al.mutex.Unlock()
+
+	if al.inFlight >= int(al.limit) {
+		al.shed++
+		return &LimitExceededError{Limit: int(al.limit), RetryAfter: max(time.Second, al.minRTT())}
+	}
+	al.inFlight++
+	return nil
+}
+
+func (al *AdaptiveLimiter) Release() {
+	al.mutex.Lock()
+	defer al.mutex.Unlock()
+
+	al.inFlight--
+}
+
+// Adjusts the limit for a response that took rtt. dropped responses are ones
+// where the backend said it is overloaded or didn't answer in time, they only
+// lower the limit. Other failures shouldn't be observed at all.
+func (al *AdaptiveLimiter) ObserveRTT(rtt time.Duration, dropped bool) {
+	al.mutex.Lock()
+	defer al.mutex.Unlock()
+
+	adaptive := al.config.Adaptive
+	step := max(1, math.Log10(al.limit))
+
+	if dropped {
+		al.setLimit(al.limit - step)
+		return
+	}
+
+	now := time.Now()
+	if now.Sub(al.windowStart) >= adaptive.MinRTTWindow {
+		al.previousMin = al.windowMin
+		al.windowMin = 0
+		al.windowStart = now
+	}
+	if al.windowMin == 0 || rtt < al.windowMin {
+		al.windowMin = rtt
+	}
+	al.lastRTT = rtt
+
+	// requests estimated to be queued at the backend
+	queued := al.limit * (1 - float64(al.minRTT())/float64(max(rtt, 1)))
+
+	switch {
+	case queued >= 6*step:
+		al.setLimit(al.limit - step)
+	case queued <= 3*step && al.inFlight*2 >= int(al.limit):
+		// only grow while the limit is actually being used
+		al.setLimit(al.limit + step)
+	}
+}
+
+func (al *AdaptiveLimiter) setLimit(limit float64) {
+	adaptive := al.config.Adaptive
+	al.limit = min(max(limit, float64(adaptive.MinLimit)), float64(adaptive.MaxLimit))
+}
+
+func (al *AdaptiveLimiter) minRTT() time.Duration {
+	if al.previousMin > 0 && (al.windowMin == 0 || al.previousMin < al.windowMin) {
+		return al.previousMin
+	}
+	return al.windowMin
+}
+
+func (al *AdaptiveLimiter) GetStats() map[string]any {
+	al.mutex.Lock()
+	defer al.mutex.Unlock()
+
+	return map[string]any{
+		"mode":      LimiterAdaptive,
+		"limit":     int(al.limit),
+		"in_flight": al.inFlight,
+		"min_rtt":   al.minRTT().String(),
+		"last_rtt":  al.lastRTT.String(),
+		"shed":      al.shed,
+		"min_limit": al.config.Adaptive.MinLimit,
+		"max_limit": al.config.Adaptive.MaxLimit,
+	}
+}
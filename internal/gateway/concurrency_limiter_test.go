@@ -0,0 +1,208 @@
+package gateway
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAdaptiveLimiter(t *testing.T) {
+	adaptiveConfig := func(initial, minLimit, maxLimit int) BulkheadConfig {
+		config := DefaultBulkheadConfig()
+		config.Mode = LimiterAdaptive
+		config.Adaptive = AdaptiveLimitConfig{InitialLimit: initial, MinLimit: minLimit, MaxLimit: maxLimit, MinRTTWindow: time.Minute}
+		return config
+	}
+
+	// holds the whole limit so samples count as the limit being used
+	fill := func(t *testing.T, limiter *AdaptiveLimiter, n int) {
+		for i := 0; i < n; i++ {
+			if err := limiter.TryAcquire(context.Background()); err != nil {
+				t.Fatalf("Expected slot %d to be granted, got %v", i+1, err)
+			}
+		}
+	}
+
+	t.Run("GrowsWhileLatencyIsFlat", func(t *testing.T) {
+		limiter := NewAdaptiveLimiter(adaptiveConfig(10, 1, 100))
+		fill(t, limiter, 10)
+
+		for i := 0; i < 20; i++ {
+			limiter.ObserveRTT(10*time.Millisecond, false)
+		}
+
+		if stats := limiter.GetStats(); stats["limit"].(int) <= 10 || stats["min_rtt"] != "10ms" {
+			t.Errorf("Expected the limit to grow above 10 with a 10ms min RTT, got %v", stats)
+		}
+	})
+
+	t.Run("DoesNotGrowWhenIdle", func(t *testing.T) {
+		limiter := NewAdaptiveLimiter(adaptiveConfig(10, 1, 100))
+		fill(t, limiter, 1)
+
+		for i := 0; i < 20; i++ {
+			limiter.ObserveRTT(10*time.Millisecond, false)
+		}
+
+		if stats := limiter.GetStats(); stats["limit"] != 10 {
+			t.Errorf("Expected the limit to stay at 10 while mostly unused, got %v", stats)
+		}
+	})
+
+	t.Run("ShrinksWhenLatencyRises", func(t *testing.T) {
+		limiter := NewAdaptiveLimiter(adaptiveConfig(50, 5, 100))
+		limiter.ObserveRTT(10*time.Millisecond, false)
+
+		for i := 0; i < 50; i++ {
+			limiter.ObserveRTT(100*time.Millisecond, false)
+		}
+
+		// settles where the queue estimate stops calling for less
+		if stats := limiter.GetStats(); stats["limit"].(int) > 10 {
+			t.Errorf("Expected the limit to fall from 50 to 10 or less, got %v", stats)
+		}
+	})
+
+	t.Run("ShrinksOnOverload", func(t *testing.T) {
+		limiter := NewAdaptiveLimiter(adaptiveConfig(10, 1, 100))
+		for i := 0; i < 3; i++ {
+			limiter.ObserveRTT(time.Millisecond, true)
+		}
+
+		if stats := limiter.GetStats(); stats["limit"] != 7 {
+			t.Errorf("Expected 3 dropped responses to take the limit to 7, got %v", stats)
+		}
+	})
+
+	t.Run("ShedsOverTheLimit", func(t *testing.T) {
+		limiter := NewAdaptiveLimiter(adaptiveConfig(2, 1, 10))
+		fill(t, limiter, 2)
+
+		var shed *LimitExceededError
+		if err := limiter.TryAcquire(context.Background()); !errors.As(err, &shed) || shed.RetryAfter < time.Second {
+			t.Fatalf("Expected the third request to be shed with a Retry-After, got %v", err)
+		}
+
+		limiter.Release()
+		if err := limiter.TryAcquire(context.Background()); err != nil {
+			t.Errorf("Expected a released slot to be granted again, got %v", err)
+		}
+		if stats := limiter.GetStats(); stats["shed"] != int64(1) || stats["in_flight"] != 2 {
+			t.Errorf("Expected 1 shed and 2 in flight, got %v", stats)
+		}
+	})
+
+	t.Run("ProxyShedsWith503", func(t *testing.T) {
+		started := make(chan struct{}, 1)
+		backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			started <- struct{}{}
+			time.Sleep(200 * time.Millisecond)
+		}))
+		defer backend.Close()
+
+		bulkhead := adaptiveConfig(1, 1, 1)
+		retry := DefaultRetryConfig()
+		retry.MaxAttempts = 1
+		proxy := NewProxy(testConfig(Route{Pattern: "/api/*", Target: backend.URL, Bulkhead: &bulkhead, Retry: &retry}), DefaultTimeoutConfig())
+
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			proxy.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/api/items", nil))
+		}()
+		<-started
+
+		w := httptest.NewRecorder()
+		proxy.ServeHTTP(w, httptest.NewRequest("GET", "/api/items", nil))
+		<-done
+
+		if w.Code != http.StatusServiceUnavailable || w.Header().Get("Retry-After") != "1" {
+			t.Errorf("Expected a 503 with Retry-After 1, got %d %q", w.Code, w.Header().Get("Retry-After"))
+		}
+	})
+
+	t.Run("FailuresDontSetMinRTT", func(t *testing.T) {
+		closed := httptest.NewServer(http.NotFoundHandler())
+		closed.Close()
+		notFound := httptest.NewServer(http.NotFoundHandler())
+		defer notFound.Close()
+
+		for _, backend := range []*httptest.Server{closed, notFound} {
+			bulkhead := adaptiveConfig(10, 1, 100)
+			retry := DefaultRetryConfig()
+			retry.MaxAttempts = 1
+			route := Route{Pattern: "/api/*", Target: backend.URL, Bulkhead: &bulkhead, Retry: &retry}
+			proxy := NewProxy(testConfig(route), DefaultTimeoutConfig())
+
+			proxy.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/api/items", nil))
+
+			snapshot := proxy.snapshot.Load()
+			limiter := snapshot.bulkheads[backendStateKey(&snapshot.config.Routes[0], backend.URL)]
+			if stats := limiter.GetStats(); stats["min_rtt"] != "0s" || stats["limit"] != 10 {
+				t.Errorf("Expected a failed response to leave the limiter alone, got %v", stats)
+			}
+		}
+	})
+
+	t.Run("ParsesAdaptiveMode", func(t *testing.T) {
+		data := `
+routes:
+  - pattern: /api/*
+    target: http://api:8000
+    bulkhead:
+      mode: adaptive
+      adaptive:
+        max_limit: 50
+        min_rtt_window: 10s
+`
+
+		config, err := ParseConfig([]byte(data), "yaml")
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+
+		adaptive := config.Routes[0].Bulkhead.Adaptive
+		if adaptive.MaxLimit != 50 || adaptive.MinRTTWindow != 10*time.Second || adaptive.InitialLimit != 20 {
+			t.Errorf("Expected the adaptive block merged over the defaults, got %+v", adaptive)
+		}
+
+		snapshot := NewProxy(config, DefaultTimeoutConfig()).snapshot.Load()
+		if _, ok := snapshot.bulkheads[backendStateKey(&config.Routes[0], "http://api:8000")].(*AdaptiveLimiter); !ok {
+			t.Errorf("Expected an adaptive limiter for the backend")
+		}
+	})
+
+	t.Run("InvalidAdaptiveMode", func(t *testing.T) {
+		data := `
+bulkhead:
+  mode: adaptive
+  adaptive:
+    min_limit: 10
+    max_limit: 5
+routes:
+  - pattern: /api/*
+    target: http://api:8000
+    bulkhead:
+      mode: elastic
+`
+
+		_, err := ParseConfig([]byte(data), "yaml")
+		if err == nil {
+			t.Fatal("Expected an error, got nil")
+		}
+
+		for _, expected := range []string{
+			"bulkhead.adaptive.max_limit",
+			"bulkhead.adaptive.initial_limit",
+			`routes[0].bulkhead.mode: unknown mode "elastic"`,
+		} {
+			if !strings.Contains(err.Error(), expected) {
+				t.Errorf("Expected error to mention %s, got %v", expected, err)
+			}
+		}
+	})
+}
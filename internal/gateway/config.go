@@ -1,8 +1,10 @@
 package gateway
 
 import (
-	"fmt"
+	"net/http"
+	"net/url"
 	"strings"
+	"sync"
 )
 
 type LoadBalancerStrategy string
@@ -11,13 +13,21 @@ const (
 	RoundRobin       LoadBalancerStrategy = "round_robin"
 	LeastConnections LoadBalancerStrategy = "least_connections"
 	Random           LoadBalancerStrategy = "random"
+	WeightedRandom   LoadBalancerStrategy = "weighted_random"
+
+	WeightedRoundRobin LoadBalancerStrategy = "weighted_round_robin" // smooth weighted round-robin
+	LeastRequest       LoadBalancerStrategy = "least_request"        // power of two choices on in-flight requests
+	RingHash           LoadBalancerStrategy = "ring_hash"            // consistent hashing on the route's hash_on key
+	Maglev             LoadBalancerStrategy = "maglev"               // like ring_hash, with a lookup table instead of a ring
+	PeakEWMA           LoadBalancerStrategy = "peak_ewma"            // lowest expected latency times requests in flight
 )
 
 type Backend struct {
-	URL            string          `json:"url"`
-	Healthy        bool            `json:"healthy"`
-	Weight         int             `json:"weight"`
-	CircuitBreaker *CircuitBreaker `json:"_"`
+	URL            string            `json:"url"`
+	Healthy        bool              `json:"healthy"`
+	Weight         int               `json:"weight"`
+	Metadata       map[string]string `json:"metadata"` // matched by route subsets, e.g. version: v2
+	CircuitBreaker *CircuitBreaker   `json:"-"`
 }
 
 type Route struct {
@@ -26,10 +36,40 @@ type Route struct {
 	Backends     []Backend            `json:"backends"`
 	LoadBalancer LoadBalancerStrategy `json:"load_balancer"`
 	StripPrefix  string               `json:"strip_prefix"`
+	Rewrite      string               `json:"rewrite"` // path template using matched params, e.g. /v2/users/{id}
+	Discovery    DiscoveryMode        `json:"discovery"`
+	Split        *TrafficSplit        `json:"split"`  // replaces target/backends with weighted backend groups
+	Mirror       *MirrorConfig        `json:"mirror"` // copies requests to a shadow backend
+	Subset       *SubsetConfig        `json:"subset"` // balances only across backends with matching metadata
+
+	OutlierDetection *OutlierDetectionConfig `json:"outlier_detection"` // ejects backends that misbehave under live traffic
+	HashOn           *HashOnConfig           `json:"hash_on"`           // session affinity key for ring_hash and maglev
+	HealthCheck      *HealthCheckSpec        `json:"health_check"`      // how the health checker probes the declared backends
+	SlowStart        Duration                `json:"slow_start"`        // ramp-up window for backends recovering from unhealthy, not applied to hash-based strategies
+	Hedge            *HedgeConfig            `json:"hedge"`             // duplicates slow requests to a second backend
+
+	// Optional predicates, a route only matches when all of them hold
+	Methods []string          `json:"methods"`
+	Host    string            `json:"host"`    // exact host or *.example.com
+	Headers map[string]string `json:"headers"` // header name -> value, "*" means any value
+	Query   map[string]string `json:"query"`   // query param -> value, "*" means any value
+
+	// Optional resilience overrides, fields left out fall back to the global settings
+	Timeouts       *TimeoutConfig        `json:"timeouts"`
+	Retry          *RetryConfig          `json:"retry"`
+	CircuitBreaker *CircuitBreakerConfig `json:"circuit_breaker"`
+	Bulkhead       *BulkheadConfig       `json:"bulkhead"`
 }
 
 type GatewayConfig struct {
-	Routes []Route `json:"routes"`
+	Routes         []Route              `json:"routes"`
+	Timeouts       TimeoutConfig        `json:"timeouts"`
+	CircuitBreaker CircuitBreakerConfig `json:"circuit_breaker"`
+	Bulkhead       BulkheadConfig       `json:"bulkhead"`
+	Retry          RetryConfig          `json:"retry"`
+
+	table     *routeTable // compiled from Routes on first match
+	tableOnce sync.Once
 }
 
 func DefaultConfig() *GatewayConfig {
@@ -89,6 +129,10 @@ func DefaultConfig() *GatewayConfig {
 				Target:  "http://localhost:8080",
 			},
 		},
+		Timeouts:       DefaultTimeoutConfig(),
+		CircuitBreaker: cbConfig,
+		Bulkhead:       DefaultBulkheadConfig(),
+		Retry:          DefaultRetryConfig(),
 	}
 }
 
@@ -106,23 +150,46 @@ func LocalhostConfig() *GatewayConfig {
 	return config
 }
 
-// Finds matching route for a given path
+// Finds the most specific route matching the request's path and predicates
+func (gc *GatewayConfig) MatchRequest(r *http.Request) (*RouteMatch, error) {
+	return gc.routeTable().match(r)
+}
+
+// Finds matching route for a given path, treated as a GET request without headers
 func (gc *GatewayConfig) MatchRoute(path string) (*Route, error) {
-	for _, route := range gc.Routes {
-		if matchesPattern(route.Pattern, path) {
-			return &route, nil
-		}
+	r := &http.Request{Method: http.MethodGet, URL: &url.URL{Path: path}, Header: http.Header{}}
+
+	match, err := gc.MatchRequest(r)
+	if err != nil {
+		return nil, err
 	}
-	return nil, fmt.Errorf("no matching route for path: %s", path)
+	return match.Route, nil
 }
 
-func matchesPattern(pattern, path string) bool {
-	// TODO: improve pattern matching (e.g., support more complex patterns)
-	if strings.HasSuffix(pattern, "/*") {
-		prefix := strings.TrimSuffix(pattern, "/*")
-		return strings.HasPrefix(path, prefix)
+func (gc *GatewayConfig) routeTable() *routeTable {
+	gc.tableOnce.Do(func() {
+		gc.table = newRouteTable(gc.Routes)
+	})
+	return gc.table
+}
+
+// Every backend declared on the route, including those in traffic split groups
+func (r *Route) declaredBackends() []*Backend {
+	backends := make([]*Backend, 0, len(r.Backends))
+	for i := range r.Backends {
+		backends = append(backends, &r.Backends[i])
 	}
-	return pattern == path
+
+	if r.Split != nil {
+		for i := range r.Split.Groups {
+			group := &r.Split.Groups[i]
+			for j := range group.Backends {
+				backends = append(backends, &group.Backends[j])
+			}
+		}
+	}
+
+	return backends
 }
 
 func (r *Route) GetBackends() []Backend {
@@ -0,0 +1,684 @@
+package gateway
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Duration decodes a Go duration string ("5s") from config files.
+// Bare numbers are rejected since "timeout: 30" would silently mean 30ns.
+type Duration time.Duration
+
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var value string
+	if err := json.Unmarshal(data, &value); err != nil {
+		return fmt.Errorf("invalid duration %s: expected a string like \"5s\"", string(data))
+	}
+
+	parsed, err := time.ParseDuration(value)
+	if err != nil {
+		return fmt.Errorf("invalid duration %q: %w", value, err)
+	}
+
+	*d = Duration(parsed)
+	return nil
+}
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+// Backends declared in a config file are healthy with weight 1 unless stated otherwise
+func (b *Backend) UnmarshalJSON(data []byte) error {
+	type alias Backend
+	aux := alias{Healthy: true, Weight: 1}
+
+	if err := decodeStrict(data, &aux); err != nil {
+		return err
+	}
+
+	*b = Backend(aux)
+	return nil
+}
+
+// Loads a gateway config from a YAML (.yaml/.yml) or JSON (.json) file.
+// Settings missing from the file fall back to the package defaults.
+func LoadConfig(path string) (*GatewayConfig, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading config file: %w", err)
+	}
+
+	format := strings.TrimPrefix(strings.ToLower(filepath.Ext(path)), ".")
+
+	config, err := ParseConfig(data, format)
+	if err != nil {
+		return nil, fmt.Errorf("error loading config %s: %w", path, err)
+	}
+
+	return config, nil
+}
+
+func ParseConfig(data []byte, format string) (*GatewayConfig, error) {
+	switch format {
+	case "yaml", "yml":
+		converted, err := yamlToJSON(data)
+		if err != nil {
+			return nil, err
+		}
+		data = converted
+	case "json":
+	default:
+		return nil, fmt.Errorf("unsupported config format %q (expected yaml or json)", format)
+	}
+
+	config := &GatewayConfig{
+		Timeouts:       DefaultTimeoutConfig(),
+		CircuitBreaker: DefaultCircuitBreakerConfig(),
+		Bulkhead:       DefaultBulkheadConfig(),
+		Retry:          DefaultRetryConfig(),
+	}
+
+	if err := decodeStrict(data, config); err != nil {
+		return nil, fmt.Errorf("error decoding config: %w", err)
+	}
+
+	if err := config.mergeRoutePolicies(data); err != nil {
+		return nil, fmt.Errorf("error decoding config: %w", err)
+	}
+
+	config.applyDefaults()
+
+	if err := config.validate(); err != nil {
+		return nil, err
+	}
+
+	config.normalizeBackends()
+	return config, nil
+}
+
+func (gc *GatewayConfig) applyDefaults() {
+	for i := range gc.Routes {
+		if gc.Routes[i].LoadBalancer == "" {
+			gc.Routes[i].LoadBalancer = RoundRobin
+			// canary groups are usually sized by weight
+			if gc.Routes[i].Split != nil {
+				gc.Routes[i].LoadBalancer = WeightedRoundRobin
+			}
+		}
+		if gc.Routes[i].Discovery == "" {
+			gc.Routes[i].Discovery = DiscoveryMerge
+		}
+	}
+}
+
+func (gc *GatewayConfig) normalizeBackends() {
+	for i := range gc.Routes {
+		route := &gc.Routes[i]
+
+		// single target routes become a one-backend pool so they get a circuit breaker too
+		if route.Target != "" && len(route.Backends) == 0 {
+			route.Backends = []Backend{{URL: route.Target, Healthy: true, Weight: 1}}
+		}
+
+		breakerConfig := gc.CircuitBreaker
+		if route.CircuitBreaker != nil {
+			breakerConfig = *route.CircuitBreaker
+		}
+
+		for _, backend := range route.declaredBackends() {
+			if backend.CircuitBreaker == nil {
+				backend.CircuitBreaker = NewCircuitBreaker(breakerConfig)
+			}
+		}
+	}
+}
+
+// reports every problem found in the config, not just the first one
+func (gc *GatewayConfig) validate() error {
+	var errs []error
+
+	if len(gc.Routes) == 0 {
+		errs = append(errs, errors.New("routes: at least one route is required"))
+	}
+
+	seen := make(map[string]int)
+	compiled := make([]*compiledRoute, len(gc.Routes))
+	for i := range gc.Routes {
+		route := &gc.Routes[i]
+		field := fmt.Sprintf("routes[%d]", i)
+
+		if route.Pattern == "" {
+			errs = append(errs, fmt.Errorf("%s.pattern: is required", field))
+		} else if c, err := compileRoute(route, i); err != nil {
+			errs = append(errs, fmt.Errorf("%s.pattern: %w", field, err))
+		} else {
+			compiled[i] = c
+		}
+
+		if first, exists := seen[route.key()]; exists {
+			errs = append(errs, fmt.Errorf("%s: duplicate pattern %q (already used by routes[%d])", field, route.Pattern, first))
+		} else {
+			seen[route.key()] = i
+		}
+
+		for j := 0; j < i && compiled[i] != nil; j++ {
+			if compiled[j] == nil || gc.Routes[j].key() == route.key() {
+				continue
+			}
+			if sameShape(compiled[j], compiled[i]) && gc.Routes[j].predicateKey() == route.predicateKey() {
+				errs = append(errs, fmt.Errorf("%s: pattern %q is unreachable, it matches the same requests as routes[%d] (%q) which takes precedence", field, route.Pattern, j, gc.Routes[j].Pattern))
+				break
+			}
+		}
+
+		if route.Rewrite != "" && compiled[i] != nil {
+			errs = append(errs, validateRewrite(field, route.Rewrite, compiled[i])...)
+		}
+
+		for j, method := range route.Methods {
+			if method == "" || strings.ContainsAny(method, " \t/") {
+				errs = append(errs, fmt.Errorf("%s.methods[%d]: invalid method %q", field, j, method))
+			}
+		}
+
+		if route.Target != "" && len(route.Backends) > 0 {
+			errs = append(errs, fmt.Errorf("%s: target and backends are mutually exclusive", field))
+		}
+
+		if route.Split != nil {
+			if route.Target != "" || len(route.Backends) > 0 {
+				errs = append(errs, fmt.Errorf("%s: split replaces target and backends, they can't be combined", field))
+			}
+			errs = append(errs, validateSplit(field+".split", route.Split)...)
+
+			if isKnownStrategy(route.LoadBalancer) && !honorsWeights(route.LoadBalancer) && route.Split.weighted() {
+				errs = append(errs, fmt.Errorf("%s.load_balancer: %s ignores the weights of split backends, use a weighted strategy or give a group's backends equal weights", field, route.LoadBalancer))
+			}
+		} else if route.Target == "" && len(route.Backends) == 0 && route.Discovery != DiscoveryRegistry {
+			errs = append(errs, fmt.Errorf("%s: either target or backends is required unless discovery is %q", field, DiscoveryRegistry))
+		}
+
+		if !isKnownDiscoveryMode(route.Discovery) {
+			errs = append(errs, fmt.Errorf("%s.discovery: unknown mode %q (expected static, merge or registry)", field, route.Discovery))
+		}
+
+		if route.Target != "" {
+			if err := validateBackendURL(route.Target); err != nil {
+				errs = append(errs, fmt.Errorf("%s.target: %w", field, err))
+			}
+		}
+
+		errs = append(errs, validateBackends(field+".backends", route.Backends)...)
+
+		if !isKnownStrategy(route.LoadBalancer) {
+			errs = append(errs, fmt.Errorf("%s.load_balancer: unknown strategy %q (expected one of %s)", field, route.LoadBalancer, strings.Join(knownStrategyNames(), ", ")))
+		}
+
+		if route.StripPrefix != "" && !strings.HasPrefix(route.StripPrefix, "/") {
+			errs = append(errs, fmt.Errorf("%s.strip_prefix: must start with \"/\", got %q", field, route.StripPrefix))
+		}
+
+		if route.Mirror != nil {
+			errs = append(errs, validateMirror(field+".mirror", *route.Mirror)...)
+		}
+
+		if route.Subset != nil {
+			errs = append(errs, validateSubset(field+".subset", route.Subset)...)
+		}
+
+		if route.HashOn != nil {
+			errs = append(errs, validateHashOn(field+".hash_on", route.HashOn, compiled[i])...)
+		} else if pinsKeys(route.LoadBalancer) {
+			errs = append(errs, fmt.Errorf("%s.hash_on: is required for load_balancer %s", field, route.LoadBalancer))
+		}
+
+		if route.OutlierDetection != nil {
+			errs = append(errs, validateOutlierDetection(field+".outlier_detection", *route.OutlierDetection)...)
+		}
+
+		if route.Hedge != nil {
+			errs = append(errs, validateHedge(field+".hedge", *route.Hedge)...)
+		}
+
+		if err := route.HealthCheck.Validate(); err != nil {
+			errs = append(errs, fmt.Errorf("%s.health_check.%w", field, err))
+		}
+
+		if route.SlowStart < 0 {
+			errs = append(errs, fmt.Errorf("%s.slow_start: must not be negative", field))
+		}
+
+		if route.Timeouts != nil {
+			errs = append(errs, validateTimeouts(field+".timeouts", *route.Timeouts)...)
+		}
+		if route.CircuitBreaker != nil {
+			errs = append(errs, validateCircuitBreaker(field+".circuit_breaker", *route.CircuitBreaker)...)
+		}
+		if route.Bulkhead != nil {
+			errs = append(errs, validateBulkhead(field+".bulkhead", *route.Bulkhead)...)
+		}
+		if route.Retry != nil {
+			errs = append(errs, validateRetry(field+".retry", *route.Retry)...)
+		}
+	}
+
+	errs = append(errs, validateTimeouts("timeouts", gc.Timeouts)...)
+	errs = append(errs, validateCircuitBreaker("circuit_breaker", gc.CircuitBreaker)...)
+	errs = append(errs, validateBulkhead("bulkhead", gc.Bulkhead)...)
+	errs = append(errs, validateRetry("retry", gc.Retry)...)
+
+	return errors.Join(errs...)
+}
+
+func validateBackends(field string, backends []Backend) []error {
+	var errs []error
+	for i, backend := range backends {
+		backendField := fmt.Sprintf("%s[%d]", field, i)
+		if err := validateBackendURL(backend.URL); err != nil {
+			errs = append(errs, fmt.Errorf("%s.url: %w", backendField, err))
+		}
+		if backend.Weight < 0 {
+			errs = append(errs, fmt.Errorf("%s.weight: must not be negative, got %d", backendField, backend.Weight))
+		}
+	}
+	return errs
+}
+
+func validateSplit(field string, split *TrafficSplit) []error {
+	if len(split.Groups) == 0 {
+		return []error{fmt.Errorf("%s.groups: at least one group is required", field)}
+	}
+
+	var errs []error
+	names := make(map[string]bool)
+	total := 0
+	for i, group := range split.Groups {
+		groupField := fmt.Sprintf("%s.groups[%d]", field, i)
+
+		if group.Name == "" {
+			errs = append(errs, fmt.Errorf("%s.name: is required", groupField))
+		} else if names[group.Name] {
+			errs = append(errs, fmt.Errorf("%s.name: duplicate group %q", groupField, group.Name))
+		}
+		names[group.Name] = true
+
+		if group.Percent < 0 || group.Percent > 100 {
+			errs = append(errs, fmt.Errorf("%s.percent: must be between 0 and 100, got %d", groupField, group.Percent))
+		}
+		total += group.Percent
+
+		if len(group.Backends) == 0 {
+			errs = append(errs, fmt.Errorf("%s.backends: at least one backend is required", groupField))
+		}
+		errs = append(errs, validateBackends(groupField+".backends", group.Backends)...)
+	}
+
+	if total != 100 {
+		errs = append(errs, fmt.Errorf("%s.groups: percentages must add up to 100, got %d", field, total))
+	}
+
+	return errs
+}
+
+func validateRewrite(field, rewrite string, compiled *compiledRoute) []error {
+	if !strings.HasPrefix(rewrite, "/") {
+		return []error{fmt.Errorf("%s.rewrite: must start with \"/\", got %q", field, rewrite)}
+	}
+
+	known := make(map[string]bool)
+	for _, segment := range compiled.segments {
+		if segment.name != "" {
+			known[segment.name] = true
+		}
+	}
+	if compiled.wildcard {
+		known["*"] = true
+	}
+
+	var errs []error
+	for _, name := range rewriteParams(rewrite) {
+		if !known[name] {
+			errs = append(errs, fmt.Errorf("%s.rewrite: unknown parameter {%s} in %q", field, name, rewrite))
+		}
+	}
+	return errs
+}
+
+func validateBackendURL(raw string) error {
+	parsed, err := url.Parse(raw)
+	if err != nil {
+		return fmt.Errorf("malformed URL %q: %w", raw, err)
+	}
+
+	if parsed.Scheme != "http" && parsed.Scheme != "https" {
+		return fmt.Errorf("malformed URL %q: scheme must be http or https", raw)
+	}
+
+	if parsed.Host == "" {
+		return fmt.Errorf("malformed URL %q: missing host", raw)
+	}
+
+	return nil
+}
+
+func validateTimeouts(field string, tc TimeoutConfig) []error {
+	var errs []error
+	if tc.RequestTimeout <= 0 {
+		errs = append(errs, fmt.Errorf("%s.request_timeout: must be positive", field))
+	}
+	if tc.BackendTimeout <= 0 {
+		errs = append(errs, fmt.Errorf("%s.backend_timeout: must be positive", field))
+	}
+	if tc.ConnectTimeout < 0 {
+		errs = append(errs, fmt.Errorf("%s.connect_timeout: must not be negative", field))
+	}
+	if tc.BackendTimeout > tc.RequestTimeout {
+		errs = append(errs, fmt.Errorf("%s.backend_timeout: must not be longer than request_timeout (%v), got %v", field, tc.RequestTimeout, tc.BackendTimeout))
+	}
+	return errs
+}
+
+func validateCircuitBreaker(field string, cb CircuitBreakerConfig) []error {
+	var errs []error
+	if cb.FailureThreshold < 1 {
+		errs = append(errs, fmt.Errorf("%s.failure_threshold: must be at least 1", field))
+	}
+	if cb.SuccessThreshold < 1 {
+		errs = append(errs, fmt.Errorf("%s.success_threshold: must be at least 1", field))
+	}
+	if cb.Timeout <= 0 {
+		errs = append(errs, fmt.Errorf("%s.timeout: must be positive", field))
+	}
+	if cb.MaxRequests < 1 {
+		errs = append(errs, fmt.Errorf("%s.max_requests: must be at least 1", field))
+	}
+
+	switch cb.Mode {
+	case "", BreakerConsecutive:
+		return errs
+	case BreakerCountWindow:
+		if cb.WindowSize < 1 {
+			errs = append(errs, fmt.Errorf("%s.window_size: must be at least 1", field))
+		}
+	case BreakerTimeWindow:
+		if cb.WindowDuration < time.Second {
+			errs = append(errs, fmt.Errorf("%s.window_duration: must be at least 1s", field))
+		}
+	default:
+		return append(errs, fmt.Errorf("%s.mode: unknown mode %q", field, cb.Mode))
+	}
+
+	if cb.MinimumCalls < 1 {
+		errs = append(errs, fmt.Errorf("%s.minimum_calls: must be at least 1", field))
+	}
+	if cb.FailureRateThreshold <= 0 || cb.FailureRateThreshold > 100 {
+		errs = append(errs, fmt.Errorf("%s.failure_rate_threshold: must be above 0 and at most 100, got %v", field, cb.FailureRateThreshold))
+	}
+	if cb.SlowCallRateThreshold <= 0 || cb.SlowCallRateThreshold > 100 {
+		errs = append(errs, fmt.Errorf("%s.slow_call_rate_threshold: must be above 0 and at most 100, got %v", field, cb.SlowCallRateThreshold))
+	}
+	if cb.SlowCallDuration < 0 {
+		errs = append(errs, fmt.Errorf("%s.slow_call_duration: must not be negative", field))
+	}
+	return errs
+}
+
+func validateBulkhead(field string, bh BulkheadConfig) []error {
+	var errs []error
+	switch bh.Mode {
+	case "", LimiterFixed:
+	case LimiterAdaptive:
+		errs = append(errs, validateAdaptiveLimit(field+".adaptive", bh.Adaptive)...)
+	default:
+		errs = append(errs, fmt.Errorf("%s.mode: unknown mode %q (expected fixed or adaptive)", field, bh.Mode))
+	}
+
+	if bh.MaxConcurrentRequests < 1 {
+		errs = append(errs, fmt.Errorf("%s.max_concurrent_requests: must be at least 1", field))
+	}
+	if bh.QueueSize < 0 {
+		errs = append(errs, fmt.Errorf("%s.queue_size: must not be negative", field))
+	}
+	if bh.QueueTimeout <= 0 {
+		errs = append(errs, fmt.Errorf("%s.queue_timeout: must be positive", field))
+	}
+	return errs
+}
+
+func validateAdaptiveLimit(field string, al AdaptiveLimitConfig) []error {
+	var errs []error
+	if al.MinLimit < 1 {
+		errs = append(errs, fmt.Errorf("%s.min_limit: must be at least 1", field))
+	}
+	if al.MaxLimit < al.MinLimit {
+		errs = append(errs, fmt.Errorf("%s.max_limit: must not be less than min_limit", field))
+	}
+	if al.InitialLimit < al.MinLimit || al.InitialLimit > al.MaxLimit {
+		errs = append(errs, fmt.Errorf("%s.initial_limit: must be between min_limit and max_limit, got %d", field, al.InitialLimit))
+	}
+	if al.MinRTTWindow < time.Second {
+		errs = append(errs, fmt.Errorf("%s.min_rtt_window: must be at least 1s", field))
+	}
+	return errs
+}
+
+func validateRetry(field string, rc RetryConfig) []error {
+	var errs []error
+	if rc.MaxAttempts < 1 {
+		errs = append(errs, fmt.Errorf("%s.max_attempts: must be at least 1", field))
+	}
+	if rc.InitialDelay < 0 {
+		errs = append(errs, fmt.Errorf("%s.initial_delay: must not be negative", field))
+	}
+	if rc.MaxDelay < rc.InitialDelay {
+		errs = append(errs, fmt.Errorf("%s.max_delay: must not be less than initial_delay", field))
+	}
+	if rc.Multiplier < 1 {
+		errs = append(errs, fmt.Errorf("%s.multiplier: must be at least 1", field))
+	}
+	for i, method := range rc.Methods {
+		if method == "" || strings.ContainsAny(method, " \t/") {
+			errs = append(errs, fmt.Errorf("%s.methods[%d]: invalid method %q", field, i, method))
+		}
+	}
+
+	if rc.MaxMemoryBody < 0 {
+		errs = append(errs, fmt.Errorf("%s.max_memory_body: must not be negative", field))
+	}
+	if rc.MaxReplayBody < rc.MaxMemoryBody {
+		errs = append(errs, fmt.Errorf("%s.max_replay_body: must not be less than max_memory_body", field))
+	}
+	if strings.ContainsAny(rc.IdempotencyKeyHeader, " \t:") {
+		errs = append(errs, fmt.Errorf("%s.idempotency_key_header: invalid header name %q", field, rc.IdempotencyKeyHeader))
+	}
+
+	for i, status := range rc.RetryOn {
+		if status < 400 || status > 599 {
+			errs = append(errs, fmt.Errorf("%s.retry_on[%d]: must be an error status between 400 and 599, got %d", field, i, status))
+		}
+	}
+	for i, class := range rc.RetryOnErrors {
+		if !slices.Contains(ErrorClasses, class) {
+			errs = append(errs, fmt.Errorf("%s.retry_on_errors[%d]: unknown error class %q (expected one of %s)", field, i, class, strings.Join(ErrorClasses, ", ")))
+		}
+	}
+	if rc.PerTryTimeout < 0 {
+		errs = append(errs, fmt.Errorf("%s.per_try_timeout: must not be negative", field))
+	}
+
+	budget := rc.Budget
+	if budget.Percent < 0 || budget.Percent > 100 {
+		errs = append(errs, fmt.Errorf("%s.budget.percent: must be between 0 and 100, got %v", field, budget.Percent))
+	}
+	if budget.MinRetriesPerSecond < 0 {
+		errs = append(errs, fmt.Errorf("%s.budget.min_retries_per_second: must not be negative", field))
+	}
+	if budget.enabled() && budget.Window < time.Second {
+		errs = append(errs, fmt.Errorf("%s.budget.window: must be at least 1s", field))
+	}
+	return errs
+}
+
+func validateSubset(field string, sc *SubsetConfig) []error {
+	var errs []error
+	if len(sc.Selector) == 0 && len(sc.Request) == 0 {
+		errs = append(errs, fmt.Errorf("%s: needs a selector or request selectors", field))
+	}
+
+	for i, source := range sc.Request {
+		sourceField := fmt.Sprintf("%s.request[%d]", field, i)
+		if (source.Header == "") == (source.Claim == "") {
+			errs = append(errs, fmt.Errorf("%s: exactly one of header or claim is required", sourceField))
+		}
+		if source.Key == "" {
+			errs = append(errs, fmt.Errorf("%s.key: is required", sourceField))
+		}
+		if source.Value != "" && !strings.Contains(source.Value, "{value}") {
+			errs = append(errs, fmt.Errorf("%s.value: template must contain {value}, got %q", sourceField, source.Value))
+		}
+	}
+
+	return errs
+}
+
+func validateHashOn(field string, hc *HashOnConfig, compiled *compiledRoute) []error {
+	sources := 0
+	for _, set := range []bool{hc.Header != "", hc.Cookie != "", hc.Claim != "", hc.Param != "", hc.ClientIP} {
+		if set {
+			sources++
+		}
+	}
+	if sources != 1 {
+		return []error{fmt.Errorf("%s: exactly one of header, cookie, claim, param or client_ip is required", field)}
+	}
+
+	if hc.Param != "" && compiled != nil {
+		for _, segment := range compiled.segments {
+			if segment.name == hc.Param {
+				return nil
+			}
+		}
+		if !(hc.Param == "*" && compiled.wildcard) {
+			return []error{fmt.Errorf("%s.param: unknown parameter %q in pattern %q", field, hc.Param, compiled.route.Pattern)}
+		}
+	}
+	return nil
+}
+
+func validateOutlierDetection(field string, od OutlierDetectionConfig) []error {
+	var errs []error
+	if od.Consecutive5xx < 0 {
+		errs = append(errs, fmt.Errorf("%s.consecutive_5xx: must not be negative", field))
+	}
+	if od.ConsecutiveGatewayErrors < 0 {
+		errs = append(errs, fmt.Errorf("%s.consecutive_gateway_errors: must not be negative", field))
+	}
+	if od.LatencyFactor != 0 && od.LatencyFactor <= 1 {
+		errs = append(errs, fmt.Errorf("%s.latency_factor: must be greater than 1, or 0 to disable", field))
+	}
+	if od.MinRequests < 1 {
+		errs = append(errs, fmt.Errorf("%s.min_requests: must be at least 1", field))
+	}
+	if od.BaseEjectionTime <= 0 {
+		errs = append(errs, fmt.Errorf("%s.base_ejection_time: must be positive", field))
+	}
+	if od.MaxEjectionTime < od.BaseEjectionTime {
+		errs = append(errs, fmt.Errorf("%s.max_ejection_time: must not be less than base_ejection_time", field))
+	}
+	if od.MaxEjectionPercent < 0 || od.MaxEjectionPercent > 100 {
+		errs = append(errs, fmt.Errorf("%s.max_ejection_percent: must be between 0 and 100, got %d", field, od.MaxEjectionPercent))
+	}
+	return errs
+}
+
+func validateHedge(field string, hc HedgeConfig) []error {
+	var errs []error
+	if hc.Delay < 0 {
+		errs = append(errs, fmt.Errorf("%s.delay: must not be negative", field))
+	}
+	if hc.Percentile < 0 || hc.Percentile >= 100 {
+		errs = append(errs, fmt.Errorf("%s.percentile: must be between 0 and 100, got %v", field, hc.Percentile))
+	}
+	if hc.Delay == 0 && hc.Percentile == 0 {
+		errs = append(errs, fmt.Errorf("%s: needs a delay or a percentile", field))
+	}
+	if hc.MaxHedges < 1 {
+		errs = append(errs, fmt.Errorf("%s.max_hedges: must be at least 1", field))
+	}
+	if hc.MaxPercent <= 0 || hc.MaxPercent > 100 {
+		errs = append(errs, fmt.Errorf("%s.max_percent: must be above 0 and at most 100, got %v", field, hc.MaxPercent))
+	}
+	return errs
+}
+
+func validateMirror(field string, mc MirrorConfig) []error {
+	var errs []error
+	if err := validateBackendURL(mc.URL); err != nil {
+		errs = append(errs, fmt.Errorf("%s.url: %w", field, err))
+	}
+	if mc.Percent < 0 || mc.Percent > 100 {
+		errs = append(errs, fmt.Errorf("%s.percent: must be between 0 and 100, got %v", field, mc.Percent))
+	}
+	if mc.Timeout <= 0 {
+		errs = append(errs, fmt.Errorf("%s.timeout: must be positive", field))
+	}
+	if mc.MaxConcurrent < 1 {
+		errs = append(errs, fmt.Errorf("%s.max_concurrent: must be at least 1", field))
+	}
+	return errs
+}
+
+func decodeStrict(data []byte, v any) error {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	return decoder.Decode(v)
+}
+
+// YAML is decoded generically and re-encoded as JSON so the json struct tags stay the single schema
+func yamlToJSON(data []byte) ([]byte, error) {
+	var value any
+	if err := yaml.Unmarshal(data, &value); err != nil {
+		return nil, fmt.Errorf("error parsing yaml: %w", err)
+	}
+
+	converted, err := json.Marshal(normalizeYAML(value))
+	if err != nil {
+		return nil, fmt.Errorf("error converting yaml: %w", err)
+	}
+
+	return converted, nil
+}
+
+func normalizeYAML(value any) any {
+	switch v := value.(type) {
+	case map[string]any:
+		for key, item := range v {
+			v[key] = normalizeYAML(item)
+		}
+		return v
+	case map[any]any:
+		converted := make(map[string]any, len(v))
+		for key, item := range v {
+			converted[fmt.Sprint(key)] = normalizeYAML(item)
+		}
+		return converted
+	case []any:
+		for i, item := range v {
+			v[i] = normalizeYAML(item)
+		}
+		return v
+	default:
+		return v
+	}
+}
@@ -0,0 +1,126 @@
+package gateway
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLoadConfig(t *testing.T) {
+	t.Run("ExampleFile", func(t *testing.T) {
+		config, err := LoadConfig("../../configs/gateway.example.yaml")
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+
+		if len(config.Routes) != 4 {
+			t.Fatalf("Expected 4 routes, got %d", len(config.Routes))
+		}
+
+		users := config.Routes[0]
+		if len(users.Backends) != 3 {
+			t.Errorf("Expected 3 user backends, got %d", len(users.Backends))
+		}
+
+		for _, backend := range users.Backends {
+			if !backend.Healthy || backend.Weight != 1 || backend.CircuitBreaker == nil {
+				t.Errorf("Expected backend defaults to be applied, got %+v", backend)
+			}
+		}
+
+		// target-only routes are turned into a single backend pool
+		if len(config.Routes[2].Backends) != 1 || config.Routes[2].Backends[0].URL != "http://localhost:8080" {
+			t.Errorf("Expected target to become a backend, got %+v", config.Routes[2].Backends)
+		}
+	})
+
+	t.Run("DefaultsMerging", func(t *testing.T) {
+		data := `{
+			"timeouts": {"backend_timeout": "20s"},
+			"retry": {"max_attempts": 5},
+			"routes": [{"pattern": "/api/llm/*", "backends": [{"url": "http://llm:9000", "weight": 3}]}]
+		}`
+
+		config, err := ParseConfig([]byte(data), "json")
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+
+		if config.Timeouts.BackendTimeout != 20*time.Second {
+			t.Errorf("Expected backend timeout 20s, got %v", config.Timeouts.BackendTimeout)
+		}
+
+		if config.Timeouts.RequestTimeout != DefaultTimeoutConfig().RequestTimeout {
+			t.Errorf("Expected default request timeout, got %v", config.Timeouts.RequestTimeout)
+		}
+
+		if config.Retry.MaxAttempts != 5 || config.Retry.InitialDelay != DefaultRetryConfig().InitialDelay {
+			t.Errorf("Expected retry overrides merged with defaults, got %+v", config.Retry)
+		}
+
+		if config.Routes[0].LoadBalancer != RoundRobin {
+			t.Errorf("Expected default load balancer %s, got %s", RoundRobin, config.Routes[0].LoadBalancer)
+		}
+
+		if config.Routes[0].Backends[0].Weight != 3 {
+			t.Errorf("Expected weight 3, got %d", config.Routes[0].Backends[0].Weight)
+		}
+	})
+
+	t.Run("ValidationErrors", func(t *testing.T) {
+		data := `
+routes:
+  - pattern: /api/users/{id}
+    target: http://api:8000
+  - pattern: /api/users/{name}
+    load_balancer: fastest
+    backends:
+      - url: "not a url"
+  - pattern: /api/users/{id}
+    target: http://api:8001
+  - pattern: /api/{version:v[0-9}
+    target: http://api:8002
+    rewrite: /{ver}
+bulkhead:
+  max_concurrent_requests: 0
+`
+
+		_, err := ParseConfig([]byte(data), "yaml")
+		if err == nil {
+			t.Fatal("Expected validation error, got nil")
+		}
+
+		expected := []string{
+			`routes[1]: pattern "/api/users/{name}" is unreachable`,
+			`routes[1].load_balancer: unknown strategy "fastest"`,
+			`routes[1].backends[0].url: malformed URL "not a url"`,
+			`routes[2]: duplicate pattern "/api/users/{id}"`,
+			`routes[3].pattern: invalid regex for parameter "version"`,
+			`bulkhead.max_concurrent_requests: must be at least 1`,
+		}
+
+		for _, msg := range expected {
+			if !strings.Contains(err.Error(), msg) {
+				t.Errorf("Expected error to contain %q, got:\n%v", msg, err)
+			}
+		}
+	})
+
+	t.Run("UnknownField", func(t *testing.T) {
+		data := `{"routes": [{"pattern": "/x", "target": "http://x:1", "strip_prefx": "/x"}]}`
+
+		_, err := ParseConfig([]byte(data), "json")
+		if err == nil || !strings.Contains(err.Error(), "strip_prefx") {
+			t.Errorf("Expected unknown field error, got %v", err)
+		}
+	})
+
+	t.Run("BareNumberDuration", func(t *testing.T) {
+		data := `{"circuit_breaker": {"timeout": 30}, "routes": [{"pattern": "/x", "target": "http://x:1"}]}`
+
+		_, err := ParseConfig([]byte(data), "json")
+		if err == nil || !strings.Contains(err.Error(), "invalid duration") {
+			t.Errorf("Expected invalid duration error, got %v", err)
+		}
+	})
+}
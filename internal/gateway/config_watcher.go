@@ -0,0 +1,64 @@
+package gateway
+
+import (
+	"context"
+	"log"
+	"os"
+	"time"
+)
+
+// Polls a config file and calls onChange whenever its modification time or size changes
+type ConfigWatcher struct {
+	path     string
+	interval time.Duration
+	modTime  time.Time
+	size     int64
+}
+
+func NewConfigWatcher(path string, interval time.Duration) *ConfigWatcher {
+	watcher := &ConfigWatcher{
+		path:     path,
+		interval: interval,
+	}
+
+	info, err := os.Stat(path)
+	if err == nil {
+		watcher.modTime = info.ModTime()
+		watcher.size = info.Size()
+	}
+
+	return watcher
+}
+
+func (cw *ConfigWatcher) Start(ctx context.Context, onChange func()) {
+	ticker := time.NewTicker(cw.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if cw.changed() {
+				log.Printf("📝 Config file %s changed", cw.path)
+				onChange()
+			}
+		}
+	}
+}
+
+func (cw *ConfigWatcher) changed() bool {
+	info, err := os.Stat(cw.path)
+	if err != nil {
+		// editors often replace the file in two steps, try again next tick
+		return false
+	}
+
+	if info.ModTime().Equal(cw.modTime) && info.Size() == cw.size {
+		return false
+	}
+
+	cw.modTime = info.ModTime()
+	cw.size = info.Size()
+	return true
+}
@@ -0,0 +1,167 @@
+package gateway
+
+import (
+	"log"
+)
+
+// How a route builds its backend pool
+type DiscoveryMode string
+
+const (
+	DiscoveryStatic   DiscoveryMode = "static"   // only the backends declared on the route
+	DiscoveryMerge    DiscoveryMode = "merge"    // declared backends plus registry instances for the route
+	DiscoveryRegistry DiscoveryMode = "registry" // only registry instances, declared backends are ignored
+)
+
+var knownDiscoveryModes = []DiscoveryMode{DiscoveryStatic, DiscoveryMerge, DiscoveryRegistry}
+
+func isKnownDiscoveryMode(mode DiscoveryMode) bool {
+	for _, known := range knownDiscoveryModes {
+		if mode == known {
+			return true
+		}
+	}
+	return false
+}
+
+// Circuit breaker and bulkhead for a backend that is not declared in the config,
+// either a registry instance or a target-only route. Lives across reloads.
+type dynamicBackend struct {
+	url            string
+	circuitBreaker *CircuitBreaker
+	bulkhead       ConcurrencyLimiter
+}
+
+// Use registry instances as backends for routes whose discovery mode allows it
+func (p *Proxy) SetRegistry(registry *ServiceRegistry) {
+	p.registry = registry
+}
+
+// Builds the backend pool for a route from its static backends and the registry
+func (p *Proxy) routeBackends(snapshot *proxySnapshot, route *Route) []Backend {
+	mode := route.Discovery
+	if mode == "" {
+		mode = DiscoveryMerge
+	}
+
+	var backends []Backend
+	if mode != DiscoveryRegistry {
+		backends = append(backends, route.GetBackends()...)
+	}
+
+	for i := range backends {
+		if backends[i].CircuitBreaker == nil {
+			backends[i].CircuitBreaker = p.dynamicBackend(snapshot, route, backends[i].URL).circuitBreaker
+		}
+	}
+
+	if p.registry == nil || mode == DiscoveryStatic {
+		return backends
+	}
+
+	p.pruneDynamicBackends(snapshot)
+
+	seen := make(map[string]bool, len(backends))
+	for _, backend := range backends {
+		seen[backend.URL] = true
+	}
+
+	for _, instance := range p.registry.ListInstances(route.Pattern) {
+		if seen[instance.URL] {
+			continue
+		}
+		seen[instance.URL] = true
+
+		backends = append(backends, Backend{
+			URL:            instance.URL,
+			Healthy:        instance.Health != "unhealthy", // newly registered instances are trusted until checked
+			Weight:         1,
+			Metadata:       instance.Metadata,
+			CircuitBreaker: p.dynamicBackend(snapshot, route, instance.URL).circuitBreaker,
+		})
+	}
+
+	return backends
+}
+
+// Returns the bulkhead for a backend of a route, creating one on the fly for dynamic backends
+func (p *Proxy) getBulkhead(snapshot *proxySnapshot, route *Route, url string) ConcurrencyLimiter {
+	if bulkhead, exists := snapshot.bulkheads[backendStateKey(route, url)]; exists {
+		return bulkhead
+	}
+	return p.dynamicBackend(snapshot, route, url).bulkhead
+}
+
+func (p *Proxy) dynamicBackend(snapshot *proxySnapshot, route *Route, url string) *dynamicBackend {
+	stateKey := backendStateKey(route, url)
+
+	p.dynamicMutex.RLock()
+	state, exists := p.dynamicBackends[stateKey]
+	p.dynamicMutex.RUnlock()
+
+	if exists {
+		return state
+	}
+
+	p.dynamicMutex.Lock()
+	defer p.dynamicMutex.Unlock()
+
+	// Double-check after acquiring write lock
+	state, exists = p.dynamicBackends[stateKey]
+	if exists {
+		return state
+	}
+
+	policy := snapshot.policyFor(route)
+	state = &dynamicBackend{
+		url:            url,
+		circuitBreaker: NewCircuitBreaker(policy.CircuitBreaker),
+		bulkhead:       NewConcurrencyLimiter(policy.Bulkhead),
+	}
+	state.circuitBreaker.attach(p.breakerEvents, stateKey, url)
+	p.dynamicBackends[stateKey] = state
+	log.Printf("➕ Created circuit breaker and bulkhead for dynamic backend %s", url)
+	return state
+}
+
+// Drops state for dynamic backends that are neither registered nor declared anymore.
+// Only runs when the registry has changed since the last prune.
+func (p *Proxy) pruneDynamicBackends(snapshot *proxySnapshot) {
+	revision := p.registry.Revision()
+	if p.prunedRevision.Load() == revision {
+		return
+	}
+
+	p.dynamicMutex.Lock()
+	defer p.dynamicMutex.Unlock()
+
+	if p.prunedRevision.Load() == revision {
+		return
+	}
+
+	live := make(map[string]bool)
+	for _, route := range p.registry.GetAllRoutes() {
+		for _, instance := range p.registry.ListInstances(route) {
+			live[instance.URL] = true
+		}
+	}
+	for _, route := range snapshot.config.Routes {
+		if route.Target != "" {
+			live[route.Target] = true
+		}
+	}
+
+	for stateKey, state := range p.dynamicBackends {
+		if !live[state.url] {
+			delete(p.dynamicBackends, stateKey)
+			for _, lb := range snapshot.loadBalancers {
+				if forgetter, ok := lb.(backendForgetter); ok {
+					forgetter.ForgetBackend(state.url)
+				}
+			}
+			log.Printf("🧹 Tearing down state for deregistered backend %s", state.url)
+		}
+	}
+
+	p.prunedRevision.Store(revision)
+}
@@ -0,0 +1,63 @@
+package gateway
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestProxyRegistryBackends(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer backend.Close()
+
+	registry := NewServiceRegistry()
+	proxy := NewProxy(testConfig(
+		Route{Pattern: "/api/orders/*", Discovery: DiscoveryRegistry, LoadBalancer: RoundRobin},
+	), DefaultTimeoutConfig())
+	proxy.SetRegistry(registry)
+
+	t.Run("NoInstances", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		proxy.ServeHTTP(w, httptest.NewRequest("GET", "/api/orders/1", nil))
+		if w.Code != http.StatusServiceUnavailable {
+			t.Errorf("Expected status 503, got %d", w.Code)
+		}
+	})
+
+	t.Run("RegisteredInstanceReceivesTraffic", func(t *testing.T) {
+		registry.RegisterService(&ServiceInstance{
+			ID: "orders-1", URL: backend.URL, Route: "/api/orders/*", Health: "healthy",
+		})
+
+		w := httptest.NewRecorder()
+		proxy.ServeHTTP(w, httptest.NewRequest("GET", "/api/orders/1", nil))
+		if w.Code != http.StatusOK {
+			t.Errorf("Expected status 200, got %d", w.Code)
+		}
+
+		if _, exists := proxy.dynamicBackends[backend.URL]; !exists {
+			t.Error("Expected circuit breaker and bulkhead to be created for registered instance")
+		}
+	})
+
+	t.Run("UnhealthyInstanceIsSkipped", func(t *testing.T) {
+		registry.UpdateServiceHealth("/api/orders/*", "orders-1", "unhealthy")
+
+		w := httptest.NewRecorder()
+		proxy.ServeHTTP(w, httptest.NewRequest("GET", "/api/orders/1", nil))
+		if w.Code != http.StatusServiceUnavailable {
+			t.Errorf("Expected status 503, got %d", w.Code)
+		}
+	})
+
+	t.Run("DeregisteredInstanceStateIsTornDown", func(t *testing.T) {
+		registry.DeregisterService("/api/orders/*", "orders-1")
+
+		proxy.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/api/orders/1", nil))
+		if _, exists := proxy.dynamicBackends[backend.URL]; exists {
+			t.Error("Expected state for deregistered instance to be removed")
+		}
+	})
+}
@@ -0,0 +1,289 @@
+package gateway
+
+import (
+	"fmt"
+	"hash/fnv"
+	"math/rand"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/aishahsofea/go-ai-gateway/internal/middleware"
+)
+
+// Where a hashing load balancer takes a request's key from, exactly one is set.
+// Requests with the same key land on the same backend while it is available.
+type HashOnConfig struct {
+	Header   string `json:"header"`
+	Cookie   string `json:"cookie"`
+	Claim    string `json:"claim"` // JWT claim, e.g. sub
+	Param    string `json:"param"` // matched path param, e.g. conversation_id
+	ClientIP bool   `json:"client_ip"`
+}
+
+// The request's hash key, empty when the request doesn't carry one
+func (hc *HashOnConfig) keyFor(r *http.Request, params RouteParams) string {
+	if hc == nil {
+		return ""
+	}
+
+	switch {
+	case hc.Header != "":
+		return r.Header.Get(hc.Header)
+	case hc.Cookie != "":
+		cookie, err := r.Cookie(hc.Cookie)
+		if err != nil {
+			return ""
+		}
+		return cookie.Value
+	case hc.Claim != "":
+		claims, ok := middleware.LookupClaims(r)
+		if !ok || claims[hc.Claim] == nil {
+			return ""
+		}
+		return fmt.Sprint(claims[hc.Claim])
+	case hc.Param != "":
+		return params.Get(hc.Param)
+	case hc.ClientIP:
+		host, _, err := net.SplitHostPort(r.RemoteAddr)
+		if err != nil {
+			return r.RemoteAddr
+		}
+		return host
+	}
+	return ""
+}
+
+// Implemented by balancers that pick a backend by a key derived from the request
+type keyedBalancer interface {
+	SelectBackendForKey(backends []Backend, key string) (*Backend, error)
+}
+
+const (
+	ringHashReplicas = 100   // points on the ring per unit of backend weight
+	maglevTableSize  = 65537 // prime, much larger than any backend pool
+	hashTableCache   = 16    // lookup tables kept per balancer, one per distinct backend set
+)
+
+// A lookup structure built for one set of backends
+type hashTable interface {
+	// Returns the index of the backend for hash, trying others in a fixed order
+	// when the preferred one is unavailable. -1 when none is available.
+	pick(hash uint64, backends []Backend) int
+}
+
+// Consistent hashing over a route's backends, either a ring (Karger et al.) or a
+// Maglev lookup table. Adding or removing a backend only moves the keys of that
+// backend's share. Weight 0 drains a backend.
+type ConsistentHashBalancer struct {
+	strategy LoadBalancerStrategy // RingHash or Maglev
+	mutex    sync.Mutex
+	tables   map[string]hashTable // backend set signature -> lookup table
+}
+
+func NewRingHashBalancer() *ConsistentHashBalancer {
+	return &ConsistentHashBalancer{strategy: RingHash, tables: make(map[string]hashTable)}
+}
+
+func NewMaglevBalancer() *ConsistentHashBalancer {
+	return &ConsistentHashBalancer{strategy: Maglev, tables: make(map[string]hashTable)}
+}
+
+// Requests without a key are spread at random
+func (ch *ConsistentHashBalancer) SelectBackend(backends []Backend) (*Backend, error) {
+	healthy := getHealthyBackends(backends)
+	if len(healthy) == 0 {
+		return nil, fmt.Errorf("no healthy backends available")
+	}
+	return &healthy[rand.Intn(len(healthy))], nil
+}
+
+func (ch *ConsistentHashBalancer) SelectBackendForKey(backends []Backend, key string) (*Backend, error) {
+	if key == "" {
+		return ch.SelectBackend(backends)
+	}
+
+	index := ch.table(backends).pick(mixHash(hashString(key)), backends)
+	if index < 0 {
+		return nil, fmt.Errorf("no healthy backends available")
+	}
+
+	selected := backends[index]
+	return &selected, nil
+}
+
+// Tables are built over every backend, available or not, so a backend whose
+// circuit opens doesn't remap the keys of the others
+func (ch *ConsistentHashBalancer) table(backends []Backend) hashTable {
+	var signature strings.Builder
+	for _, backend := range backends {
+		signature.WriteString(backend.URL)
+		signature.WriteByte(' ')
+		signature.WriteString(strconv.Itoa(backend.Weight))
+		signature.WriteByte(',')
+	}
+
+	ch.mutex.Lock()
+	defer ch.mutex.Unlock()
+
+	if table, exists := ch.tables[signature.String()]; exists {
+		return table
+	}
+
+	var table hashTable
+	if ch.strategy == Maglev {
+		table = newMaglevTable(backends)
+	} else {
+		table = newHashRing(backends)
+	}
+
+	if len(ch.tables) >= hashTableCache {
+		ch.tables = make(map[string]hashTable)
+	}
+	ch.tables[signature.String()] = table
+	return table
+}
+
+func (ch *ConsistentHashBalancer) String() string {
+	if ch.strategy == Maglev {
+		return "Maglev"
+	}
+	return "RingHash"
+}
+
+func backendAvailable(backend *Backend) bool {
+	return backend.Healthy && (backend.CircuitBreaker == nil || backend.CircuitBreaker.CanRequest())
+}
+
+type ringPoint struct {
+	hash    uint64
+	backend int
+}
+
+type hashRing struct {
+	points []ringPoint // sorted by hash
+}
+
+func newHashRing(backends []Backend) *hashRing {
+	ring := &hashRing{}
+	for i, backend := range backends {
+		for replica := 0; replica < ringHashReplicas*backend.Weight; replica++ {
+			hash := mixHash(hashString(backend.URL + "#" + strconv.Itoa(replica)))
+			ring.points = append(ring.points, ringPoint{hash: hash, backend: i})
+		}
+	}
+
+	sort.Slice(ring.points, func(i, j int) bool {
+		return ring.points[i].hash < ring.points[j].hash
+	})
+	return ring
+}
+
+// Walks clockwise from hash to the first available backend
+func (hr *hashRing) pick(hash uint64, backends []Backend) int {
+	if len(hr.points) == 0 {
+		return -1
+	}
+
+	start := sort.Search(len(hr.points), func(i int) bool {
+		return hr.points[i].hash >= hash
+	})
+
+	tried := make(map[int]bool)
+	for n := 0; n < len(hr.points) && len(tried) < len(backends); n++ {
+		index := hr.points[(start+n)%len(hr.points)].backend
+		if tried[index] {
+			continue
+		}
+		tried[index] = true
+
+		if backendAvailable(&backends[index]) {
+			return index
+		}
+	}
+	return -1
+}
+
+type maglevTable struct {
+	entries []int // slot -> backend index, -1 when no backend has weight
+}
+
+// Fills the table the way the Maglev paper does: each backend walks its own
+// permutation of the slots and claims the next free one on its turn. A backend
+// takes as many turns per round as its weight.
+func newMaglevTable(backends []Backend) *maglevTable {
+	table := &maglevTable{entries: make([]int, maglevTableSize)}
+	for i := range table.entries {
+		table.entries[i] = -1
+	}
+
+	offsets := make([]uint64, len(backends))
+	skips := make([]uint64, len(backends))
+	next := make([]uint64, len(backends))
+	weighted := false
+	for i, backend := range backends {
+		offsets[i] = mixHash(hashString(backend.URL)) % maglevTableSize
+		skips[i] = mixHash(hashString(backend.URL+"#skip"))%(maglevTableSize-1) + 1
+		weighted = weighted || backend.Weight > 0
+	}
+	if !weighted {
+		return table
+	}
+
+	filled := 0
+	for filled < maglevTableSize {
+		for i, backend := range backends {
+			for turn := 0; turn < backend.Weight && filled < maglevTableSize; turn++ {
+				slot := (offsets[i] + next[i]*skips[i]) % maglevTableSize
+				for table.entries[slot] >= 0 {
+					next[i]++
+					slot = (offsets[i] + next[i]*skips[i]) % maglevTableSize
+				}
+				table.entries[slot] = i
+				next[i]++
+				filled++
+			}
+		}
+	}
+	return table
+}
+
+// Looks the key up again with a salted hash when its backend is unavailable
+func (mt *maglevTable) pick(hash uint64, backends []Backend) int {
+	if mt.entries[0] < 0 {
+		return -1
+	}
+
+	for attempt := uint64(0); attempt <= uint64(len(backends)); attempt++ {
+		index := mt.entries[mixHash(hash+attempt)%maglevTableSize]
+		if backendAvailable(&backends[index]) {
+			return index
+		}
+	}
+
+	for i := range backends {
+		if backends[i].Weight > 0 && backendAvailable(&backends[i]) {
+			return i
+		}
+	}
+	return -1
+}
+
+func hashString(s string) uint64 {
+	hash := fnv.New64a()
+	hash.Write([]byte(s))
+	return hash.Sum64()
+}
+
+// splitmix64 finalizer, spreads similar inputs such as "url#1" and "url#2" evenly
+func mixHash(h uint64) uint64 {
+	h ^= h >> 30
+	h *= 0xbf58476d1ce4e5b9
+	h ^= h >> 27
+	h *= 0x94d049bb133111eb
+	h ^= h >> 31
+	return h
+}
@@ -0,0 +1,227 @@
+package gateway
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func hashBackends(n int) []Backend {
+	breakers := DefaultCircuitBreakerConfig()
+	backends := make([]Backend, n)
+	for i := range backends {
+		backends[i] = Backend{
+			URL:            fmt.Sprintf("http://backend-%d:8000", i),
+			Healthy:        true,
+			Weight:         1,
+			CircuitBreaker: NewCircuitBreaker(breakers),
+		}
+	}
+	return backends
+}
+
+func TestConsistentHashBalancer(t *testing.T) {
+	balancers := map[string]func() *ConsistentHashBalancer{
+		"RingHash": NewRingHashBalancer,
+		"Maglev":   NewMaglevBalancer,
+	}
+
+	assign := func(t *testing.T, lb *ConsistentHashBalancer, backends []Backend, keys int) map[string]string {
+		t.Helper()
+		assignments := make(map[string]string, keys)
+		for i := 0; i < keys; i++ {
+			key := fmt.Sprintf("user-%d", i)
+			backend, err := lb.SelectBackendForKey(backends, key)
+			if err != nil {
+				t.Fatalf("Expected no error, got %v", err)
+			}
+			assignments[key] = backend.URL
+		}
+		return assignments
+	}
+
+	for name, newBalancer := range balancers {
+		t.Run(name, func(t *testing.T) {
+			t.Run("SameKeySameBackend", func(t *testing.T) {
+				lb := newBalancer()
+				backends := hashBackends(5)
+
+				first := assign(t, lb, backends, 100)
+				again := assign(t, lb, backends, 100)
+				for key, url := range first {
+					if again[key] != url {
+						t.Fatalf("Expected %s to stay on %s, got %s", key, url, again[key])
+					}
+				}
+			})
+
+			t.Run("AddingBackendMovesFewKeys", func(t *testing.T) {
+				lb := newBalancer()
+				backends := hashBackends(11)
+
+				before := assign(t, lb, backends[:10], 10000)
+				after := assign(t, lb, backends, 10000)
+
+				moved, shuffled := 0, 0
+				for key, url := range before {
+					if after[key] == url {
+						continue
+					}
+					moved++
+					if after[key] != backends[10].URL {
+						shuffled++
+					}
+				}
+
+				// an even share of the new backend is 1/11 of the keys
+				if moved < 500 || moved > 1500 {
+					t.Errorf("Expected about 900 of 10000 keys to move, got %d", moved)
+				}
+
+				// a ring never moves keys between existing backends, Maglev only a few
+				if maxShuffled := map[string]int{"RingHash": 0, "Maglev": 200}[name]; shuffled > maxShuffled {
+					t.Errorf("Expected at most %d keys to move between existing backends, got %d", maxShuffled, shuffled)
+				}
+			})
+
+			t.Run("FallsBackWhenCircuitIsOpen", func(t *testing.T) {
+				lb := newBalancer()
+				backends := hashBackends(5)
+				before := assign(t, lb, backends, 1000)
+
+				open := backends[2].CircuitBreaker
+				for i := 0; i < DefaultCircuitBreakerConfig().FailureThreshold; i++ {
+					open.RecordFailure()
+				}
+
+				after := assign(t, lb, backends, 1000)
+				for key, url := range before {
+					switch {
+					case after[key] == backends[2].URL:
+						t.Fatalf("Expected %s to avoid the open circuit", key)
+					case url != backends[2].URL && after[key] != url:
+						t.Fatalf("Expected %s to stay on %s, got %s", key, url, after[key])
+					}
+				}
+			})
+
+			t.Run("HonorsWeight", func(t *testing.T) {
+				lb := newBalancer()
+				backends := hashBackends(3)
+				backends[0].Weight = 2
+				backends[2].Weight = 0
+
+				counts := make(map[string]int)
+				for _, url := range assign(t, lb, backends, 9000) {
+					counts[url]++
+				}
+
+				if counts[backends[2].URL] != 0 {
+					t.Errorf("Expected drained backend to get no keys, got %d", counts[backends[2].URL])
+				}
+				if heavy := counts[backends[0].URL]; heavy < 5400 || heavy > 6600 {
+					t.Errorf("Expected about 6000 of 9000 keys on the weight 2 backend, got %d", heavy)
+				}
+			})
+		})
+	}
+}
+
+func TestHashOn(t *testing.T) {
+	hits := make(map[string]int)
+	newBackend := func(name string) *httptest.Server {
+		return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			hits[name]++
+		}))
+	}
+
+	var backends []Backend
+	for _, name := range []string{"a", "b", "c"} {
+		server := newBackend(name)
+		defer server.Close()
+		backends = append(backends, Backend{URL: server.URL, Healthy: true, Weight: 1})
+	}
+
+	tests := []struct {
+		name    string
+		pattern string
+		hashOn  HashOnConfig
+		request func(i int) *http.Request
+	}{
+		{
+			name:    "Header",
+			pattern: "/api/*",
+			hashOn:  HashOnConfig{Header: "X-User-ID"},
+			request: func(i int) *http.Request {
+				r := httptest.NewRequest("GET", fmt.Sprintf("/api/items/%d", i), nil)
+				r.Header.Set("X-User-ID", "user-42")
+				return r
+			},
+		},
+		{
+			name:    "PathParam",
+			pattern: "/api/conversations/{conversation_id}",
+			hashOn:  HashOnConfig{Param: "conversation_id"},
+			request: func(i int) *http.Request {
+				return httptest.NewRequest("GET", "/api/conversations/abc", nil)
+			},
+		},
+		{
+			name:    "ClientIP",
+			pattern: "/api/*",
+			hashOn:  HashOnConfig{ClientIP: true},
+			request: func(i int) *http.Request {
+				r := httptest.NewRequest("GET", "/api/items", nil)
+				r.RemoteAddr = fmt.Sprintf("10.0.0.7:%d", 40000+i)
+				return r
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clear(hits)
+			hashOn := tt.hashOn
+			proxy := NewProxy(testConfig(Route{
+				Pattern:      tt.pattern,
+				Backends:     append([]Backend(nil), backends...),
+				LoadBalancer: RingHash,
+				HashOn:       &hashOn,
+			}), DefaultTimeoutConfig())
+
+			for i := 0; i < 10; i++ {
+				proxy.ServeHTTP(httptest.NewRecorder(), tt.request(i))
+			}
+
+			if len(hits) != 1 {
+				t.Errorf("Expected every request on one backend, got %v", hits)
+			}
+		})
+	}
+
+	t.Run("ValidationErrors", func(t *testing.T) {
+		data := `{"routes": [
+			{"pattern": "/a/*", "target": "http://x:1", "load_balancer": "maglev"},
+			{"pattern": "/b/{id}", "target": "http://x:1", "load_balancer": "ring_hash", "hash_on": {"param": "user_id"}},
+			{"pattern": "/c/*", "target": "http://x:1", "load_balancer": "ring_hash", "hash_on": {"header": "X-User", "client_ip": true}}
+		]}`
+
+		_, err := ParseConfig([]byte(data), "json")
+		if err == nil {
+			t.Fatal("Expected validation error, got nil")
+		}
+
+		expected := []string{
+			"routes[0].hash_on: is required for load_balancer maglev",
+			`routes[1].hash_on.param: unknown parameter "user_id"`,
+			"routes[2].hash_on: exactly one of header, cookie, claim, param or client_ip is required",
+		}
+		for _, msg := range expected {
+			if !strings.Contains(err.Error(), msg) {
+				t.Errorf("Expected error to contain %q, got:\n%v", msg, err)
+			}
+		}
+	})
+}
@@ -2,7 +2,16 @@ package gateway
 
 import (
 	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"math/rand"
+	"net"
 	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
 	"sync"
 	"time"
 )
@@ -10,7 +19,9 @@ import (
 type HealthCheckConfig struct {
 	Interval       time.Duration
 	Timeout        time.Duration
-	FailureLimit   int
+	FailureLimit   int     // consecutive failures before a healthy instance is marked unhealthy
+	SuccessLimit   int     // consecutive successes before an unhealthy instance is marked healthy again
+	Jitter         float64 // each probe is delayed by up to this fraction of Interval
 	HealthEndpoint string
 }
 
@@ -19,16 +30,128 @@ func DefaultHealthCheckConfig() HealthCheckConfig {
 		Interval:       30 * time.Second,
 		Timeout:        5 * time.Second,
 		FailureLimit:   3,
+		SuccessLimit:   2,
+		Jitter:         0.2,
 		HealthEndpoint: "/health",
 	}
 }
 
+const (
+	HealthCheckHTTP = "http"
+	HealthCheckTCP  = "tcp"
+	HealthCheckNone = "none" // never probed, keeps whatever health it has
+)
+
+// How much of a response body is read when matching body_contains or json_field
+const healthCheckBodyLimit = 64 << 10
+
+// How an instance wants to be probed, sent along when it registers, or how a
+// route's declared backends are probed. Anything left out falls back to the checker's config.
+type HealthCheckSpec struct {
+	Type           string   `json:"type,omitempty"`            // http (default), tcp or none
+	Path           string   `json:"path,omitempty"`            // defaults to the checker's HealthEndpoint
+	Method         string   `json:"method,omitempty"`          // defaults to GET
+	ExpectedStatus []string `json:"expected_status,omitempty"` // e.g. "200", "200-299" or "2xx", defaults to 2xx
+	BodyContains   string   `json:"body_contains,omitempty"`
+	JSONField      string   `json:"json_field,omitempty"` // dotted path into a JSON body, e.g. "checks.db"
+	JSONValue      string   `json:"json_value,omitempty"` // expected value of JSONField
+	Address        string   `json:"address,omitempty"`    // tcp only, defaults to the host and port of the instance URL
+	Timeout        Duration `json:"timeout,omitempty"`
+	FailureLimit   int      `json:"failure_limit,omitempty"`
+	SuccessLimit   int      `json:"success_limit,omitempty"`
+}
+
+func (spec *HealthCheckSpec) Validate() error {
+	if spec == nil {
+		return nil
+	}
+
+	switch spec.Type {
+	case "", HealthCheckHTTP, HealthCheckTCP, HealthCheckNone:
+	default:
+		return fmt.Errorf("type: must be one of %s, %s, %s", HealthCheckHTTP, HealthCheckTCP, HealthCheckNone)
+	}
+
+	for _, status := range spec.ExpectedStatus {
+		if _, _, err := parseStatusRange(status); err != nil {
+			return fmt.Errorf("expected_status: %w", err)
+		}
+	}
+
+	if spec.JSONValue != "" && spec.JSONField == "" {
+		return fmt.Errorf("json_value: requires json_field")
+	}
+
+	if spec.Timeout < 0 {
+		return fmt.Errorf("timeout: must not be negative")
+	}
+	if spec.FailureLimit < 0 {
+		return fmt.Errorf("failure_limit: must not be negative")
+	}
+	if spec.SuccessLimit < 0 {
+		return fmt.Errorf("success_limit: must not be negative")
+	}
+
+	return nil
+}
+
+// Accepts "200", "200-299" and "2xx"
+func parseStatusRange(status string) (int, int, error) {
+	status = strings.TrimSpace(status)
+
+	if len(status) == 3 && strings.HasSuffix(strings.ToLower(status), "xx") {
+		class, err := strconv.Atoi(status[:1])
+		if err != nil || class < 1 || class > 5 {
+			return 0, 0, fmt.Errorf("invalid status class %q", status)
+		}
+		return class * 100, class*100 + 99, nil
+	}
+
+	low, high, isRange := strings.Cut(status, "-")
+	min, err := strconv.Atoi(strings.TrimSpace(low))
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid status %q", status)
+	}
+	max := min
+	if isRange {
+		if max, err = strconv.Atoi(strings.TrimSpace(high)); err != nil {
+			return 0, 0, fmt.Errorf("invalid status %q", status)
+		}
+	}
+
+	if min < 100 || max > 599 || min > max {
+		return 0, 0, fmt.Errorf("invalid status range %q", status)
+	}
+	return min, max, nil
+}
+
+func statusExpected(code int, expected []string) bool {
+	if len(expected) == 0 {
+		return code >= 200 && code < 300
+	}
+
+	for _, status := range expected {
+		min, max, err := parseStatusRange(status)
+		if err == nil && code >= min && code <= max {
+			return true
+		}
+	}
+	return false
+}
+
+// Consecutive results of an instance since its last health change
+type healthCounts struct {
+	failures  int
+	successes int
+}
+
 type HealthChecker struct {
 	registry *ServiceRegistry
+	proxy    *Proxy // when set, the backends declared in its config are checked too
 	config   HealthCheckConfig
 	client   *http.Client
-	mutex    sync.RWMutex
-	failures map[string]int // serviceID -> failure count
+	mutex    sync.Mutex
+	counts   map[string]*healthCounts // route + " " + service ID, or "static " + URL -> counts
 }
 
 func NewHealthChecker(registry *ServiceRegistry, config HealthCheckConfig) *HealthChecker {
@@ -36,12 +159,20 @@ func NewHealthChecker(registry *ServiceRegistry, config HealthCheckConfig) *Heal
 		registry: registry,
 		config:   config,
 		client: &http.Client{
-			Timeout: config.Timeout,
+			// a redirect is a result to check, not something to follow
+			CheckRedirect: func(req *http.Request, via []*http.Request) error {
+				return http.ErrUseLastResponse
+			},
 		},
-		failures: make(map[string]int),
+		counts: make(map[string]*healthCounts),
 	}
 }
 
+// Also checks the backends declared in the proxy's config, feeding the results into its backend selection
+func (hc *HealthChecker) SetProxy(proxy *Proxy) {
+	hc.proxy = proxy
+}
+
 func (hc *HealthChecker) Start(ctx context.Context) {
 	ticker := time.NewTicker(hc.config.Interval)
 	defer ticker.Stop()
@@ -51,47 +182,260 @@ func (hc *HealthChecker) Start(ctx context.Context) {
 		case <-ctx.Done():
 			return
 		case <-ticker.C:
-			hc.mutex.RLock()
-			for _, instances := range hc.registry.services {
-				for _, instance := range instances {
-					// go hc.checkService(instance)
-					go func(inst *ServiceInstance) {
-						isHealthy := hc.checkService(inst)
-						hc.updateServiceHealth(inst, isHealthy)
-					}(instance)
-				}
-			}
-			hc.mutex.RUnlock()
+			hc.checkAll(ctx)
+		}
+	}
+}
+
+// Starts one probe per registry instance and declared backend
+func (hc *HealthChecker) checkAll(ctx context.Context) {
+	instances := hc.registry.allInstances()
+
+	var static []ServiceInstance
+	if hc.proxy != nil {
+		static = hc.proxy.healthCheckTargets()
+		hc.proxy.forgetBackendHealth(instances, static)
+	}
+	hc.forgetRemoved(instances, static)
+
+	for i := range instances {
+		hc.schedule(ctx, &instances[i], hc.updateServiceHealth)
+	}
+	for i := range static {
+		hc.schedule(ctx, &static[i], hc.updateBackendHealth)
+	}
+}
+
+func (hc *HealthChecker) schedule(ctx context.Context, instance *ServiceInstance, update func(*ServiceInstance, bool)) {
+	if instance.HealthCheck != nil && instance.HealthCheck.Type == HealthCheckNone {
+		return
+	}
+
+	go func() {
+		// spread probes over the interval instead of firing them all at once
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(hc.jitter()):
 		}
+
+		err := hc.probe(ctx, instance)
+		if err != nil {
+			log.Printf("🩺 Health check failed for %s on route %s: %v", instance.URL, instance.Route, err)
+		}
+		update(instance, err == nil)
+	}()
+}
+
+func (hc *HealthChecker) jitter() time.Duration {
+	if hc.config.Jitter <= 0 {
+		return 0
 	}
+	return time.Duration(rand.Float64() * hc.config.Jitter * float64(hc.config.Interval))
 }
 
 func (hc *HealthChecker) checkService(instance *ServiceInstance) bool {
-	req, err := http.NewRequest("GET", instance.URL+hc.config.HealthEndpoint, nil)
+	return hc.probe(context.Background(), instance) == nil
+}
+
+// Runs the instance's health check, returning why it failed
+func (hc *HealthChecker) probe(ctx context.Context, instance *ServiceInstance) error {
+	spec := instance.HealthCheck
+	if spec == nil {
+		spec = &HealthCheckSpec{}
+	}
+
+	timeout := hc.config.Timeout
+	if spec.Timeout > 0 {
+		timeout = time.Duration(spec.Timeout)
+	}
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	if spec.Type == HealthCheckTCP {
+		return hc.probeTCP(ctx, instance, spec)
+	}
+	return hc.probeHTTP(ctx, instance, spec)
+}
+
+func (hc *HealthChecker) probeTCP(ctx context.Context, instance *ServiceInstance, spec *HealthCheckSpec) error {
+	address := spec.Address
+	if address == "" {
+		target, err := url.Parse(instance.URL)
+		if err != nil {
+			return fmt.Errorf("invalid instance URL: %w", err)
+		}
+
+		address = target.Host
+		if target.Port() == "" {
+			port := "80"
+			if target.Scheme == "https" {
+				port = "443"
+			}
+			address = net.JoinHostPort(target.Hostname(), port)
+		}
+	}
+
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", address)
+	if err != nil {
+		return err
+	}
+	return conn.Close()
+}
+
+func (hc *HealthChecker) probeHTTP(ctx context.Context, instance *ServiceInstance, spec *HealthCheckSpec) error {
+	path := spec.Path
+	if path == "" {
+		path = hc.config.HealthEndpoint
+	}
+
+	method := spec.Method
+	if method == "" {
+		method = http.MethodGet
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, instance.URL+path, nil)
 	if err != nil {
-		return false
+		return err
 	}
 
 	resp, err := hc.client.Do(req)
-	if err != nil || resp.StatusCode != http.StatusOK {
-		return false
+	if err != nil {
+		return err
 	}
 	defer resp.Body.Close()
 
-	return true
+	// read (and drain) the body even when it isn't checked so the connection can be reused
+	body, err := io.ReadAll(io.LimitReader(resp.Body, healthCheckBodyLimit))
+	if err != nil {
+		return fmt.Errorf("error reading response: %w", err)
+	}
+
+	if !statusExpected(resp.StatusCode, spec.ExpectedStatus) {
+		return fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+
+	if spec.BodyContains != "" && !strings.Contains(string(body), spec.BodyContains) {
+		return fmt.Errorf("body does not contain %q", spec.BodyContains)
+	}
+
+	if spec.JSONField != "" {
+		return matchJSONField(body, spec.JSONField, spec.JSONValue)
+	}
+
+	return nil
+}
+
+// Checks that the dotted field exists in a JSON body and, when expected is set, equals it
+func matchJSONField(body []byte, field, expected string) error {
+	var value any
+	if err := json.Unmarshal(body, &value); err != nil {
+		return fmt.Errorf("body is not JSON: %w", err)
+	}
+
+	for _, key := range strings.Split(field, ".") {
+		object, ok := value.(map[string]any)
+		if !ok {
+			return fmt.Errorf("json field %s not found", field)
+		}
+		if value, ok = object[key]; !ok {
+			return fmt.Errorf("json field %s not found", field)
+		}
+	}
+
+	if expected != "" && fmt.Sprint(value) != expected {
+		return fmt.Errorf("json field %s is %v, expected %s", field, value, expected)
+	}
+	return nil
 }
 
 func (hc *HealthChecker) updateServiceHealth(instance *ServiceInstance, isHealthy bool) {
 	hc.mutex.Lock()
 	defer hc.mutex.Unlock()
 
+	current := hc.registry.healthOf(instance.Route, instance.ID)
+	next := hc.nextHealthLocked(instance.Route+" "+instance.ID, current, isHealthy, instance.HealthCheck)
+	if next == "" {
+		return
+	}
+
+	if err := hc.registry.UpdateServiceHealth(instance.Route, instance.ID, next); err != nil {
+		// the registry keeps the old health, the next probe tries again
+		log.Printf("❌ Failed to update health of service %s: %v", instance.ID, err)
+		return
+	}
+	if current == "unhealthy" && next == "healthy" && hc.proxy != nil {
+		hc.proxy.markRecovered(instance.URL)
+	}
+}
+
+// Applies a probe result of a backend declared in the proxy's config
+func (hc *HealthChecker) updateBackendHealth(target *ServiceInstance, isHealthy bool) {
+	hc.mutex.Lock()
+	defer hc.mutex.Unlock()
+
+	current := hc.proxy.backendHealthOf(target.URL)
+	if next := hc.nextHealthLocked("static "+target.URL, current, isHealthy, target.HealthCheck); next != "" {
+		hc.proxy.setBackendHealth(target.URL, next == "healthy")
+	}
+}
+
+// Counts a probe result and returns the health the target should have now, or
+// "" when it stays as it is
+func (hc *HealthChecker) nextHealthLocked(key, current string, isHealthy bool, spec *HealthCheckSpec) string {
+	failureLimit, successLimit := hc.config.FailureLimit, hc.config.SuccessLimit
+	if spec != nil {
+		if spec.FailureLimit > 0 {
+			failureLimit = spec.FailureLimit
+		}
+		if spec.SuccessLimit > 0 {
+			successLimit = spec.SuccessLimit
+		}
+	}
+
+	counts, exists := hc.counts[key]
+	if !exists {
+		counts = &healthCounts{}
+		hc.counts[key] = counts
+	}
+
 	if isHealthy {
-		delete(hc.failures, instance.ID)
-		hc.registry.UpdateServiceHealth(instance.Route, instance.ID, "healthy")
+		counts.failures = 0
+		counts.successes++
+		// an instance that was marked unhealthy has to prove itself several times in a row
+		if current != "unhealthy" || counts.successes >= successLimit {
+			return "healthy"
+		}
 	} else {
-		hc.failures[instance.ID]++
-		if hc.failures[instance.ID] >= hc.config.FailureLimit {
-			hc.registry.UpdateServiceHealth(instance.Route, instance.ID, "unhealthy")
+		counts.successes = 0
+		counts.failures++
+		if counts.failures >= failureLimit {
+			return "unhealthy"
+		}
+	}
+	return ""
+}
+
+// Drops the counts of instances and backends that are no longer there
+func (hc *HealthChecker) forgetRemoved(instances, static []ServiceInstance) {
+	current := make(map[string]bool, len(instances)+len(static))
+	for _, instance := range instances {
+		current[instance.Route+" "+instance.ID] = true
+	}
+	for _, target := range static {
+		current["static "+target.URL] = true
+	}
+
+	hc.mutex.Lock()
+	defer hc.mutex.Unlock()
+
+	for key := range hc.counts {
+		if !current[key] {
+			delete(hc.counts, key)
 		}
 	}
 }
@@ -0,0 +1,235 @@
+package gateway
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Sends a duplicate of a slow request to another backend and uses whichever
+// response comes back first. Only requests that may be retried are hedged.
+type HedgeConfig struct {
+	Delay      time.Duration `json:"delay"`       // how long the first backend gets before a hedge is sent
+	Percentile float64       `json:"percentile"`  // wait for this latency percentile of the route instead, e.g. 95; delay applies until there are enough samples
+	MaxHedges  int           `json:"max_hedges"`  // extra copies per attempt
+	MaxPercent float64       `json:"max_percent"` // hedges allowed per 100 requests of the route over the last 10s
+}
+
+func DefaultHedgeConfig() HedgeConfig {
+	return HedgeConfig{
+		MaxHedges:  1,
+		MaxPercent: 10,
+	}
+}
+
+func (c *HedgeConfig) UnmarshalJSON(data []byte) error {
+	type alias HedgeConfig
+	defaults := DefaultHedgeConfig()
+	aux := struct {
+		*alias
+		Delay Duration `json:"delay"`
+	}{
+		alias: (*alias)(&defaults),
+		Delay: Duration(defaults.Delay),
+	}
+
+	if err := decodeStrict(data, &aux); err != nil {
+		return err
+	}
+
+	*c = defaults
+	c.Delay = time.Duration(aux.Delay)
+	return nil
+}
+
+const (
+	hedgeRateWindow  = 10 * time.Second
+	hedgeSamples     = 512 // latencies kept for the percentile
+	hedgeMinSamples  = 20  // before this many, the configured delay is used
+	hedgeRecalculate = 32  // samples between percentile recalculations
+)
+
+// Cause of the context of an attempt that lost to another one
+var errHedgeLost = errors.New("another attempt answered first")
+
+// Requests of the route and the hedges sent for them
+type hedgeCounts struct {
+	requests int
+	hedges   int
+}
+
+func (hc hedgeCounts) plus(other hedgeCounts) hedgeCounts {
+	return hedgeCounts{requests: hc.requests + other.requests, hedges: hc.hedges + other.hedges}
+}
+
+type hedger struct {
+	config HedgeConfig
+
+	mutex      sync.Mutex
+	rate       *secondsWindow[hedgeCounts]
+	samples    []time.Duration
+	next       int
+	sinceCalc  int
+	percentile time.Duration // cached, 0 until there are enough samples
+
+	sent    atomic.Int64
+	won     atomic.Int64 // hedges that answered before the first attempt
+	limited atomic.Int64 // hedges not sent because of max_percent
+}
+
+func newHedger(config HedgeConfig) *hedger {
+	return &hedger{
+		config: config,
+		rate:   newSecondsWindow[hedgeCounts](hedgeRateWindow),
+	}
+}
+
+// How long to wait for an attempt before hedging, 0 when there is nothing to go by yet
+func (h *hedger) delay() time.Duration {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	if h.config.Percentile > 0 && h.percentile > 0 {
+		return h.percentile
+	}
+	return h.config.Delay
+}
+
+// Records the latency of an attempt that completed
+func (h *hedger) observe(latency time.Duration) {
+	if h.config.Percentile <= 0 {
+		return
+	}
+
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	if len(h.samples) < hedgeSamples {
+		h.samples = append(h.samples, latency)
+	} else {
+		h.samples[h.next] = latency
+		h.next = (h.next + 1) % hedgeSamples
+	}
+
+	h.sinceCalc++
+	if len(h.samples) < hedgeMinSamples || (h.percentile > 0 && h.sinceCalc < hedgeRecalculate) {
+		return
+	}
+
+	sorted := slices.Clone(h.samples)
+	slices.Sort(sorted)
+	h.percentile = sorted[int(float64(len(sorted)-1)*h.config.Percentile/100)]
+	h.sinceCalc = 0
+}
+
+func (h *hedger) recordRequest() {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	h.rate.bucket(time.Now()).requests++
+}
+
+// Takes a hedge out of the rate limit, false when max_percent is used up
+func (h *hedger) allow() bool {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	now := time.Now()
+	if total := h.rate.totals(now); !withinShare(total.hedges, total.requests, h.config.MaxPercent, 0) {
+		h.limited.Add(1)
+		return false
+	}
+	h.rate.bucket(now).hedges++
+	h.sent.Add(1)
+	return true
+}
+
+func (h *hedger) GetStats() map[string]any {
+	return map[string]any{
+		"delay":   h.delay().String(),
+		"sent":    h.sent.Load(),
+		"won":     h.won.Load(),
+		"limited": h.limited.Load(),
+	}
+}
+
+// Runs an attempt against first and, while it hasn't answered after the hedge
+// delay, against other backends of the route. The first good response wins and
+// the other attempts are cancelled. Returns the last failure when all of them fail.
+func (p *Proxy) hedgedAttempt(r *http.Request, ac *attemptContext, selection *backendSelection, first *Backend, h *hedger) attemptResult {
+	h.recordRequest()
+
+	results := make(chan attemptResult, h.config.MaxHedges+1)
+	var cancels []context.CancelCauseFunc
+	defer func() {
+		for _, cancel := range cancels {
+			cancel(errHedgeLost)
+		}
+	}()
+
+	launch := func(backend *Backend) {
+		ctx, cancel := context.WithCancelCause(r.Context())
+		cancels = append(cancels, cancel)
+
+		// a losing attempt may still be reading the body after the request is done
+		ac.body.retain()
+		go func() {
+			defer ac.body.release()
+			results <- p.attempt(r.WithContext(ctx), ac, backend)
+		}()
+	}
+
+	launch(first)
+	pending := 1
+	tried := map[string]bool{first.URL: true}
+
+	var timer <-chan time.Time
+	if delay := h.delay(); delay > 0 {
+		timer = time.After(delay)
+	}
+
+	var last attemptResult
+	for pending > 0 {
+		select {
+		case result := <-results:
+			pending--
+			if result.succeeded() {
+				if result.backend.URL != first.URL {
+					h.won.Add(1)
+				}
+				return result
+			}
+			last = result
+
+		case <-timer:
+			timer = nil
+			if len(tried) > h.config.MaxHedges {
+				continue
+			}
+
+			// the hedge goes to a backend that doesn't have the request yet
+			hedgeSelection := *selection
+			hedgeSelection.exclude = tried
+			backend, err := p.selectBackend(ac.snapshot, ac.match.Route, &hedgeSelection)
+			if err != nil {
+				log.Printf("⚠️ No other backend to hedge %s %s to: %v", r.Method, r.URL.Path, err)
+				continue
+			}
+			if !h.allow() {
+				continue
+			}
+
+			log.Printf("🦔 Hedging %s %s to %s", r.Method, r.URL.Path, backend.URL)
+			tried[backend.URL] = true
+			launch(backend)
+			pending++
+			timer = time.After(h.delay())
+		}
+	}
+	return last
+}
@@ -0,0 +1,181 @@
+package gateway
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestHedging(t *testing.T) {
+	newBackend := func(delay time.Duration, calls *atomic.Int32, cancelled *atomic.Int32) *httptest.Server {
+		return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			calls.Add(1)
+			select {
+			case <-time.After(delay):
+				w.Write([]byte(delay.String()))
+			case <-r.Context().Done():
+				cancelled.Add(1)
+			}
+		}))
+	}
+
+	hedgedProxy := func(hedge HedgeConfig, backends ...*httptest.Server) *Proxy {
+		route := Route{Pattern: "/api/*", Hedge: &hedge}
+		for _, backend := range backends {
+			route.Backends = append(route.Backends, Backend{URL: backend.URL, Healthy: true, Weight: 1})
+		}
+		return NewProxy(testConfig(route), DefaultTimeoutConfig())
+	}
+
+	t.Run("SlowBackendIsHedged", func(t *testing.T) {
+		var slowCalls, slowCancelled, fastCalls, fastCancelled atomic.Int32
+		slow := newBackend(2*time.Second, &slowCalls, &slowCancelled)
+		defer slow.Close()
+		fast := newBackend(0, &fastCalls, &fastCancelled)
+		defer fast.Close()
+
+		hedge := DefaultHedgeConfig()
+		hedge.Delay = 20 * time.Millisecond
+		hedge.MaxPercent = 100
+		proxy := hedgedProxy(hedge, slow, fast)
+
+		// round robin sends one of the two requests to the slow backend first
+		start := time.Now()
+		for i := 0; i < 2; i++ {
+			w := httptest.NewRecorder()
+			proxy.ServeHTTP(w, httptest.NewRequest("GET", "/api/items", nil))
+
+			if w.Code != http.StatusOK || w.Body.String() != "0s" {
+				t.Fatalf("Expected the fast backend's response, got %d %q", w.Code, w.Body.String())
+			}
+		}
+		if elapsed := time.Since(start); elapsed > time.Second {
+			t.Errorf("Expected the hedge to answer well before the slow backend, took %v", elapsed)
+		}
+
+		deadline := time.Now().Add(time.Second)
+		for slowCancelled.Load() == 0 && time.Now().Before(deadline) {
+			time.Sleep(5 * time.Millisecond)
+		}
+		if slowCancelled.Load() != 1 {
+			t.Errorf("Expected the losing attempt to be cancelled")
+		}
+
+		snapshot := proxy.snapshot.Load()
+		hedger, _ := snapshot.hedgerFor("/api/*")
+		if hedger.sent.Load() != 1 || hedger.won.Load() != 1 {
+			t.Errorf("Expected 1 hedge sent and won, got %v", hedger.GetStats())
+		}
+		if state := snapshot.config.Routes[0].Backends[0].CircuitBreaker.GetStats(); state["failure_count"] != 0 {
+			t.Errorf("Expected the cancelled attempt not to count as a failure, got %v", state)
+		}
+	})
+
+	t.Run("FastBackendIsNotHedged", func(t *testing.T) {
+		var calls, cancelled atomic.Int32
+		a := newBackend(0, &calls, &cancelled)
+		defer a.Close()
+		b := newBackend(0, &calls, &cancelled)
+		defer b.Close()
+
+		hedge := DefaultHedgeConfig()
+		hedge.Delay = 500 * time.Millisecond
+		hedge.MaxPercent = 100
+		proxy := hedgedProxy(hedge, a, b)
+
+		for i := 0; i < 4; i++ {
+			proxy.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/api/items", nil))
+		}
+		if calls.Load() != 4 {
+			t.Errorf("Expected 4 backend calls, got %d", calls.Load())
+		}
+	})
+
+	t.Run("HedgeRateIsCapped", func(t *testing.T) {
+		var calls, cancelled atomic.Int32
+		a := newBackend(30*time.Millisecond, &calls, &cancelled)
+		defer a.Close()
+		b := newBackend(30*time.Millisecond, &calls, &cancelled)
+		defer b.Close()
+
+		hedge := DefaultHedgeConfig()
+		hedge.Delay = time.Millisecond
+		hedge.MaxPercent = 10
+		proxy := hedgedProxy(hedge, a, b)
+
+		for i := 0; i < 20; i++ {
+			proxy.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/api/items", nil))
+		}
+
+		hedger, _ := proxy.snapshot.Load().hedgerFor("/api/*")
+		if hedger.sent.Load() != 2 || hedger.limited.Load() != 18 {
+			t.Errorf("Expected 2 hedges for 20 requests at 10%%, got %v", hedger.GetStats())
+		}
+	})
+
+	t.Run("PostIsNotHedged", func(t *testing.T) {
+		var calls, cancelled atomic.Int32
+		a := newBackend(50*time.Millisecond, &calls, &cancelled)
+		defer a.Close()
+		b := newBackend(50*time.Millisecond, &calls, &cancelled)
+		defer b.Close()
+
+		hedge := DefaultHedgeConfig()
+		hedge.Delay = time.Millisecond
+		hedge.MaxPercent = 100
+		proxy := hedgedProxy(hedge, a, b)
+
+		proxy.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("POST", "/api/items", strings.NewReader("item")))
+		if calls.Load() != 1 {
+			t.Errorf("Expected a POST without idempotency key to be sent once, got %d", calls.Load())
+		}
+	})
+
+	t.Run("PercentileDelay", func(t *testing.T) {
+		hedger := newHedger(HedgeConfig{Delay: time.Second, Percentile: 95, MaxHedges: 1, MaxPercent: 10})
+
+		for i := 1; i < hedgeMinSamples; i++ {
+			hedger.observe(time.Duration(i) * time.Millisecond)
+		}
+		if hedger.delay() != time.Second {
+			t.Errorf("Expected the configured delay without enough samples, got %v", hedger.delay())
+		}
+
+		// 1-100ms over and over, the percentile is recalculated as samples come in
+		for i := 0; i < hedgeSamples; i++ {
+			hedger.observe(time.Duration(i%100+1) * time.Millisecond)
+		}
+		if delay := hedger.delay(); delay < 90*time.Millisecond || delay > 100*time.Millisecond {
+			t.Errorf("Expected a delay around the p95 of 1-100ms, got %v", delay)
+		}
+	})
+
+	t.Run("InvalidHedge", func(t *testing.T) {
+		data := `
+routes:
+  - pattern: /api/*
+    target: http://api:8000
+    hedge:
+      max_hedges: 0
+      max_percent: 0
+`
+
+		_, err := ParseConfig([]byte(data), "yaml")
+		if err == nil {
+			t.Fatal("Expected an error, got nil")
+		}
+
+		for _, expected := range []string{
+			"routes[0].hedge: needs a delay or a percentile",
+			"routes[0].hedge.max_hedges",
+			"routes[0].hedge.max_percent",
+		} {
+			if !strings.Contains(err.Error(), expected) {
+				t.Errorf("Expected error to mention %s, got %v", expected, err)
+			}
+		}
+	})
+}
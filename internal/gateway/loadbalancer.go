@@ -55,15 +55,108 @@ func (r *RandomBalancer) String() string {
 	return "Random"
 }
 
+// Picks backends at random in proportion to their weight. Weight 0 drains a backend.
+type WeightedRandomBalancer struct{}
+
+func NewWeightedRandomBalancer() *WeightedRandomBalancer {
+	return &WeightedRandomBalancer{}
+}
+
+func (wr *WeightedRandomBalancer) SelectBackend(backends []Backend) (*Backend, error) {
+	healthy := getHealthyBackends(backends)
+
+	total := 0
+	for _, backend := range healthy {
+		if backend.Weight > 0 {
+			total += backend.Weight
+		}
+	}
+
+	if total == 0 {
+		return nil, fmt.Errorf("no healthy backends available")
+	}
+
+	pick := rand.Intn(total)
+	for i := range healthy {
+		if healthy[i].Weight <= 0 {
+			continue
+		}
+		pick -= healthy[i].Weight
+		if pick < 0 {
+			return &healthy[i], nil
+		}
+	}
+
+	return nil, fmt.Errorf("no healthy backends available")
+}
+
+func (wr *WeightedRandomBalancer) String() string {
+	return "WeightedRandom"
+}
+
+// Implemented by balancers that pick backends by their in-flight requests,
+// the proxy reports each request's start and end to them
+type connectionTracker interface {
+	IncrementConnections(url string)
+	DecrementConnections(url string)
+}
+
+// Implemented by balancers that keep state per backend URL, a reload drops it
+// for backends that left the route
+type backendForgetter interface {
+	ForgetBackend(url string)
+}
+
+// In-flight requests per backend URL. A counter is created once per backend and
+// then only updated atomically, so selections never wait on each other.
+type connectionCounts struct {
+	counters sync.Map // backend URL -> *atomic.Int64
+}
+
+func (cc *connectionCounts) counter(url string) *atomic.Int64 {
+	if counter, ok := cc.counters.Load(url); ok {
+		return counter.(*atomic.Int64)
+	}
+	counter, _ := cc.counters.LoadOrStore(url, new(atomic.Int64))
+	return counter.(*atomic.Int64)
+}
+
+func (cc *connectionCounts) get(url string) int64 {
+	if counter, ok := cc.counters.Load(url); ok {
+		return counter.(*atomic.Int64).Load()
+	}
+	return 0
+}
+
+func (cc *connectionCounts) increment(url string) {
+	cc.counter(url).Add(1)
+}
+
+// A request that ends after its backend was forgotten has nothing to decrement
+func (cc *connectionCounts) decrement(url string) {
+	value, ok := cc.counters.Load(url)
+	if !ok {
+		return
+	}
+	counter := value.(*atomic.Int64)
+	for {
+		current := counter.Load()
+		if current <= 0 || counter.CompareAndSwap(current, current-1) {
+			return
+		}
+	}
+}
+
+func (cc *connectionCounts) forget(url string) {
+	cc.counters.Delete(url)
+}
+
 type LeastConnectionsBalancer struct {
-	connections map[string]int64
-	mutex       sync.RWMutex
+	connections connectionCounts
 }
 
 func NewLeastConnectionsBalancer() *LeastConnectionsBalancer {
-	return &LeastConnectionsBalancer{
-		connections: make(map[string]int64),
-	}
+	return &LeastConnectionsBalancer{}
 }
 
 func (lc *LeastConnectionsBalancer) SelectBackend(backends []Backend) (*Backend, error) {
@@ -72,15 +165,12 @@ func (lc *LeastConnectionsBalancer) SelectBackend(backends []Backend) (*Backend,
 		return nil, fmt.Errorf("no healthy backends available")
 	}
 
-	lc.mutex.RLock()
-	defer lc.mutex.RUnlock()
-
 	var selected *Backend
 	minConnections := int64(-1)
 
 	for i := range healthy {
 		backend := &healthy[i]
-		connections := lc.connections[backend.URL]
+		connections := lc.connections.get(backend.URL)
 
 		if minConnections == -1 || connections < minConnections {
 			minConnections = connections
@@ -96,19 +186,133 @@ func (lc *LeastConnectionsBalancer) String() string {
 }
 
 func (lc *LeastConnectionsBalancer) IncrementConnections(url string) {
-	lc.mutex.Lock()
-	defer lc.mutex.Unlock()
-	lc.connections[url]++
+	lc.connections.increment(url)
 }
 
 func (lc *LeastConnectionsBalancer) DecrementConnections(url string) {
-	lc.mutex.Lock()
-	defer lc.mutex.Unlock()
-	if lc.connections[url] > 0 {
-		lc.connections[url]--
+	lc.connections.decrement(url)
+}
+
+func (lc *LeastConnectionsBalancer) ForgetBackend(url string) {
+	lc.connections.forget(url)
+}
+
+// nginx's smooth weighted round-robin: every pick adds each backend's weight to its
+// current weight, takes the highest and subtracts the total from it. Backends get
+// picked in proportion to their weight without bursts, e.g. weights 5, 1, 1 give
+// a a b a c a a. Weight 0 drains a backend.
+type SmoothWeightedRoundRobinBalancer struct {
+	mutex   sync.Mutex
+	current map[string]int // backend URL -> current weight
+}
+
+func NewSmoothWeightedRoundRobinBalancer() *SmoothWeightedRoundRobinBalancer {
+	return &SmoothWeightedRoundRobinBalancer{
+		current: make(map[string]int),
 	}
 }
 
+func (sw *SmoothWeightedRoundRobinBalancer) SelectBackend(backends []Backend) (*Backend, error) {
+	healthy := getHealthyBackends(backends)
+
+	sw.mutex.Lock()
+	defer sw.mutex.Unlock()
+
+	var selected *Backend
+	total, best := 0, 0
+	for i := range healthy {
+		backend := &healthy[i]
+		if backend.Weight <= 0 {
+			continue
+		}
+
+		total += backend.Weight
+		current := sw.current[backend.URL] + backend.Weight
+		sw.current[backend.URL] = current
+
+		if selected == nil || current > best {
+			selected, best = backend, current
+		}
+	}
+
+	if selected == nil {
+		return nil, fmt.Errorf("no healthy backends available")
+	}
+	sw.current[selected.URL] -= total
+
+	// start over once backends that left the pool make up most of the map, it would grow with registry churn otherwise
+	if len(sw.current) > 2*len(healthy)+16 {
+		sw.current = make(map[string]int, len(healthy))
+	}
+
+	return selected, nil
+}
+
+func (sw *SmoothWeightedRoundRobinBalancer) String() string {
+	return "SmoothWeightedRoundRobin"
+}
+
+// Least-request balancing with the power of two choices: picks two backends at
+// random and takes the one with fewer in-flight requests relative to its weight.
+// Close to least connections, without looking at every backend on each request.
+type PowerOfTwoChoicesBalancer struct {
+	connections connectionCounts
+}
+
+func NewPowerOfTwoChoicesBalancer() *PowerOfTwoChoicesBalancer {
+	return &PowerOfTwoChoicesBalancer{}
+}
+
+func (pc *PowerOfTwoChoicesBalancer) SelectBackend(backends []Backend) (*Backend, error) {
+	healthy := getHealthyBackends(backends)
+
+	candidates := make([]*Backend, 0, len(healthy))
+	for i := range healthy {
+		if healthy[i].Weight > 0 {
+			candidates = append(candidates, &healthy[i])
+		}
+	}
+
+	switch len(candidates) {
+	case 0:
+		return nil, fmt.Errorf("no healthy backends available")
+	case 1:
+		return candidates[0], nil
+	}
+
+	i := rand.Intn(len(candidates))
+	j := rand.Intn(len(candidates) - 1)
+	if j >= i {
+		j++ // a distinct second choice
+	}
+
+	first, second := candidates[i], candidates[j]
+	if pc.load(second) < pc.load(first) {
+		return second, nil
+	}
+	return first, nil
+}
+
+func (pc *PowerOfTwoChoicesBalancer) load(backend *Backend) float64 {
+	return float64(pc.connections.get(backend.URL)+1) / float64(backend.Weight)
+}
+
+func (pc *PowerOfTwoChoicesBalancer) String() string {
+	return "PowerOfTwoChoices"
+}
+
+func (pc *PowerOfTwoChoicesBalancer) IncrementConnections(url string) {
+	pc.connections.increment(url)
+}
+
+func (pc *PowerOfTwoChoicesBalancer) DecrementConnections(url string) {
+	pc.connections.decrement(url)
+}
+
+func (pc *PowerOfTwoChoicesBalancer) ForgetBackend(url string) {
+	pc.connections.forget(url)
+}
+
 func getHealthyBackends(backends []Backend) []Backend {
 	var healthy []Backend
 	for _, backend := range backends {
@@ -122,6 +326,39 @@ func getHealthyBackends(backends []Backend) []Backend {
 	return healthy
 }
 
+var knownStrategies = []LoadBalancerStrategy{RoundRobin, LeastConnections, Random, WeightedRandom, WeightedRoundRobin, LeastRequest, RingHash, Maglev, PeakEWMA}
+
+func isKnownStrategy(strategy LoadBalancerStrategy) bool {
+	for _, known := range knownStrategies {
+		if strategy == known {
+			return true
+		}
+	}
+	return false
+}
+
+// Whether a strategy sends backends traffic in proportion to their weight
+func honorsWeights(strategy LoadBalancerStrategy) bool {
+	switch strategy {
+	case WeightedRandom, WeightedRoundRobin, LeastRequest, RingHash, Maglev:
+		return true
+	}
+	return false
+}
+
+// Whether a strategy sends each key to the same backend every time
+func pinsKeys(strategy LoadBalancerStrategy) bool {
+	return strategy == RingHash || strategy == Maglev
+}
+
+func knownStrategyNames() []string {
+	names := make([]string, 0, len(knownStrategies))
+	for _, strategy := range knownStrategies {
+		names = append(names, string(strategy))
+	}
+	return names
+}
+
 func NewLoadBalancer(strategy LoadBalancerStrategy) LoadBalancer {
 	switch strategy {
 	case RoundRobin:
@@ -130,6 +367,18 @@ func NewLoadBalancer(strategy LoadBalancerStrategy) LoadBalancer {
 		return NewRandomBalancer()
 	case LeastConnections:
 		return NewLeastConnectionsBalancer()
+	case WeightedRandom:
+		return NewWeightedRandomBalancer()
+	case WeightedRoundRobin:
+		return NewSmoothWeightedRoundRobinBalancer()
+	case LeastRequest:
+		return NewPowerOfTwoChoicesBalancer()
+	case RingHash:
+		return NewRingHashBalancer()
+	case Maglev:
+		return NewMaglevBalancer()
+	case PeakEWMA:
+		return NewPeakEWMABalancer()
 	default:
 		return NewRoundRobinBalancer() // Default to RoundRobin
 	}
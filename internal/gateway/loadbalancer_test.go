@@ -0,0 +1,293 @@
+package gateway
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestWeightedRandomBalancer(t *testing.T) {
+	breakers := DefaultCircuitBreakerConfig()
+	backends := []Backend{
+		{URL: "http://heavy:8000", Healthy: true, Weight: 3, CircuitBreaker: NewCircuitBreaker(breakers)},
+		{URL: "http://light:8000", Healthy: true, Weight: 1, CircuitBreaker: NewCircuitBreaker(breakers)},
+		{URL: "http://drained:8000", Healthy: true, Weight: 0, CircuitBreaker: NewCircuitBreaker(breakers)},
+	}
+
+	lb := NewWeightedRandomBalancer()
+	counts := make(map[string]int)
+	for i := 0; i < 8000; i++ {
+		backend, err := lb.SelectBackend(backends)
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		counts[backend.URL]++
+	}
+
+	if counts["http://drained:8000"] != 0 {
+		t.Errorf("Expected drained backend to get no traffic, got %d", counts["http://drained:8000"])
+	}
+
+	if heavy := counts["http://heavy:8000"]; heavy < 5600 || heavy > 6400 {
+		t.Errorf("Expected about 75%% of traffic on the weight 3 backend, got %d of 8000", heavy)
+	}
+}
+
+func TestSmoothWeightedRoundRobinBalancer(t *testing.T) {
+	breakers := DefaultCircuitBreakerConfig()
+	backends := []Backend{
+		{URL: "a", Healthy: true, Weight: 5, CircuitBreaker: NewCircuitBreaker(breakers)},
+		{URL: "b", Healthy: true, Weight: 1, CircuitBreaker: NewCircuitBreaker(breakers)},
+		{URL: "c", Healthy: true, Weight: 1, CircuitBreaker: NewCircuitBreaker(breakers)},
+		{URL: "drained", Healthy: true, Weight: 0, CircuitBreaker: NewCircuitBreaker(breakers)},
+	}
+
+	lb := NewSmoothWeightedRoundRobinBalancer()
+
+	var sequence string
+	for i := 0; i < 14; i++ {
+		backend, err := lb.SelectBackend(backends)
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		sequence += backend.URL
+	}
+
+	if expected := "aabacaaaabacaa"; sequence != expected {
+		t.Errorf("Expected sequence %s, got %s", expected, sequence)
+	}
+}
+
+func TestPowerOfTwoChoicesBalancer(t *testing.T) {
+	breakers := DefaultCircuitBreakerConfig()
+	backends := []Backend{
+		{URL: "busy", Healthy: true, Weight: 1, CircuitBreaker: NewCircuitBreaker(breakers)},
+		{URL: "idle", Healthy: true, Weight: 1, CircuitBreaker: NewCircuitBreaker(breakers)},
+		{URL: "drained", Healthy: true, Weight: 0, CircuitBreaker: NewCircuitBreaker(breakers)},
+	}
+
+	lb := NewPowerOfTwoChoicesBalancer()
+	for i := 0; i < 10; i++ {
+		lb.IncrementConnections("busy")
+	}
+
+	for i := 0; i < 100; i++ {
+		backend, err := lb.SelectBackend(backends)
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		if backend.URL != "idle" {
+			t.Fatalf("Expected the backend with fewer in-flight requests, got %s", backend.URL)
+		}
+	}
+
+	t.Run("ConcurrentUse", func(t *testing.T) {
+		var wg sync.WaitGroup
+		for i := 0; i < 50; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for j := 0; j < 100; j++ {
+					backend, _ := lb.SelectBackend(backends)
+					lb.IncrementConnections(backend.URL)
+					lb.DecrementConnections(backend.URL)
+				}
+			}()
+		}
+		wg.Wait()
+
+		if busy, idle := lb.connections.get("busy"), lb.connections.get("idle"); busy != 10 || idle != 0 {
+			t.Errorf("Expected in-flight counts busy=10 idle=0, got busy=%d idle=%d", busy, idle)
+		}
+	})
+}
+
+func BenchmarkLoadBalancers(b *testing.B) {
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(os.Stderr)
+
+	breakers := DefaultCircuitBreakerConfig()
+	backends := make([]Backend, 10)
+	for i := range backends {
+		backends[i] = Backend{
+			URL:            fmt.Sprintf("http://backend-%d:8000", i),
+			Healthy:        true,
+			Weight:         i%3 + 1,
+			CircuitBreaker: NewCircuitBreaker(breakers),
+		}
+	}
+
+	for _, strategy := range knownStrategies {
+		b.Run(string(strategy), func(b *testing.B) {
+			lb := NewLoadBalancer(strategy)
+			tracker, tracks := lb.(connectionTracker)
+
+			b.RunParallel(func(pb *testing.PB) {
+				for pb.Next() {
+					backend, err := lb.SelectBackend(backends)
+					if err != nil {
+						b.Fatal(err)
+					}
+					if tracks {
+						tracker.IncrementConnections(backend.URL)
+						tracker.DecrementConnections(backend.URL)
+					}
+				}
+			})
+		})
+	}
+}
+
+func TestPeakEWMABalancer(t *testing.T) {
+	breakers := DefaultCircuitBreakerConfig()
+	backends := []Backend{
+		{URL: "fast", Healthy: true, Weight: 1, CircuitBreaker: NewCircuitBreaker(breakers)},
+		{URL: "slow", Healthy: true, Weight: 1, CircuitBreaker: NewCircuitBreaker(breakers)},
+	}
+
+	selectURL := func(lb *PeakEWMABalancer) string {
+		backend, err := lb.SelectBackend(backends)
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		return backend.URL
+	}
+
+	// reads decay with the time since the last sample, which in a test is a few microseconds
+	about := func(got time.Duration, want time.Duration) bool {
+		return got <= want && got > want-time.Millisecond
+	}
+
+	t.Run("PrefersLowerLatency", func(t *testing.T) {
+		lb := NewPeakEWMABalancer()
+		lb.ObserveLatency("fast", 10*time.Millisecond, 200)
+		lb.ObserveLatency("slow", 100*time.Millisecond, 200)
+
+		for i := 0; i < 20; i++ {
+			if got := selectURL(lb); got != "fast" {
+				t.Fatalf("Expected fast backend, got %s", got)
+			}
+		}
+	})
+
+	t.Run("WeighsRequestsInFlight", func(t *testing.T) {
+		lb := NewPeakEWMABalancer()
+		lb.ObserveLatency("fast", 10*time.Millisecond, 200)
+		lb.ObserveLatency("slow", 100*time.Millisecond, 200)
+		for i := 0; i < 20; i++ {
+			lb.IncrementConnections("fast")
+		}
+
+		if got := selectURL(lb); got != "slow" {
+			t.Errorf("Expected slow backend once the fast one is busy, got %s", got)
+		}
+	})
+
+	t.Run("JumpsToPeakAndDecaysSlowly", func(t *testing.T) {
+		lb := NewPeakEWMABalancer()
+		lb.ObserveLatency("fast", 10*time.Millisecond, 200)
+		lb.ObserveLatency("fast", 200*time.Millisecond, 200)
+
+		if got := time.Duration(lb.latency("fast")); !about(got, 200*time.Millisecond) {
+			t.Fatalf("Expected latency to jump to 200ms, got %v", got)
+		}
+
+		lb.ObserveLatency("fast", 10*time.Millisecond, 200)
+		if got := time.Duration(lb.latency("fast")); got < 190*time.Millisecond {
+			t.Errorf("Expected latency to decay slowly from the peak, got %v", got)
+		}
+	})
+
+	t.Run("PenalizesFastFailures", func(t *testing.T) {
+		lb := NewPeakEWMABalancer()
+		lb.ObserveLatency("fast", 10*time.Millisecond, 200)
+		lb.ObserveLatency("fast", time.Millisecond, 503)
+
+		if got := time.Duration(lb.latency("fast")); !about(got, 20*time.Millisecond) {
+			t.Errorf("Expected a failure to count as 20ms, got %v", got)
+		}
+	})
+
+	t.Run("DecaysWhileIdle", func(t *testing.T) {
+		lb := NewPeakEWMABalancer()
+		lb.ObserveLatency("slow", 100*time.Millisecond, 200)
+
+		state, _ := lb.latencies.Load("slow")
+		s := state.(*peakEWMAState)
+		s.mutex.Lock()
+		s.observed = s.observed.Add(-peakEWMADecay)
+		s.mutex.Unlock()
+
+		// e^-1 of the last sample
+		if got := time.Duration(lb.latency("slow")); got < 36*time.Millisecond || got > 37*time.Millisecond {
+			t.Errorf("Expected an idle backend's latency to decay to about 37ms, got %v", got)
+		}
+	})
+
+	t.Run("ForgetsRemovedBackends", func(t *testing.T) {
+		lb := NewPeakEWMABalancer()
+		for i := 0; i < 50; i++ {
+			lb.ObserveLatency(fmt.Sprintf("gone-%d", i), time.Millisecond, 200)
+		}
+		lb.ObserveLatency("fast", time.Millisecond, 200)
+
+		selectURL(lb)
+
+		if got := lb.tracked.Load(); got != 1 {
+			t.Errorf("Expected only the remaining backend to be tracked, got %d", got)
+		}
+		if _, ok := lb.latencies.Load("fast"); !ok {
+			t.Error("Expected the remaining backend's latency to be kept")
+		}
+	})
+
+	t.Run("ProbesUnknownBackends", func(t *testing.T) {
+		lb := NewPeakEWMABalancer()
+		lb.ObserveLatency("fast", 10*time.Millisecond, 200)
+		lb.IncrementConnections("fast")
+
+		if got := selectURL(lb); got != "slow" {
+			t.Errorf("Expected the backend without samples to be tried, got %s", got)
+		}
+
+		lb.IncrementConnections("slow")
+		if got := selectURL(lb); got != "fast" {
+			t.Errorf("Expected no second request before the first response, got %s", got)
+		}
+	})
+
+	t.Run("ProxyFeedsLatencies", func(t *testing.T) {
+		hits := make(map[string]int)
+		newBackend := func(name string, delay time.Duration) *httptest.Server {
+			return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				time.Sleep(delay)
+				hits[name]++
+			}))
+		}
+
+		fast := newBackend("fast", 0)
+		defer fast.Close()
+		slow := newBackend("slow", 30*time.Millisecond)
+		defer slow.Close()
+
+		proxy := NewProxy(testConfig(Route{
+			Pattern:      "/api/*",
+			Backends:     []Backend{{URL: fast.URL, Healthy: true, Weight: 1}, {URL: slow.URL, Healthy: true, Weight: 1}},
+			LoadBalancer: PeakEWMA,
+		}), DefaultTimeoutConfig())
+
+		for i := 0; i < 20; i++ {
+			proxy.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/api/items", nil))
+		}
+
+		if hits["fast"] < 18 {
+			t.Errorf("Expected nearly all requests on the fast backend, got %v", hits)
+		}
+	})
+}
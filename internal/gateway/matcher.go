@@ -0,0 +1,427 @@
+package gateway
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// Segment kinds, ordered from least to most specific
+type segmentKind int
+
+const (
+	segmentParam   segmentKind = iota + 1 // {id}
+	segmentRegex                          // {id:[0-9]+}
+	segmentLiteral                        // users
+)
+
+type patternSegment struct {
+	kind    segmentKind
+	literal string
+	name    string
+	regex   *regexp.Regexp
+}
+
+// A route with its pattern parsed, ready to be matched against requests
+type compiledRoute struct {
+	route      *Route
+	key        string
+	index      int // position in the config, breaks ties between equally specific routes
+	segments   []patternSegment
+	wildcard   bool // pattern ends with /*
+	params     int  // number of values captured on match, including the wildcard
+	predicates int
+}
+
+// The route chosen for a request, with the values captured by its path parameters.
+// A trailing wildcard is captured under the name "*".
+type RouteMatch struct {
+	Route  *Route
+	Params RouteParams
+	key    string
+}
+
+type RouteParam struct {
+	Name  string
+	Value string
+}
+
+// A slice rather than a map, routes capture only a handful of params and this keeps matching cheap
+type RouteParams []RouteParam
+
+func (rp RouteParams) Get(name string) string {
+	for _, param := range rp {
+		if param.Name == name {
+			return param.Value
+		}
+	}
+	return ""
+}
+
+// Parses patterns like /api/{version:v[0-9]+}/users/{id} or /api/users/*
+func compilePattern(pattern string) ([]patternSegment, bool, error) {
+	if !strings.HasPrefix(pattern, "/") {
+		return nil, false, fmt.Errorf("must start with \"/\", got %q", pattern)
+	}
+
+	rest := strings.TrimPrefix(pattern, "/")
+	wildcard := false
+	if rest == "*" || strings.HasSuffix(rest, "/*") {
+		wildcard = true
+		rest = strings.TrimSuffix(strings.TrimSuffix(rest, "*"), "/")
+	}
+
+	if rest == "" {
+		return nil, wildcard, nil
+	}
+
+	seen := make(map[string]bool)
+	parts := strings.Split(rest, "/")
+	segments := make([]patternSegment, 0, len(parts))
+
+	for _, part := range parts {
+		if !strings.HasPrefix(part, "{") {
+			if strings.ContainsAny(part, "{}*") {
+				return nil, false, fmt.Errorf("invalid segment %q (wildcard is only supported as a trailing \"/*\")", part)
+			}
+			segments = append(segments, patternSegment{kind: segmentLiteral, literal: part})
+			continue
+		}
+
+		if !strings.HasSuffix(part, "}") {
+			return nil, false, fmt.Errorf("unterminated parameter %q", part)
+		}
+
+		name, expr, hasRegex := strings.Cut(part[1:len(part)-1], ":")
+		if name == "" || name == "*" {
+			return nil, false, fmt.Errorf("parameter %q needs a name", part)
+		}
+		if seen[name] {
+			return nil, false, fmt.Errorf("duplicate parameter name %q", name)
+		}
+		seen[name] = true
+
+		if !hasRegex {
+			segments = append(segments, patternSegment{kind: segmentParam, name: name})
+			continue
+		}
+
+		regex, err := regexp.Compile("^(?:" + expr + ")$")
+		if err != nil {
+			return nil, false, fmt.Errorf("invalid regex for parameter %q: %w", name, err)
+		}
+		segments = append(segments, patternSegment{kind: segmentRegex, name: name, regex: regex})
+	}
+
+	return segments, wildcard, nil
+}
+
+func compileRoute(route *Route, index int) (*compiledRoute, error) {
+	segments, wildcard, err := compilePattern(route.Pattern)
+	if err != nil {
+		return nil, err
+	}
+
+	params := 0
+	for _, segment := range segments {
+		if segment.kind != segmentLiteral {
+			params++
+		}
+	}
+	if wildcard {
+		params++
+	}
+
+	predicates := len(route.Headers) + len(route.Query)
+	if len(route.Methods) > 0 {
+		predicates++
+	}
+	if route.Host != "" {
+		predicates++
+	}
+
+	return &compiledRoute{
+		route:      route,
+		key:        route.key(),
+		index:      index,
+		segments:   segments,
+		wildcard:   wildcard,
+		params:     params,
+		predicates: predicates,
+	}, nil
+}
+
+// Splits a path into segments, reusing buf to avoid allocating for typical depths
+func splitPath(path string, buf []string) []string {
+	rest := strings.TrimPrefix(path, "/")
+	if rest == "" {
+		return buf[:0]
+	}
+
+	parts := buf[:0]
+	for {
+		i := strings.IndexByte(rest, '/')
+		if i < 0 {
+			return append(parts, rest)
+		}
+		parts = append(parts, rest[:i])
+		rest = rest[i+1:]
+	}
+}
+
+func (cr *compiledRoute) matchPath(path string, parts []string) (RouteParams, bool) {
+	if len(parts) < len(cr.segments) || (!cr.wildcard && len(parts) != len(cr.segments)) {
+		return nil, false
+	}
+
+	for i, segment := range cr.segments {
+		part := parts[i]
+
+		switch segment.kind {
+		case segmentLiteral:
+			if part != segment.literal {
+				return nil, false
+			}
+		case segmentParam:
+			if part == "" {
+				return nil, false
+			}
+		case segmentRegex:
+			if !segment.regex.MatchString(part) {
+				return nil, false
+			}
+		}
+	}
+
+	return cr.captureParams(path, parts), true
+}
+
+// Extracts param values from a path already known to match
+func (cr *compiledRoute) captureParams(path string, parts []string) RouteParams {
+	if cr.params == 0 {
+		return nil
+	}
+
+	params := make(RouteParams, 0, cr.params)
+	for i, segment := range cr.segments {
+		if segment.kind != segmentLiteral {
+			params = append(params, RouteParam{Name: segment.name, Value: parts[i]})
+		}
+	}
+
+	if cr.wildcard {
+		params = append(params, RouteParam{Name: "*", Value: pathRemainder(path, len(cr.segments))})
+	}
+
+	return params
+}
+
+// Returns what is left of path after skipping its first n segments
+func pathRemainder(path string, n int) string {
+	rest := strings.TrimPrefix(path, "/")
+	for ; n > 0; n-- {
+		i := strings.IndexByte(rest, '/')
+		if i < 0 {
+			return ""
+		}
+		rest = rest[i+1:]
+	}
+	return rest
+}
+
+func (cr *compiledRoute) matchPredicates(r *http.Request) bool {
+	if cr.predicates == 0 {
+		return true
+	}
+	route := cr.route
+
+	if len(route.Methods) > 0 {
+		allowed := false
+		for _, method := range route.Methods {
+			if strings.EqualFold(method, r.Method) {
+				allowed = true
+				break
+			}
+		}
+		if !allowed {
+			return false
+		}
+	}
+
+	if route.Host != "" && !matchesHost(route.Host, r.Host) {
+		return false
+	}
+
+	for name, expected := range route.Headers {
+		if !matchesValue(expected, r.Header.Values(name)) {
+			return false
+		}
+	}
+
+	if len(route.Query) > 0 {
+		query := r.URL.Query()
+		for name, expected := range route.Query {
+			if !matchesValue(expected, query[name]) {
+				return false
+			}
+		}
+	}
+
+	return true
+}
+
+// "*" only requires the header or query parameter to be present
+func matchesValue(expected string, values []string) bool {
+	if len(values) == 0 {
+		return false
+	}
+	if expected == "*" {
+		return true
+	}
+	for _, value := range values {
+		if value == expected {
+			return true
+		}
+	}
+	return false
+}
+
+// Supports exact hosts and a leading wildcard label (*.example.com)
+func matchesHost(pattern, host string) bool {
+	if hostname, _, err := net.SplitHostPort(host); err == nil {
+		host = hostname
+	}
+	host = strings.ToLower(host)
+	pattern = strings.ToLower(pattern)
+
+	if strings.HasPrefix(pattern, "*.") {
+		return strings.HasSuffix(host, pattern[1:]) && len(host) > len(pattern)-1
+	}
+	return host == pattern
+}
+
+// reports whether a should be tried before b
+func moreSpecific(a, b *compiledRoute) bool {
+	for i := 0; i < len(a.segments) && i < len(b.segments); i++ {
+		if a.segments[i].kind != b.segments[i].kind {
+			return a.segments[i].kind > b.segments[i].kind
+		}
+	}
+
+	if len(a.segments) != len(b.segments) {
+		return len(a.segments) > len(b.segments)
+	}
+
+	if a.wildcard != b.wildcard {
+		return !a.wildcard
+	}
+
+	if a.predicates != b.predicates {
+		return a.predicates > b.predicates
+	}
+
+	return a.index < b.index
+}
+
+// reports whether a and b match exactly the same paths, ignoring parameter names
+func sameShape(a, b *compiledRoute) bool {
+	if a.wildcard != b.wildcard || len(a.segments) != len(b.segments) {
+		return false
+	}
+
+	for i := range a.segments {
+		sa, sb := a.segments[i], b.segments[i]
+		if sa.kind != sb.kind || sa.literal != sb.literal {
+			return false
+		}
+		if sa.kind == segmentRegex && sa.regex.String() != sb.regex.String() {
+			return false
+		}
+	}
+
+	return true
+}
+
+// Identifies a route by its pattern and predicates, stable across reloads
+func (r *Route) key() string {
+	if predicates := r.predicateKey(); predicates != "" {
+		return r.Pattern + " [" + predicates + "]"
+	}
+	return r.Pattern
+}
+
+func (r *Route) predicateKey() string {
+	var predicates []string
+
+	if len(r.Methods) > 0 {
+		methods := make([]string, len(r.Methods))
+		for i, method := range r.Methods {
+			methods[i] = strings.ToUpper(method)
+		}
+		sort.Strings(methods)
+		predicates = append(predicates, "methods="+strings.Join(methods, ","))
+	}
+
+	if r.Host != "" {
+		predicates = append(predicates, "host="+strings.ToLower(r.Host))
+	}
+
+	predicates = append(predicates, sortedPairs("header:", r.Headers)...)
+	predicates = append(predicates, sortedPairs("query:", r.Query)...)
+
+	return strings.Join(predicates, " ")
+}
+
+func sortedPairs(prefix string, values map[string]string) []string {
+	pairs := make([]string, 0, len(values))
+	for name, value := range values {
+		pairs = append(pairs, prefix+http.CanonicalHeaderKey(name)+"="+value)
+	}
+	sort.Strings(pairs)
+	return pairs
+}
+
+// Expands {name} and {*} in a rewrite template with the matched params. The
+// template is scanned once, so a value that itself contains {other} is copied
+// as is rather than expanded again.
+func expandRewrite(template string, params RouteParams) string {
+	var path strings.Builder
+	last := 0
+	for _, match := range rewriteParam.FindAllStringSubmatchIndex(template, -1) {
+		joinPath(&path, template[last:match[0]])
+		joinPath(&path, params.Get(template[match[2]:match[3]]))
+		last = match[1]
+	}
+	joinPath(&path, template[last:])
+
+	expanded := path.String()
+	if len(expanded) > 1 && strings.HasSuffix(expanded, "/") && !strings.HasSuffix(template, "/") {
+		expanded = strings.TrimSuffix(expanded, "/")
+	}
+	if !strings.HasPrefix(expanded, "/") {
+		expanded = "/" + expanded
+	}
+	return expanded
+}
+
+// Appends a piece of the rewritten path, dropping the doubled slash where an
+// empty param leaves two separators next to each other
+func joinPath(path *strings.Builder, piece string) {
+	if strings.HasSuffix(path.String(), "/") && strings.HasPrefix(piece, "/") {
+		piece = piece[1:]
+	}
+	path.WriteString(piece)
+}
+
+var rewriteParam = regexp.MustCompile(`\{([^{}]+)\}`)
+
+func rewriteParams(template string) []string {
+	var names []string
+	for _, match := range rewriteParam.FindAllStringSubmatch(template, -1) {
+		names = append(names, match[1])
+	}
+	return names
+}
@@ -0,0 +1,171 @@
+package gateway
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestMatchRequest(t *testing.T) {
+	config := &GatewayConfig{
+		Routes: []Route{
+			{Pattern: "/api/*"},
+			{Pattern: "/api/users/*"},
+			{Pattern: "/api/users/{id}"},
+			{Pattern: "/api/users/{id:[0-9]+}"},
+			{Pattern: "/api/users/me"},
+			{Pattern: "/api/llm/*", Methods: []string{"POST"}},
+			{Pattern: "/api/llm/*"},
+			{Pattern: "/api/tenants/*", Host: "*.example.com"},
+			{Pattern: "/api/tenants/*", Headers: map[string]string{"X-Api-Version": "2"}},
+			{Pattern: "/api/search", Query: map[string]string{"beta": "*"}},
+			{Pattern: "/api/search"},
+			{Pattern: "/users"},
+		},
+	}
+
+	tests := []struct {
+		name    string
+		method  string
+		path    string
+		host    string
+		headers map[string]string
+		want    int // index into config.Routes, -1 for no match
+		params  map[string]string
+	}{
+		{name: "LiteralBeatsParams", path: "/api/users/me", want: 4},
+		{name: "RegexBeatsParam", path: "/api/users/42", want: 3, params: map[string]string{"id": "42"}},
+		{name: "ParamWhenRegexFails", path: "/api/users/alice", want: 2, params: map[string]string{"id": "alice"}},
+		{name: "LongerWildcardWins", path: "/api/users/42/orders", want: 1, params: map[string]string{"*": "42/orders"}},
+		{name: "WildcardMatchesItsPrefix", path: "/api/users", want: 1},
+		{name: "WildcardRespectsSegmentBoundary", path: "/api/usersXYZ", want: 0, params: map[string]string{"*": "usersXYZ"}},
+		{name: "MethodPredicate", method: "POST", path: "/api/llm/chat", want: 5},
+		{name: "MethodPredicateFallsThrough", method: "GET", path: "/api/llm/chat", want: 6},
+		{name: "HostPredicate", path: "/api/tenants/1", host: "acme.example.com:8080", want: 7},
+		{name: "HeaderPredicate", path: "/api/tenants/1", headers: map[string]string{"X-Api-Version": "2"}, want: 8},
+		{name: "PredicatesNotMet", path: "/api/tenants/1", host: "example.org", want: 0},
+		{name: "QueryPredicate", path: "/api/search?beta=1", want: 9},
+		{name: "QueryPredicateNotMet", path: "/api/search?q=go", want: 10},
+		{name: "ExactMatch", path: "/users", want: 11},
+		{name: "NoMatch", path: "/users/42", want: -1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			method := tt.method
+			if method == "" {
+				method = "GET"
+			}
+
+			req := httptest.NewRequest(method, tt.path, nil)
+			if tt.host != "" {
+				req.Host = tt.host
+			}
+			for name, value := range tt.headers {
+				req.Header.Set(name, value)
+			}
+
+			match, err := config.MatchRequest(req)
+			if tt.want == -1 {
+				if err == nil {
+					t.Errorf("Expected no match, got %s", match.Route.key())
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("Expected match, got error %v", err)
+			}
+
+			if match.Route != &config.Routes[tt.want] {
+				t.Errorf("Expected route %s, got %s", config.Routes[tt.want].key(), match.Route.key())
+			}
+
+			for name, value := range tt.params {
+				if match.Params.Get(name) != value {
+					t.Errorf("Expected param %s=%q, got %q", name, value, match.Params.Get(name))
+				}
+			}
+		})
+	}
+}
+
+func TestProxyRewriteAndParamHeaders(t *testing.T) {
+	var gotPath, gotParam string
+	var gotHeaders http.Header
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotPath = r.URL.Path
+		gotParam = r.Header.Get("X-Route-Param-Id")
+		gotHeaders = r.Header
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer backend.Close()
+
+	t.Run("RewritesPath", func(t *testing.T) {
+		proxy := NewProxy(testConfig(
+			Route{Pattern: "/api/v1/users/{id}/*", Target: backend.URL, Rewrite: "/users/{id}/{*}"},
+		), DefaultTimeoutConfig())
+
+		w := httptest.NewRecorder()
+		proxy.ServeHTTP(w, httptest.NewRequest("GET", "/api/v1/users/42/orders/7", nil))
+
+		if w.Code != http.StatusOK {
+			t.Fatalf("Expected status 200, got %d", w.Code)
+		}
+
+		if gotPath != "/users/42/orders/7" {
+			t.Errorf("Expected rewritten path /users/42/orders/7, got %s", gotPath)
+		}
+
+		if gotParam != "42" {
+			t.Errorf("Expected X-Route-Param-Id 42, got %q", gotParam)
+		}
+	})
+
+	t.Run("DoesNotExpandParamValues", func(t *testing.T) {
+		proxy := NewProxy(testConfig(
+			Route{Pattern: "/api/v1/users/{id}/posts/{post}", Target: backend.URL, Rewrite: "/posts/{post}/users/{id}"},
+		), DefaultTimeoutConfig())
+
+		proxy.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/api/v1/users/%7Bpost%7D/posts/7", nil))
+
+		if gotPath != "/posts/7/users/{post}" {
+			t.Errorf("Expected the captured {post} to be kept as is, got %s", gotPath)
+		}
+	})
+
+	t.Run("KeepsTargetPath", func(t *testing.T) {
+		proxy := NewProxy(testConfig(
+			Route{Pattern: "/api/v1/users/{id}", Target: backend.URL + "/v1", Rewrite: "/users/{id}"},
+			Route{Pattern: "/legacy/*", Target: backend.URL + "/v2", StripPrefix: "/legacy"},
+		), DefaultTimeoutConfig())
+
+		proxy.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/api/v1/users/42", nil))
+		if gotPath != "/v1/users/42" {
+			t.Errorf("Expected the rewrite under the target's /v1, got %s", gotPath)
+		}
+
+		proxy.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/legacy/orders", nil))
+		if gotPath != "/v2/orders" {
+			t.Errorf("Expected the stripped path under the target's /v2, got %s", gotPath)
+		}
+	})
+
+	t.Run("DropsSpoofedParamHeaders", func(t *testing.T) {
+		proxy := NewProxy(testConfig(
+			Route{Pattern: "/api/v1/users/{id}", Target: backend.URL},
+		), DefaultTimeoutConfig())
+
+		req := httptest.NewRequest("GET", "/api/v1/users/42", nil)
+		req.Header.Set("X-Route-Param-Id", "1")
+		req.Header.Set("X-Route-Param-Role", "admin")
+		proxy.ServeHTTP(httptest.NewRecorder(), req)
+
+		if gotParam != "42" || gotHeaders.Get("X-Route-Param-Role") != "" {
+			t.Errorf("Expected only the matched params to reach the backend, got %v", gotHeaders)
+		}
+		if len(gotHeaders.Values("X-Route-Param-Id")) != 1 {
+			t.Errorf("Expected a single X-Route-Param-Id, got %q", gotHeaders.Values("X-Route-Param-Id"))
+		}
+	})
+}
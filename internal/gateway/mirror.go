@@ -0,0 +1,229 @@
+package gateway
+
+import (
+	"context"
+	"log"
+	"math/rand"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"sync/atomic"
+	"time"
+)
+
+// Copies a share of a route's requests to a shadow backend. Shadow responses are
+// discarded, only their status and latency are compared with the primary's.
+type MirrorConfig struct {
+	URL           string        `json:"url"`
+	Percent       float64       `json:"percent"` // share of requests copied, 0-100
+	Timeout       time.Duration `json:"timeout"`
+	MaxConcurrent int           `json:"max_concurrent"` // shadow requests in flight, extra copies are dropped
+}
+
+func DefaultMirrorConfig() MirrorConfig {
+	return MirrorConfig{
+		Percent:       100,
+		Timeout:       5 * time.Second,
+		MaxConcurrent: 10,
+	}
+}
+
+func (c *MirrorConfig) UnmarshalJSON(data []byte) error {
+	type alias MirrorConfig
+	defaults := DefaultMirrorConfig()
+	aux := struct {
+		*alias
+		Timeout Duration `json:"timeout"`
+	}{
+		alias:   (*alias)(&defaults),
+		Timeout: Duration(defaults.Timeout),
+	}
+
+	if err := decodeStrict(data, &aux); err != nil {
+		return err
+	}
+
+	*c = defaults
+	c.Timeout = time.Duration(aux.Timeout)
+	return nil
+}
+
+type requestMirror struct {
+	config   MirrorConfig
+	bulkhead *ServiceBulkhead // bounds shadow concurrency, never queues
+
+	sampled        atomic.Int64
+	dropped        atomic.Int64
+	completed      atomic.Int64
+	failed         atomic.Int64
+	mismatches     atomic.Int64
+	primaryLatency atomic.Int64 // nanoseconds summed over completed copies
+	shadowLatency  atomic.Int64
+}
+
+func newRequestMirror(config MirrorConfig) *requestMirror {
+	return &requestMirror{
+		config: config,
+		bulkhead: NewServiceBulkhead(BulkheadConfig{
+			MaxConcurrentRequests: config.MaxConcurrent,
+			QueueSize:             0,
+			QueueTimeout:          config.Timeout,
+		}),
+	}
+}
+
+type mirrorResult struct {
+	status  int
+	latency time.Duration
+}
+
+// A shadow request in flight, waiting for the primary's result to compare against
+type mirroredRequest struct {
+	primary chan mirrorResult
+}
+
+// Reports how the primary request went. Never blocks, safe to call on nil.
+func (mr *mirroredRequest) primaryDone(status int, latency time.Duration) {
+	if mr == nil {
+		return
+	}
+	mr.primary <- mirrorResult{status: status, latency: latency}
+}
+
+// Whether a request gets mirrored, decided before its body is read so bodies
+// of requests that aren't mirrored don't have to be kept. Safe to call on nil.
+func (m *requestMirror) sample() bool {
+	if m == nil || rand.Float64()*100 >= m.config.Percent {
+		return false
+	}
+	m.sampled.Add(1)
+	return true
+}
+
+// Sends a copy of r to the route's shadow backend in the background, for a
+// request that was sampled. Returns nil when there is no mirror or the shadow
+// backend is already at capacity.
+func (p *Proxy) startMirror(mirror *requestMirror, r *http.Request, body *requestBody, match *RouteMatch, lb LoadBalancer) *mirroredRequest {
+	if mirror == nil {
+		return nil
+	}
+
+	// the primary request gets the only read of a body that can't be replayed
+	if !body.retain() {
+		mirror.dropped.Add(1)
+		return nil
+	}
+
+	// detached from the client's context so the copy outlives the primary request
+	ctx, cancel := context.WithTimeout(context.Background(), mirror.config.Timeout)
+
+	if err := mirror.bulkhead.TryAcquire(ctx); err != nil {
+		cancel()
+		body.release()
+		mirror.dropped.Add(1)
+		return nil
+	}
+
+	shadow := r.Clone(ctx)
+	shadow.Body = body.reader()
+
+	mirrored := &mirroredRequest{primary: make(chan mirrorResult, 1)}
+
+	go func() {
+		defer cancel()
+		defer body.release()
+
+		start := time.Now()
+		status := p.sendShadow(shadow, mirror.config.URL, match, lb)
+		shadowLatency := time.Since(start)
+		mirror.bulkhead.Release()
+
+		primary := <-mirrored.primary
+		mirror.record(primary, mirrorResult{status: status, latency: shadowLatency})
+	}()
+
+	return mirrored
+}
+
+func (p *Proxy) sendShadow(r *http.Request, shadowURL string, match *RouteMatch, lb LoadBalancer) int {
+	targetURL, err := url.Parse(shadowURL)
+	if err != nil {
+		log.Printf("⚠️ Invalid mirror URL %s: %v", shadowURL, err)
+		return http.StatusBadGateway
+	}
+
+	backend := &Backend{URL: shadowURL}
+	proxy := httputil.NewSingleHostReverseProxy(targetURL)
+
+	originalDirector := proxy.Director
+	proxy.Director = func(req *http.Request) {
+		rewritePath(req, match)
+		originalDirector(req)
+		p.customizeRequest(req, match, backend, lb)
+		req.Header.Set("X-Gateway-Mirror", "true")
+	}
+
+	proxy.ErrorHandler = func(w http.ResponseWriter, r *http.Request, err error) {
+		log.Printf("🪞 Mirror request to %s failed: %v", shadowURL, err)
+		w.WriteHeader(http.StatusBadGateway)
+	}
+
+	tracker := &statusTracker{ResponseWriter: &discardResponseWriter{}, status: 200}
+	proxy.ServeHTTP(tracker, r)
+	return tracker.status
+}
+
+func (m *requestMirror) record(primary, shadow mirrorResult) {
+	m.completed.Add(1)
+	m.primaryLatency.Add(int64(primary.latency))
+	m.shadowLatency.Add(int64(shadow.latency))
+
+	if shadow.status >= 500 {
+		m.failed.Add(1)
+	}
+
+	if primary.status != shadow.status {
+		m.mismatches.Add(1)
+		log.Printf("🪞 Mirror status mismatch for %s: primary=%d shadow=%d", m.config.URL, primary.status, shadow.status)
+	}
+}
+
+func (m *requestMirror) GetStats() map[string]any {
+	completed := m.completed.Load()
+
+	var avgPrimary, avgShadow time.Duration
+	if completed > 0 {
+		avgPrimary = time.Duration(m.primaryLatency.Load() / completed)
+		avgShadow = time.Duration(m.shadowLatency.Load() / completed)
+	}
+
+	return map[string]any{
+		"url":                 m.config.URL,
+		"percent":             m.config.Percent,
+		"sampled":             m.sampled.Load(),
+		"dropped":             m.dropped.Load(),
+		"completed":           completed,
+		"shadow_failures":     m.failed.Load(),
+		"status_mismatches":   m.mismatches.Load(),
+		"avg_primary_latency": avgPrimary.String(),
+		"avg_shadow_latency":  avgShadow.String(),
+		"bulkhead":            m.bulkhead.GetStats(),
+	}
+}
+
+type discardResponseWriter struct {
+	header http.Header
+}
+
+func (d *discardResponseWriter) Header() http.Header {
+	if d.header == nil {
+		d.header = make(http.Header)
+	}
+	return d.header
+}
+
+func (d *discardResponseWriter) Write(data []byte) (int, error) {
+	return len(data), nil
+}
+
+func (d *discardResponseWriter) WriteHeader(status int) {}
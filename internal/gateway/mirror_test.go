@@ -0,0 +1,137 @@
+package gateway
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func waitFor(t *testing.T, condition func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !condition() {
+		if time.Now().After(deadline) {
+			t.Fatal("Condition not met within 5s")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestRequestMirror(t *testing.T) {
+	t.Run("ShadowSeesSameBodyAndIsCompared", func(t *testing.T) {
+		primaryBodies := make(chan string, 1)
+		primary := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			body, _ := io.ReadAll(r.Body)
+			primaryBodies <- string(body)
+			w.WriteHeader(http.StatusOK)
+		}))
+		defer primary.Close()
+
+		shadowBodies := make(chan string, 1)
+		shadow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			body, _ := io.ReadAll(r.Body)
+			if r.Header.Get("X-Gateway-Mirror") != "true" {
+				t.Error("Expected shadow request to carry X-Gateway-Mirror header")
+			}
+			shadowBodies <- string(body)
+			w.WriteHeader(http.StatusInternalServerError)
+		}))
+		defer shadow.Close()
+
+		mirror := DefaultMirrorConfig()
+		mirror.URL = shadow.URL
+
+		proxy := NewProxy(testConfig(
+			Route{Pattern: "/api/*", Target: primary.URL, Mirror: &mirror},
+		), DefaultTimeoutConfig())
+
+		w := httptest.NewRecorder()
+		proxy.ServeHTTP(w, httptest.NewRequest("POST", "/api/orders", strings.NewReader(`{"id":1}`)))
+
+		if w.Code != http.StatusOK {
+			t.Fatalf("Expected primary status 200, got %d", w.Code)
+		}
+
+		if got := <-primaryBodies; got != `{"id":1}` {
+			t.Errorf("Expected primary body {\"id\":1}, got %q", got)
+		}
+
+		if got := <-shadowBodies; got != `{"id":1}` {
+			t.Errorf("Expected shadow body {\"id\":1}, got %q", got)
+		}
+
+		stats := proxy.snapshot.Load().mirrors["/api/*"]
+		waitFor(t, func() bool { return stats.completed.Load() == 1 })
+
+		if stats.mismatches.Load() != 1 || stats.failed.Load() != 1 {
+			t.Errorf("Expected one status mismatch and one shadow failure, got %v", stats.GetStats())
+		}
+	})
+
+	t.Run("SlowShadowNeverBlocksPrimary", func(t *testing.T) {
+		primary := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusOK)
+		}))
+		defer primary.Close()
+
+		release := make(chan struct{})
+		shadow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			<-release
+			w.WriteHeader(http.StatusOK)
+		}))
+		defer shadow.Close()
+		defer close(release)
+
+		mirror := DefaultMirrorConfig()
+		mirror.URL = shadow.URL
+		mirror.MaxConcurrent = 1
+
+		proxy := NewProxy(testConfig(
+			Route{Pattern: "/api/*", Target: primary.URL, Mirror: &mirror},
+		), DefaultTimeoutConfig())
+
+		for i := 0; i < 2; i++ {
+			start := time.Now()
+			w := httptest.NewRecorder()
+			proxy.ServeHTTP(w, httptest.NewRequest("GET", "/api/orders", nil))
+
+			if w.Code != http.StatusOK {
+				t.Errorf("Expected primary status 200, got %d", w.Code)
+			}
+			if elapsed := time.Since(start); elapsed > time.Second {
+				t.Errorf("Expected primary to finish without waiting on the shadow, took %v", elapsed)
+			}
+		}
+
+		stats := proxy.snapshot.Load().mirrors["/api/*"]
+		if stats.sampled.Load() != 2 || stats.dropped.Load() != 1 {
+			t.Errorf("Expected second copy to be dropped at capacity, got %v", stats.GetStats())
+		}
+	})
+
+	t.Run("RespectsSamplingPercent", func(t *testing.T) {
+		primary := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusOK)
+		}))
+		defer primary.Close()
+
+		mirror := DefaultMirrorConfig()
+		mirror.URL = "http://shadow.invalid:8000"
+		mirror.Percent = 0
+
+		proxy := NewProxy(testConfig(
+			Route{Pattern: "/api/*", Target: primary.URL, Mirror: &mirror},
+		), DefaultTimeoutConfig())
+
+		for i := 0; i < 20; i++ {
+			proxy.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/api/orders", nil))
+		}
+
+		if sampled := proxy.snapshot.Load().mirrors["/api/*"].sampled.Load(); sampled != 0 {
+			t.Errorf("Expected no requests to be mirrored at 0%%, got %d", sampled)
+		}
+	})
+}
@@ -0,0 +1,314 @@
+package gateway
+
+import (
+	"log"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Ejects a route's backends based on the responses of live traffic, independent of
+// the active health checker. An ejected backend gets no requests until its ejection
+// time is up, and each repeat ejection lasts twice as long as the previous one.
+type OutlierDetectionConfig struct {
+	Consecutive5xx           int           `json:"consecutive_5xx"`            // 0 disables
+	ConsecutiveGatewayErrors int           `json:"consecutive_gateway_errors"` // 502, 503 and 504, which includes failed connections; 0 disables
+	LatencyFactor            float64       `json:"latency_factor"`             // eject backends this many times slower than the others' median, 0 disables
+	MinRequests              int           `json:"min_requests"`               // successful responses a backend needs before its latency is compared
+	BaseEjectionTime         time.Duration `json:"base_ejection_time"`
+	MaxEjectionTime          time.Duration `json:"max_ejection_time"`
+	MaxEjectionPercent       int           `json:"max_ejection_percent"` // share of the route's backends that may be ejected at once
+}
+
+func DefaultOutlierDetectionConfig() OutlierDetectionConfig {
+	return OutlierDetectionConfig{
+		Consecutive5xx:           5,
+		ConsecutiveGatewayErrors: 3,
+		MinRequests:              20,
+		BaseEjectionTime:         30 * time.Second,
+		MaxEjectionTime:          5 * time.Minute,
+		MaxEjectionPercent:       50,
+	}
+}
+
+func (c *OutlierDetectionConfig) UnmarshalJSON(data []byte) error {
+	type alias OutlierDetectionConfig
+	defaults := DefaultOutlierDetectionConfig()
+	aux := struct {
+		*alias
+		BaseEjectionTime Duration `json:"base_ejection_time"`
+		MaxEjectionTime  Duration `json:"max_ejection_time"`
+	}{
+		alias:            (*alias)(&defaults),
+		BaseEjectionTime: Duration(defaults.BaseEjectionTime),
+		MaxEjectionTime:  Duration(defaults.MaxEjectionTime),
+	}
+
+	if err := decodeStrict(data, &aux); err != nil {
+		return err
+	}
+
+	*c = defaults
+	c.BaseEjectionTime = time.Duration(aux.BaseEjectionTime)
+	c.MaxEjectionTime = time.Duration(aux.MaxEjectionTime)
+	return nil
+}
+
+// Weight of the newest sample in a backend's latency average
+const outlierLatencyDecay = 0.3
+
+type outlierState struct {
+	consecutive5xx           int
+	consecutiveGatewayErrors int
+	latency                  float64 // moving average of successful responses, in nanoseconds
+	requests                 int     // successful responses since the last ejection
+	ejections                int     // consecutive ejections, doubles the next ejection time
+	ejectedUntil             time.Time
+	lastReason               string
+}
+
+func (s *outlierState) ejected(now time.Time) bool {
+	return now.Before(s.ejectedUntil)
+}
+
+type outlierDetector struct {
+	config   OutlierDetectionConfig
+	mutex    sync.Mutex
+	backends map[string]*outlierState // backend URL -> state
+	pool     map[string]bool          // every backend the route balances over, ejection caps are a share of it
+	poolLen  int                      // length of the backend list pool was built from
+	total    int64                    // ejections since the detector was created
+}
+
+func newOutlierDetector(config OutlierDetectionConfig) *outlierDetector {
+	return &outlierDetector{
+		config:   config,
+		backends: make(map[string]*outlierState),
+	}
+}
+
+// Takes in the route's full backend set, before any split group, subset or health
+// filtering, and forgets backends that left it. Safe to call on nil.
+func (od *outlierDetector) track(backends []Backend) {
+	if od == nil {
+		return
+	}
+
+	od.mutex.Lock()
+	defer od.mutex.Unlock()
+
+	if od.samePoolLocked(backends) {
+		return
+	}
+
+	od.pool = make(map[string]bool, len(backends))
+	od.poolLen = len(backends)
+	for _, backend := range backends {
+		od.pool[backend.URL] = true
+	}
+
+	for url := range od.backends {
+		if !od.pool[url] {
+			delete(od.backends, url)
+		}
+	}
+}
+
+func (od *outlierDetector) samePoolLocked(backends []Backend) bool {
+	if len(backends) != od.poolLen {
+		return false
+	}
+	for _, backend := range backends {
+		if !od.pool[backend.URL] {
+			return false
+		}
+	}
+	return true
+}
+
+// Leaves out ejected backends. Safe to call on nil.
+func (od *outlierDetector) available(backends []Backend) []Backend {
+	if od == nil {
+		return backends
+	}
+
+	od.mutex.Lock()
+	defer od.mutex.Unlock()
+
+	now := time.Now()
+
+	available := make([]Backend, 0, len(backends))
+	for _, backend := range backends {
+		if state, exists := od.backends[backend.URL]; exists && state.ejected(now) {
+			continue
+		}
+		available = append(available, backend)
+	}
+
+	// the pool shrank under the ejections, better to try an outlier than nothing
+	if len(available) == 0 {
+		return backends
+	}
+	return available
+}
+
+// Records the outcome of a request to a backend and ejects it when it turns out
+// to be an outlier. Safe to call on nil.
+func (od *outlierDetector) record(url string, status int, latency time.Duration) {
+	if od == nil {
+		return
+	}
+
+	od.mutex.Lock()
+	defer od.mutex.Unlock()
+
+	// a request still in flight when its backend left the route
+	if !od.pool[url] {
+		return
+	}
+
+	state, exists := od.backends[url]
+	if !exists {
+		state = &outlierState{}
+		od.backends[url] = state
+	}
+
+	if status >= 500 {
+		state.consecutive5xx++
+	} else {
+		state.consecutive5xx = 0
+		state.requests++
+		if state.latency == 0 {
+			state.latency = float64(latency)
+		} else {
+			state.latency = outlierLatencyDecay*float64(latency) + (1-outlierLatencyDecay)*state.latency
+		}
+	}
+
+	switch status {
+	case 502, 503, 504:
+		state.consecutiveGatewayErrors++
+	default:
+		state.consecutiveGatewayErrors = 0
+	}
+
+	switch {
+	case od.config.ConsecutiveGatewayErrors > 0 && state.consecutiveGatewayErrors >= od.config.ConsecutiveGatewayErrors:
+		od.ejectLocked(url, state, "consecutive_gateway_errors")
+	case od.config.Consecutive5xx > 0 && state.consecutive5xx >= od.config.Consecutive5xx:
+		od.ejectLocked(url, state, "consecutive_5xx")
+	case od.latencyOutlierLocked(url, state):
+		od.ejectLocked(url, state, "latency")
+	}
+}
+
+// Whether a backend is much slower than the median of the route's other backends
+func (od *outlierDetector) latencyOutlierLocked(url string, state *outlierState) bool {
+	if od.config.LatencyFactor <= 0 || state.requests < od.config.MinRequests {
+		return false
+	}
+
+	now := time.Now()
+	var others []float64
+	for otherURL, other := range od.backends {
+		if otherURL != url && other.requests >= od.config.MinRequests && !other.ejected(now) {
+			others = append(others, other.latency)
+		}
+	}
+	if len(others) == 0 {
+		return false
+	}
+
+	sort.Float64s(others)
+	median := others[len(others)/2]
+	if len(others)%2 == 0 {
+		median = (others[len(others)/2-1] + others[len(others)/2]) / 2
+	}
+
+	return state.latency > od.config.LatencyFactor*median
+}
+
+func (od *outlierDetector) ejectLocked(url string, state *outlierState, reason string) {
+	now := time.Now()
+	if state.ejected(now) {
+		return
+	}
+
+	ejected := 0
+	for _, other := range od.backends {
+		if other.ejected(now) {
+			ejected++
+		}
+	}
+	if (ejected+1)*100 > od.config.MaxEjectionPercent*len(od.pool) {
+		log.Printf("⚠️ Not ejecting outlier %s (%s), %d of %d backends are already ejected", url, reason, ejected, len(od.pool))
+		return
+	}
+
+	// a backend that stayed in for a while starts over at the base ejection time
+	if !state.ejectedUntil.IsZero() && now.Sub(state.ejectedUntil) > od.config.MaxEjectionTime {
+		state.ejections = 0
+	}
+	state.ejections++
+
+	duration := od.config.BaseEjectionTime
+	for i := 1; i < state.ejections && duration < od.config.MaxEjectionTime; i++ {
+		duration *= 2
+	}
+	if duration > od.config.MaxEjectionTime {
+		duration = od.config.MaxEjectionTime
+	}
+
+	state.ejectedUntil = now.Add(duration)
+	state.lastReason = reason
+	state.consecutive5xx = 0
+	state.consecutiveGatewayErrors = 0
+	state.requests = 0
+	state.latency = 0
+	od.total++
+
+	log.Printf("⏏️ Ejected outlier backend %s for %v (%s, ejection #%d)", url, duration, reason, state.ejections)
+}
+
+// Ejection state of a single backend
+func (od *outlierDetector) backendStats(url string) map[string]any {
+	od.mutex.Lock()
+	defer od.mutex.Unlock()
+
+	state, exists := od.backends[url]
+	if !exists {
+		state = &outlierState{}
+	}
+
+	stats := map[string]any{
+		"ejected":                    state.ejected(time.Now()),
+		"ejections":                  state.ejections,
+		"consecutive_5xx":            state.consecutive5xx,
+		"consecutive_gateway_errors": state.consecutiveGatewayErrors,
+		"avg_latency":                time.Duration(state.latency).String(),
+	}
+	if state.ejected(time.Now()) {
+		stats["ejected_until"] = state.ejectedUntil
+		stats["reason"] = state.lastReason
+	}
+	return stats
+}
+
+func (od *outlierDetector) GetStats() map[string]any {
+	od.mutex.Lock()
+	defer od.mutex.Unlock()
+
+	now := time.Now()
+	ejected := 0
+	for _, state := range od.backends {
+		if state.ejected(now) {
+			ejected++
+		}
+	}
+
+	return map[string]any{
+		"ejected":              ejected,
+		"total_ejections":      od.total,
+		"max_ejection_percent": od.config.MaxEjectionPercent,
+	}
+}
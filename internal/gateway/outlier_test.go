@@ -0,0 +1,232 @@
+package gateway
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestOutlierDetection(t *testing.T) {
+	backends := []Backend{{URL: "http://a"}, {URL: "http://b"}, {URL: "http://c"}}
+
+	isAvailable := func(od *outlierDetector, url string) bool {
+		for _, backend := range od.available(backends) {
+			if backend.URL == url {
+				return true
+			}
+		}
+		return false
+	}
+
+	t.Run("EjectsAfterConsecutiveGatewayErrors", func(t *testing.T) {
+		od := newOutlierDetector(DefaultOutlierDetectionConfig())
+		od.track(backends)
+
+		od.record("http://a", 502, time.Millisecond)
+		od.record("http://a", 200, time.Millisecond) // a success resets the streak
+		od.record("http://a", 503, time.Millisecond)
+		od.record("http://a", 504, time.Millisecond)
+		if !isAvailable(od, "http://a") {
+			t.Fatal("Expected backend to stay available after 2 consecutive gateway errors")
+		}
+
+		od.record("http://a", 502, time.Millisecond)
+		if isAvailable(od, "http://a") {
+			t.Fatal("Expected backend to be ejected after 3 consecutive gateway errors")
+		}
+
+		if stats := od.backendStats("http://a"); stats["reason"] != "consecutive_gateway_errors" {
+			t.Errorf("Expected reason consecutive_gateway_errors, got %v", stats["reason"])
+		}
+	})
+
+	t.Run("EjectsAfterConsecutive5xx", func(t *testing.T) {
+		od := newOutlierDetector(DefaultOutlierDetectionConfig())
+		od.track(backends)
+
+		for i := 0; i < 5; i++ {
+			od.record("http://b", 500, time.Millisecond)
+		}
+
+		if isAvailable(od, "http://b") {
+			t.Fatal("Expected backend to be ejected after 5 consecutive 500s")
+		}
+	})
+
+	t.Run("EjectionTimeDoubles", func(t *testing.T) {
+		config := DefaultOutlierDetectionConfig()
+		config.ConsecutiveGatewayErrors = 1
+		config.BaseEjectionTime = 20 * time.Millisecond
+		config.MaxEjectionTime = 50 * time.Millisecond
+		od := newOutlierDetector(config)
+		od.track(backends)
+
+		expected := []time.Duration{20 * time.Millisecond, 40 * time.Millisecond, 50 * time.Millisecond}
+		for i, want := range expected {
+			start := time.Now()
+			od.record("http://a", 502, time.Millisecond)
+
+			od.mutex.Lock()
+			got := od.backends["http://a"].ejectedUntil.Sub(start)
+			od.mutex.Unlock()
+
+			if got < want || got > want+10*time.Millisecond {
+				t.Fatalf("Ejection %d: expected about %v, got %v", i+1, want, got)
+			}
+
+			waitFor(t, func() bool { return isAvailable(od, "http://a") })
+		}
+	})
+
+	t.Run("CapsEjectedPercentage", func(t *testing.T) {
+		config := DefaultOutlierDetectionConfig()
+		config.ConsecutiveGatewayErrors = 1
+		od := newOutlierDetector(config)
+		od.track(backends[:2])
+
+		od.record("http://a", 502, time.Millisecond)
+		od.record("http://b", 502, time.Millisecond)
+
+		if isAvailable(od, "http://a") || !isAvailable(od, "http://b") {
+			t.Fatalf("Expected only the first of 2 backends to be ejected at 50%%, got %v", od.GetStats())
+		}
+	})
+
+	t.Run("CapCountsTheWholeRoute", func(t *testing.T) {
+		config := DefaultOutlierDetectionConfig()
+		config.ConsecutiveGatewayErrors = 1
+		od := newOutlierDetector(config)
+		od.track(backends)
+
+		// a split group with a single backend, selection only sees that one
+		canary := backends[:1]
+		od.available(canary)
+		od.record("http://a", 502, time.Millisecond)
+
+		if od.backendStats("http://a")["ejected"] != true {
+			t.Fatalf("Expected 1 of the route's 3 backends to be ejected at 50%%, got %v", od.GetStats())
+		}
+	})
+
+	t.Run("ForgetsBackendsThatLeft", func(t *testing.T) {
+		od := newOutlierDetector(DefaultOutlierDetectionConfig())
+		od.track(backends)
+		for _, backend := range backends {
+			od.record(backend.URL, 200, time.Millisecond)
+		}
+
+		od.track(backends[1:])
+
+		od.mutex.Lock()
+		_, kept := od.backends["http://a"]
+		tracked := len(od.backends)
+		od.mutex.Unlock()
+
+		if kept || tracked != 2 {
+			t.Errorf("Expected only the 2 remaining backends to be tracked, got %d (removed one kept: %v)", tracked, kept)
+		}
+	})
+
+	t.Run("EjectsLatencyOutliers", func(t *testing.T) {
+		config := DefaultOutlierDetectionConfig()
+		config.LatencyFactor = 3
+		config.MinRequests = 5
+		od := newOutlierDetector(config)
+		od.track(backends)
+
+		for i := 0; i < config.MinRequests; i++ {
+			od.record("http://a", 200, 10*time.Millisecond)
+			od.record("http://b", 200, 12*time.Millisecond)
+			od.record("http://c", 200, 100*time.Millisecond)
+		}
+
+		if !isAvailable(od, "http://a") || !isAvailable(od, "http://b") {
+			t.Fatal("Expected fast backends to stay available")
+		}
+		if isAvailable(od, "http://c") {
+			t.Fatal("Expected slow backend to be ejected")
+		}
+	})
+
+	t.Run("ProxySkipsEjectedBackend", func(t *testing.T) {
+		var badHits atomic.Int64
+		bad := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			badHits.Add(1)
+			w.WriteHeader(http.StatusBadGateway)
+		}))
+		defer bad.Close()
+
+		good := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusOK)
+		}))
+		defer good.Close()
+
+		config := DefaultOutlierDetectionConfig()
+		config.ConsecutiveGatewayErrors = 2
+		proxy := NewProxy(testConfig(Route{
+			Pattern:          "/api/*",
+			Backends:         []Backend{{URL: bad.URL, Healthy: true, Weight: 1}, {URL: good.URL, Healthy: true, Weight: 1}},
+			OutlierDetection: &config,
+		}), DefaultTimeoutConfig())
+
+		for i := 0; i < 6; i++ {
+			proxy.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/api/items", nil))
+		}
+
+		ejectedHits := badHits.Load()
+		for i := 0; i < 6; i++ {
+			w := httptest.NewRecorder()
+			proxy.ServeHTTP(w, httptest.NewRequest("GET", "/api/items", nil))
+			if w.Code != http.StatusOK {
+				t.Fatalf("Expected status 200, got %d", w.Code)
+			}
+		}
+
+		if badHits.Load() != ejectedHits {
+			t.Errorf("Expected no requests to the ejected backend, got %d more", badHits.Load()-ejectedHits)
+		}
+
+		w := httptest.NewRecorder()
+		proxy.RoutesHandler(w, httptest.NewRequest("GET", "/admin/routes", nil))
+
+		var response struct {
+			Data []routeInfo `json:"data"`
+		}
+		if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+			t.Fatalf("Expected JSON response, got %v", err)
+		}
+
+		for _, backend := range response.Data[0].Backends {
+			if backend.CircuitBreaker["state"] == nil {
+				t.Errorf("Expected circuit breaker stats for %s", backend.URL)
+			}
+			if ejected := backend.Outlier["ejected"] == true; ejected != (backend.URL == bad.URL) {
+				t.Errorf("Expected %s ejected=%v, got %v", backend.URL, backend.URL == bad.URL, ejected)
+			}
+		}
+	})
+
+	t.Run("ValidationErrors", func(t *testing.T) {
+		data := `{"routes": [{"pattern": "/x", "target": "http://x:1", "outlier_detection": {"latency_factor": 0.5, "base_ejection_time": "1m", "max_ejection_time": "30s", "max_ejection_percent": 150}}]}`
+
+		_, err := ParseConfig([]byte(data), "json")
+		if err == nil {
+			t.Fatal("Expected validation error, got nil")
+		}
+
+		expected := []string{
+			"routes[0].outlier_detection.latency_factor: must be greater than 1",
+			"routes[0].outlier_detection.max_ejection_time: must not be less than base_ejection_time",
+			"routes[0].outlier_detection.max_ejection_percent: must be between 0 and 100",
+		}
+		for _, msg := range expected {
+			if !strings.Contains(err.Error(), msg) {
+				t.Errorf("Expected error to contain %q, got:\n%v", msg, err)
+			}
+		}
+	})
+}
@@ -0,0 +1,158 @@
+package gateway
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Implemented by balancers that learn from how requests went, the proxy reports
+// the latency and status of every attempt to them
+type latencyObserver interface {
+	ObserveLatency(url string, latency time.Duration, status int)
+}
+
+// How quickly old latency samples stop counting, the weight of a sample halves
+// roughly every 0.7 of this
+const peakEWMADecay = 10 * time.Second
+
+type peakEWMAState struct {
+	mutex    sync.Mutex
+	latency  float64 // nanoseconds
+	observed time.Time
+}
+
+// Peak EWMA as in Finagle and Linkerd: tracks a moving average of each backend's
+// latency that jumps straight up to a slower sample and decays back down over
+// time, and sends a request to the backend with the lowest average latency times
+// its requests in flight. Slow backends get fewer requests, and a backend that
+// gets slower is avoided right away.
+type PeakEWMABalancer struct {
+	connections connectionCounts
+	latencies   sync.Map // backend URL -> *peakEWMAState
+	tracked     atomic.Int64
+}
+
+func NewPeakEWMABalancer() *PeakEWMABalancer {
+	return &PeakEWMABalancer{}
+}
+
+func (pe *PeakEWMABalancer) SelectBackend(backends []Backend) (*Backend, error) {
+	healthy := getHealthyBackends(backends)
+	if len(healthy) == 0 {
+		return nil, fmt.Errorf("no healthy backends available")
+	}
+
+	// start at a random backend so ties don't all go to the first one
+	offset := rand.Intn(len(healthy))
+
+	var selected *Backend
+	lowest := math.Inf(1)
+	for n := range healthy {
+		backend := &healthy[(offset+n)%len(healthy)]
+		if cost := pe.cost(backend.URL); selected == nil || cost < lowest {
+			selected, lowest = backend, cost
+		}
+	}
+
+	// forget backends that left the pool once they make up most of the map, it would grow with registry churn otherwise
+	if pe.tracked.Load() > int64(2*len(backends)+16) {
+		pe.prune(backends)
+	}
+
+	return selected, nil
+}
+
+func (pe *PeakEWMABalancer) prune(backends []Backend) {
+	current := make(map[string]bool, len(backends))
+	for _, backend := range backends {
+		current[backend.URL] = true
+	}
+
+	pe.latencies.Range(func(url, _ any) bool {
+		if !current[url.(string)] {
+			if _, loaded := pe.latencies.LoadAndDelete(url); loaded {
+				pe.tracked.Add(-1)
+			}
+		}
+		return true
+	})
+}
+
+// Expected latency of one more request. A backend without samples is tried
+// right away, but gets nothing more until its first response is in.
+func (pe *PeakEWMABalancer) cost(url string) float64 {
+	inFlight := float64(pe.connections.get(url))
+
+	latency := pe.latency(url)
+	if latency == 0 {
+		if inFlight > 0 {
+			return math.MaxFloat64
+		}
+		return 0
+	}
+	return latency * (inFlight + 1)
+}
+
+// The average decayed for the time since the last sample, so a backend that was
+// slow once and hasn't been picked since gets tried again
+func (pe *PeakEWMABalancer) latency(url string) float64 {
+	state, ok := pe.latencies.Load(url)
+	if !ok {
+		return 0
+	}
+
+	s := state.(*peakEWMAState)
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.latency * math.Exp(-float64(time.Since(s.observed))/float64(peakEWMADecay))
+}
+
+func (pe *PeakEWMABalancer) ObserveLatency(url string, latency time.Duration, status int) {
+	state, loaded := pe.latencies.LoadOrStore(url, &peakEWMAState{})
+	if !loaded {
+		pe.tracked.Add(1)
+	}
+	s := state.(*peakEWMAState)
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	sample := float64(latency)
+	// failures are often fast, they count as twice the current average so they don't attract traffic
+	if status >= 500 {
+		sample = math.Max(sample, 2*s.latency)
+	}
+
+	now := time.Now()
+	switch {
+	case s.observed.IsZero() || sample > s.latency:
+		s.latency = sample
+	default:
+		weight := math.Exp(-float64(now.Sub(s.observed)) / float64(peakEWMADecay))
+		s.latency = s.latency*weight + sample*(1-weight)
+	}
+	s.observed = now
+}
+
+func (pe *PeakEWMABalancer) String() string {
+	return "PeakEWMA"
+}
+
+func (pe *PeakEWMABalancer) IncrementConnections(url string) {
+	pe.connections.increment(url)
+}
+
+func (pe *PeakEWMABalancer) DecrementConnections(url string) {
+	pe.connections.decrement(url)
+}
+
+func (pe *PeakEWMABalancer) ForgetBackend(url string) {
+	pe.connections.forget(url)
+	if _, loaded := pe.latencies.LoadAndDelete(url); loaded {
+		pe.tracked.Add(-1)
+	}
+}
@@ -0,0 +1,112 @@
+package gateway
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// Resilience settings a route actually runs with, its overrides merged over the global defaults
+type ResiliencePolicy struct {
+	Timeouts       TimeoutConfig        `json:"timeouts"`
+	Retry          RetryConfig          `json:"retry"`
+	CircuitBreaker CircuitBreakerConfig `json:"circuit_breaker"`
+	Bulkhead       BulkheadConfig       `json:"bulkhead"`
+}
+
+func (rp ResiliencePolicy) withRouteOverrides(route *Route) ResiliencePolicy {
+	if route.Timeouts != nil {
+		rp.Timeouts = *route.Timeouts
+	}
+	if route.Retry != nil {
+		rp.Retry = *route.Retry
+	}
+	if route.CircuitBreaker != nil {
+		rp.CircuitBreaker = *route.CircuitBreaker
+	}
+	if route.Bulkhead != nil {
+		rp.Bulkhead = *route.Bulkhead
+	}
+	return rp
+}
+
+// Names of the policy blocks a route overrides
+func (r *Route) policyOverrides() []string {
+	overrides := []string{}
+	if r.Timeouts != nil {
+		overrides = append(overrides, "timeouts")
+	}
+	if r.Retry != nil {
+		overrides = append(overrides, "retry")
+	}
+	if r.CircuitBreaker != nil {
+		overrides = append(overrides, "circuit_breaker")
+	}
+	if r.Bulkhead != nil {
+		overrides = append(overrides, "bulkhead")
+	}
+	return overrides
+}
+
+// Key for per-backend state. Routes that override the circuit breaker or bulkhead
+// get their own state for a backend instead of sharing it with other routes.
+func backendStateKey(route *Route, url string) string {
+	if route.CircuitBreaker != nil || route.Bulkhead != nil {
+		return route.key() + " " + url
+	}
+	return url
+}
+
+// Route policy blocks only need to list the fields they change, so each block is
+// decoded again on top of a copy of the corresponding global settings.
+func (gc *GatewayConfig) mergeRoutePolicies(data []byte) error {
+	var raw struct {
+		Routes []struct {
+			Timeouts       json.RawMessage `json:"timeouts"`
+			Retry          json.RawMessage `json:"retry"`
+			CircuitBreaker json.RawMessage `json:"circuit_breaker"`
+			Bulkhead       json.RawMessage `json:"bulkhead"`
+		} `json:"routes"`
+	}
+
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+
+	for i, blocks := range raw.Routes {
+		route := &gc.Routes[i]
+
+		if blocks.Timeouts != nil {
+			merged := gc.Timeouts
+			if err := json.Unmarshal(blocks.Timeouts, &merged); err != nil {
+				return fmt.Errorf("routes[%d].timeouts: %w", i, err)
+			}
+			route.Timeouts = &merged
+		}
+
+		if blocks.Retry != nil {
+			merged := gc.Retry
+			if err := json.Unmarshal(blocks.Retry, &merged); err != nil {
+				return fmt.Errorf("routes[%d].retry: %w", i, err)
+			}
+			route.Retry = &merged
+		}
+
+		if blocks.CircuitBreaker != nil {
+			merged := gc.CircuitBreaker
+			if err := json.Unmarshal(blocks.CircuitBreaker, &merged); err != nil {
+				return fmt.Errorf("routes[%d].circuit_breaker: %w", i, err)
+			}
+			route.CircuitBreaker = &merged
+		}
+
+		if blocks.Bulkhead != nil {
+			merged := gc.Bulkhead
+			if err := json.Unmarshal(blocks.Bulkhead, &merged); err != nil {
+				return fmt.Errorf("routes[%d].bulkhead: %w", i, err)
+			}
+			route.Bulkhead = &merged
+		}
+	}
+
+	return nil
+}
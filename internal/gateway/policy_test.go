@@ -0,0 +1,176 @@
+package gateway
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestRoutePolicies(t *testing.T) {
+	t.Run("PartialOverridesMergeWithGlobals", func(t *testing.T) {
+		data := `
+timeouts:
+  request_timeout: 150s
+routes:
+  - pattern: /api/llm/*
+    target: http://llm:9000
+    timeouts:
+      backend_timeout: 120s
+    retry:
+      methods: [GET]
+    circuit_breaker:
+      failure_threshold: 2
+  - pattern: /api/users/*
+    target: http://users:8001
+`
+
+		config, err := ParseConfig([]byte(data), "yaml")
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+
+		llm := config.Routes[0]
+		if llm.Timeouts.BackendTimeout != 120*time.Second {
+			t.Errorf("Expected backend timeout 120s, got %v", llm.Timeouts.BackendTimeout)
+		}
+
+		if llm.Timeouts.RequestTimeout != 150*time.Second {
+			t.Errorf("Expected request timeout inherited from global block, got %v", llm.Timeouts.RequestTimeout)
+		}
+
+		if llm.Retry.MaxAttempts != DefaultRetryConfig().MaxAttempts {
+			t.Errorf("Expected default max attempts, got %d", llm.Retry.MaxAttempts)
+		}
+
+		if llm.Backends[0].CircuitBreaker.config.FailureThreshold != 2 {
+			t.Errorf("Expected backend breaker to use route failure threshold 2, got %d", llm.Backends[0].CircuitBreaker.config.FailureThreshold)
+		}
+
+		if config.Routes[1].Timeouts != nil || config.Routes[1].Retry != nil {
+			t.Error("Expected route without overrides to have no policy blocks")
+		}
+	})
+
+	t.Run("InvalidOverride", func(t *testing.T) {
+		data := `{"routes": [{"pattern": "/x", "target": "http://x:1", "bulkhead": {"max_concurrent_requests": 0}}]}`
+
+		_, err := ParseConfig([]byte(data), "json")
+		if err == nil || !strings.Contains(err.Error(), "routes[0].bulkhead.max_concurrent_requests: must be at least 1") {
+			t.Errorf("Expected route bulkhead validation error, got %v", err)
+		}
+	})
+
+	t.Run("BackendTimeoutOverRequestTimeout", func(t *testing.T) {
+		data := `{"routes": [{"pattern": "/x", "target": "http://x:1", "timeouts": {"backend_timeout": "120s"}}]}`
+
+		_, err := ParseConfig([]byte(data), "json")
+		if err == nil || !strings.Contains(err.Error(), "routes[0].timeouts.backend_timeout: must not be longer than request_timeout") {
+			t.Errorf("Expected route timeouts validation error, got %v", err)
+		}
+	})
+
+	t.Run("RequestTimeoutOutlastsWriteTimeout", func(t *testing.T) {
+		backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			time.Sleep(300 * time.Millisecond)
+			w.Write([]byte("done"))
+		}))
+		defer backend.Close()
+
+		timeouts := DefaultTimeoutConfig()
+		timeouts.RequestTimeout = 2 * time.Second
+		proxy := NewProxy(testConfig(Route{Pattern: "/api/llm/*", Target: backend.URL, Timeouts: &timeouts}), DefaultTimeoutConfig())
+
+		gateway := httptest.NewUnstartedServer(proxy)
+		gateway.Config.WriteTimeout = 100 * time.Millisecond
+		gateway.Start()
+		defer gateway.Close()
+
+		resp, err := http.Get(gateway.URL + "/api/llm/complete")
+		if err != nil {
+			t.Fatalf("Expected the response to outlast the server's write timeout, got %v", err)
+		}
+		defer resp.Body.Close()
+
+		if body, _ := io.ReadAll(resp.Body); resp.StatusCode != http.StatusOK || string(body) != "done" {
+			t.Errorf("Expected 200 done, got %d %q", resp.StatusCode, body)
+		}
+	})
+
+	t.Run("RetriesOnlyAllowedMethods", func(t *testing.T) {
+		var calls atomic.Int32
+		backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			calls.Add(1)
+			w.WriteHeader(http.StatusBadGateway)
+		}))
+		defer backend.Close()
+
+		retry := DefaultRetryConfig()
+		retry.InitialDelay = time.Millisecond
+		retry.Methods = []string{"GET"}
+
+		proxy := NewProxy(testConfig(
+			Route{Pattern: "/api/llm/*", Target: backend.URL, Retry: &retry},
+		), DefaultTimeoutConfig())
+
+		proxy.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("POST", "/api/llm/chat", nil))
+		if calls.Load() != 1 {
+			t.Errorf("Expected POST to be attempted once, got %d", calls.Load())
+		}
+
+		calls.Store(0)
+		proxy.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/api/llm/models", nil))
+		if calls.Load() != int32(retry.MaxAttempts) {
+			t.Errorf("Expected GET to be attempted %d times, got %d", retry.MaxAttempts, calls.Load())
+		}
+	})
+
+	t.Run("AdminRoutesShowsEffectivePolicy", func(t *testing.T) {
+		timeouts := DefaultTimeoutConfig()
+		timeouts.BackendTimeout = 120 * time.Second
+
+		proxy := NewProxy(testConfig(
+			Route{Pattern: "/api/llm/*", Target: "http://llm:9000", Timeouts: &timeouts},
+			Route{Pattern: "/api/users/*", Target: "http://users:8001"},
+		), DefaultTimeoutConfig())
+
+		w := httptest.NewRecorder()
+		proxy.RoutesHandler(w, httptest.NewRequest("GET", "/admin/routes", nil))
+
+		if w.Code != http.StatusOK {
+			t.Fatalf("Expected status 200, got %d", w.Code)
+		}
+
+		var response struct {
+			Data []struct {
+				Pattern   string   `json:"pattern"`
+				Overrides []string `json:"overrides"`
+				Policy    struct {
+					Timeouts struct {
+						BackendTimeout string `json:"backend_timeout"`
+					} `json:"timeouts"`
+				} `json:"policy"`
+			} `json:"data"`
+		}
+		if err := json.NewDecoder(w.Body).Decode(&response); err != nil {
+			t.Fatalf("Expected valid JSON, got %v", err)
+		}
+
+		if len(response.Data) != 2 {
+			t.Fatalf("Expected 2 routes, got %d", len(response.Data))
+		}
+
+		llm, users := response.Data[0], response.Data[1]
+		if llm.Policy.Timeouts.BackendTimeout != "2m0s" || len(llm.Overrides) != 1 {
+			t.Errorf("Expected llm route to override backend timeout to 2m0s, got %+v", llm)
+		}
+
+		if users.Policy.Timeouts.BackendTimeout != "5s" || len(users.Overrides) != 0 {
+			t.Errorf("Expected users route to use the global backend timeout, got %+v", users)
+		}
+	})
+}
@@ -2,46 +2,52 @@ package gateway
 
 import (
 	"bytes"
+	"context"
+	"errors"
 	"fmt"
 	"log"
+	"math"
 	"net/http"
 	"net/http/httputil"
 	"net/url"
+	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
 )
 
 type Proxy struct {
-	config        *GatewayConfig
-	loadBalancers map[string]LoadBalancer // Cache load balancers per route
-	retryConfig   RetryConfig
-	timeoutConfig TimeoutConfig
-	bulkheads     map[string]*ServiceBulkhead
-	mutex         sync.RWMutex
+	snapshot atomic.Pointer[proxySnapshot] // swapped as a whole on reload
+	mutex    sync.Mutex                    // serializes reloads
+
+	registry        *ServiceRegistry
+	dynamicBackends map[string]*dynamicBackend // backend state key -> state for undeclared backends
+	dynamicMutex    sync.RWMutex
+	prunedRevision  atomic.Uint64
+
+	health      map[string]backendHealth // backend URL -> latest health checker verdict
+	healthMutex sync.RWMutex
+
+	breakerEvents *breakerEvents
 }
 
 func NewProxy(config *GatewayConfig, timeoutConfig TimeoutConfig) *Proxy {
 	proxy := &Proxy{
-		config:        config,
-		loadBalancers: make(map[string]LoadBalancer),
-		retryConfig:   DefaultRetryConfig(),
-		timeoutConfig: timeoutConfig,
-		bulkheads:     make(map[string]*ServiceBulkhead),
-	}
-
-	// Initialize bulkheads for each route
-	bulkheadConfig := DefaultBulkheadConfig()
-	for _, route := range config.Routes {
-		for _, backend := range route.Backends {
-			if _, exists := proxy.bulkheads[backend.URL]; !exists {
-				proxy.bulkheads[backend.URL] = NewServiceBulkhead(bulkheadConfig)
-			}
-		}
+		dynamicBackends: make(map[string]*dynamicBackend),
+		health:          make(map[string]backendHealth),
+		breakerEvents:   newBreakerEvents(),
 	}
 
+	snapshot := newProxySnapshot(config, timeoutConfig, nil)
+	proxy.attachBreakers(snapshot)
+	proxy.snapshot.Store(snapshot)
 	return proxy
 }
 
+// Time to write the response to the client once the request timeout is up
+const responseWriteGrace = 10 * time.Second
+
 type statusTracker struct {
 	http.ResponseWriter
 	status int
@@ -61,81 +67,222 @@ type bufferingResponseWriter struct {
 func (p *Proxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	log.Printf("Gateway received request: %s %s", r.Method, r.URL.Path)
 
-	ctx, cancel := p.timeoutConfig.WithRequestTimeout(r.Context())
-	defer cancel()
-
-	r = r.WithContext(ctx)
+	// in-flight requests keep using this snapshot even if a reload swaps in a new one
+	snapshot := p.snapshot.Load()
 
-	route, err := p.config.MatchRoute(r.URL.Path)
+	match, err := snapshot.config.MatchRequest(r)
 	if err != nil {
 		http.Error(w, "Route not found", http.StatusNotFound)
 		return
 	}
+	route := match.Route
+	policy := snapshot.policyFor(route)
 
-	lb := p.getLoadBalancer(route)
+	// the route's request timeout decides how long the response may take, not the server's write timeout
+	deadline := time.Now().Add(policy.Timeouts.RequestTimeout + responseWriteGrace)
+	if err := http.NewResponseController(w).SetWriteDeadline(deadline); err != nil && !errors.Is(err, http.ErrNotSupported) {
+		log.Printf("⚠️ Could not extend the write deadline of %s %s: %v", r.Method, r.URL.Path, err)
+	}
 
-	var finalBackend *Backend
-	var finalStatus int
+	ctx, cancel := policy.Timeouts.WithRequestTimeout(r.Context())
+	defer cancel()
 
-	err = p.retryConfig.ExecuteWithRetry(r.Context(), func() (int, error) {
+	r = r.WithContext(ctx)
 
-		backend, err := p.selectBackend(route, lb)
-		if err != nil {
-			return 503, err
-		}
+	retryConfig := policy.Retry
+	if !retryConfig.allowsRequest(r) {
+		retryConfig.MaxAttempts = 1
+	}
 
-		bulkhead := p.bulkheads[backend.URL]
-		log.Printf("🚧 Attempting to acquire bulkhead for %s, stats: %v", backend.URL, bulkhead.GetStats())
+	// duplicates are only sent for requests that are safe to send twice
+	hedge, _ := snapshot.hedgerFor(match.key)
+	if !policy.Retry.allowsRequest(r) {
+		hedge = nil
+	}
 
-		err = bulkhead.TryAcquire(r.Context())
+	mirror, _ := snapshot.mirrorFor(match.key)
+	if !mirror.sample() {
+		mirror = nil
+	}
+
+	// read once so retries, hedges and the mirror see the same payload. Without
+	// any of them the body streams straight through to the one attempt.
+	body := streamedBody(r)
+	if retryConfig.MaxAttempts > 1 || hedge != nil || mirror != nil {
+		body, err = newRequestBody(r, retryConfig.MaxMemoryBody, retryConfig.MaxReplayBody)
 		if err != nil {
-			log.Printf("⚠️ Bulkhead rejected request to %s: %v", backend.URL, err)
-			return 503, fmt.Errorf("bulkhead limit reached: %w", err)
+			log.Printf("❌ Failed to read request body of %s %s: %v", r.Method, r.URL.Path, err)
+			http.Error(w, "Failed to read request body", http.StatusBadRequest)
+			return
 		}
-		defer bulkhead.Release()
+	}
+	defer body.release()
+
+	if !body.replayable() {
+		hedge = nil
+	}
 
-		lcb, ok := lb.(*LeastConnectionsBalancer)
-		if ok {
-			lcb.IncrementConnections(backend.URL)
-			defer lcb.DecrementConnections(backend.URL)
+	// a retry would send an incomplete body, so there is only one attempt
+	lostRetries := retryConfig.MaxAttempts > 1 && !body.replayable()
+	if lostRetries {
+		retryConfig.MaxAttempts = 1
+	}
+
+	lb := snapshot.getLoadBalancer(match)
+	outliers, _ := snapshot.outlierDetectorFor(match.key)
+
+	// chosen once so retries stay within the same group and subset
+	selection := &backendSelection{
+		group:    route.Split.chooseGroup(r),
+		subset:   route.Subset.requestSelector(r),
+		hashKey:  route.HashOn.keyFor(r, match.Params),
+		outliers: outliers,
+		lb:       lb,
+	}
+
+	budget, _ := snapshot.retryBudgetFor(match.key)
+	budget.recordRequest()
+
+	mirrored := p.startMirror(mirror, r, body, match, lb)
+	start := time.Now()
+
+	// the request timeout still bounds all attempts and the waits between them
+	timeouts := policy.Timeouts
+	if retryConfig.PerTryTimeout > 0 {
+		timeouts.BackendTimeout = retryConfig.PerTryTimeout
+	}
+
+	ac := &attemptContext{
+		snapshot: snapshot,
+		match:    match,
+		timeouts: timeouts,
+		body:     body,
+		budget:   budget,
+		outliers: outliers,
+		lb:       lb,
+		hedger:   hedge,
+	}
+
+	var finalBackend *Backend
+	var finalStatus int
+	var finalLatency time.Duration
+	var finalResponse *bufferingResponseWriter
+	var attemptedURL string    // backend of the latest attempt
+	var nextBackend *Backend   // picked before a retry, so the retry is charged to the backend it goes to
+	var budgetExhausted string // budget that turned down a retry, if any
+
+	err = retryConfig.executeWithRetry(r.Context(), func() retryOutcome {
+
+		backend := nextBackend
+		nextBackend = nil
+		if backend == nil {
+			var err error
+			backend, err = p.selectBackend(snapshot, route, selection)
+			if err != nil {
+				return retryOutcome{status: 503, err: err}
+			}
 		}
 
-		var bufferedResponse = &bufferingResponseWriter{}
+		var result attemptResult
+		if hedge != nil {
+			result = p.hedgedAttempt(r, ac, selection, backend, hedge)
+		} else {
+			result = p.attempt(r, ac, backend)
+		}
+		attemptedURL = result.backend.URL
 
-		status, requestErr := p.executeRequest(bufferedResponse, r, backend, route, lb)
-		log.Printf("🎯 Request completed: status=%d, error=%v", status, requestErr)
-		finalBackend = backend
-		finalStatus = status
+		if !result.executed {
+			return retryOutcome{status: result.status, err: result.err}
+		}
 
-		if status >= 500 {
-			return status, fmt.Errorf("server error: %d", status)
+		log.Printf("🎯 Request completed: status=%d, error=%v", result.status, result.err)
+		finalBackend = result.backend
+		finalStatus = result.status
+		finalLatency = result.latency
+		finalResponse = result.response
+
+		// the response is only written once it's clear there won't be another attempt
+		return retryOutcome{
+			status:     result.status,
+			err:        result.err,
+			retryAfter: parseRetryAfter(result.response.Header().Get("Retry-After"), time.Now()),
+		}
+	}, func() bool {
+		// without a backend to go to only the route's budget is charged, the attempt selects again
+		nextURL := ""
+		if backend, err := p.selectBackend(snapshot, route, selection); err == nil {
+			nextBackend, nextURL = backend, backend.URL
 		}
 
-		if status < 500 {
-			bufferedResponse.replayTo(w)
+		budgetExhausted = budget.withdraw(nextURL)
+		if budgetExhausted != "" {
+			nextBackend = nil
+			log.Printf("🪣 Retry budget exhausted (%s) for route %s, not retrying failed request to %s", budgetExhausted, route.Pattern, attemptedURL)
+			return false
 		}
-		return status, requestErr
+		return true
 	})
 
 	if err != nil {
+		mirrored.primaryDone(http.StatusServiceUnavailable, time.Since(start))
+		if budgetExhausted != "" {
+			w.Header().Set(RetryBudgetHeader, budgetExhausted)
+		}
+		var shed *LimitExceededError
+		if errors.As(err, &shed) {
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(shed.RetryAfter.Seconds()))))
+		}
+		if lostRetries {
+			log.Printf("❌ %s %s to %s failed and was not retried: %v", r.Method, r.URL.Path, attemptedURL, ErrBodyNotReplayable)
+			http.Error(w, "Service unavailable: "+ErrBodyNotReplayable.Error(), http.StatusServiceUnavailable)
+			return
+		}
 		http.Error(w, "Service unavailable", http.StatusServiceUnavailable)
 		return
 	}
+	mirrored.primaryDone(finalStatus, time.Since(start))
+	finalResponse.replayTo(w)
 
 	if finalStatus >= 500 {
-		finalBackend.CircuitBreaker.RecordFailure()
+		finalBackend.CircuitBreaker.RecordCall(false, finalLatency)
 		log.Printf("🔴 Circuit breaker recorded failure for %s (status: %d, failures %v)", finalBackend.URL, finalStatus, finalBackend.CircuitBreaker.GetStats())
 	} else {
-		finalBackend.CircuitBreaker.RecordSuccess()
+		finalBackend.CircuitBreaker.RecordCall(true, finalLatency)
 		log.Printf("🟢 Circuit breaker recorded success for %s (status: %d)", finalBackend.URL, finalStatus)
 	}
 
 }
 
-func (p *Proxy) customizeRequest(req *http.Request, route *Route, backend *Backend, lb LoadBalancer) {
-	if route.StripPrefix != "" && strings.HasPrefix(req.URL.Path, route.StripPrefix) {
+// Rewrites the client's path for the backend. Runs before the reverse proxy
+// joins the backend URL's own path on, so a target like http://svc/v1 keeps its prefix.
+func rewritePath(req *http.Request, match *RouteMatch) {
+	route := match.Route
+
+	if route.Rewrite != "" {
+		req.URL.Path = expandRewrite(route.Rewrite, match.Params)
+		req.URL.RawPath = ""
+	} else if route.StripPrefix != "" && strings.HasPrefix(req.URL.Path, route.StripPrefix) {
 		req.URL.Path = strings.TrimPrefix(req.URL.Path, route.StripPrefix)
 	}
+}
+
+func (p *Proxy) customizeRequest(req *http.Request, match *RouteMatch, backend *Backend, lb LoadBalancer) {
+	route := match.Route
+
+	// backends trust these to come from the gateway, so clients don't get to send their own
+	for key := range req.Header {
+		if strings.HasPrefix(key, "X-Route-Param-") {
+			req.Header.Del(key)
+		}
+	}
+
+	// Matched path params, e.g. {id} becomes X-Route-Param-Id
+	for _, param := range match.Params {
+		if param.Name != "*" {
+			req.Header.Set("X-Route-Param-"+param.Name, param.Value)
+		}
+	}
+	req.Header.Set("X-Route-Pattern", route.Pattern)
 
 	// Gateway headers
 	req.Header.Set("X-Forwarded-By", "go-ai-gateway")
@@ -144,47 +291,158 @@ func (p *Proxy) customizeRequest(req *http.Request, route *Route, backend *Backe
 	req.Header.Set("X-Load-Balancer", lb.String())
 }
 
-// returns cached or new load balancer for route
-func (p *Proxy) getLoadBalancer(route *Route) LoadBalancer {
-	p.mutex.RLock()
-	lb, exists := p.loadBalancers[route.Pattern]
-	p.mutex.RUnlock()
+// Per-request inputs to backend selection
+type backendSelection struct {
+	group    *BackendGroup     // traffic split group, nil without a split
+	subset   map[string]string // metadata the backend has to match
+	hashKey  string            // session affinity key for hashing balancers
+	exclude  map[string]bool   // backend URLs that already have the request, for hedges
+	outliers *outlierDetector
+	lb       LoadBalancer
+}
+
+// Per-request state every attempt of a request shares
+type attemptContext struct {
+	snapshot *proxySnapshot
+	match    *RouteMatch
+	timeouts TimeoutConfig
+	body     *requestBody
+	budget   *retryBudget
+	outliers *outlierDetector
+	hedger   *hedger // nil when the request isn't hedged
+	lb       LoadBalancer
+}
+
+type attemptResult struct {
+	backend  *Backend
+	executed bool // false when the attempt never reached the backend, e.g. the bulkhead was full
+	status   int
+	err      error
+	latency  time.Duration
+	response *bufferingResponseWriter
+}
 
-	if exists {
-		return lb
+func (ar attemptResult) succeeded() bool {
+	return ar.executed && ar.err == nil && ar.status < 500
+}
+
+// Sends the request to backend once, buffering the response
+func (p *Proxy) attempt(r *http.Request, ac *attemptContext, backend *Backend) attemptResult {
+	result := attemptResult{backend: backend}
+
+	bulkhead := p.getBulkhead(ac.snapshot, ac.match.Route, backend.URL)
+	log.Printf("🚧 Attempting to acquire bulkhead for %s, stats: %v", backend.URL, bulkhead.GetStats())
+
+	if err := bulkhead.TryAcquire(r.Context()); err != nil {
+		log.Printf("⚠️ Bulkhead rejected request to %s: %v", backend.URL, err)
+		result.status = 503
+		result.err = fmt.Errorf("bulkhead limit reached: %w", err)
+		return result
+	}
+	defer bulkhead.Release()
+
+	if tracker, ok := ac.lb.(connectionTracker); ok {
+		tracker.IncrementConnections(backend.URL)
+		defer tracker.DecrementConnections(backend.URL)
 	}
 
-	// Create new load balancer
-	p.mutex.Lock()
-	defer p.mutex.Unlock()
+	// a copy per attempt, hedged attempts run side by side
+	r = r.WithContext(r.Context())
+	r.Body = ac.body.reader()
 
-	// Double-check after acquiring write lock
-	lb, exists = p.loadBalancers[route.Pattern]
+	result.response = &bufferingResponseWriter{}
+	ac.budget.recordAttempt(backend.URL)
 
-	if exists {
-		return lb
+	attemptStart := time.Now()
+	result.status, result.err = p.executeRequest(result.response, r, ac.timeouts, backend, ac.match, ac.lb)
+	result.latency = time.Since(attemptStart)
+	result.executed = true
+
+	// an attempt cut short because another one answered first says nothing about the backend
+	if context.Cause(r.Context()) == errHedgeLost {
+		return result
 	}
 
-	lb = NewLoadBalancer(route.LoadBalancer)
-	p.loadBalancers[route.Pattern] = lb
-	log.Printf("Created %s load balancer for route : %s", lb.String(), route.Pattern)
-	return lb
+	ac.outliers.record(backend.URL, result.status, result.latency)
+	if observer, ok := bulkhead.(rttObserver); ok {
+		// failures come back fast and would pass for the backend's unloaded latency
+		switch {
+		case isOverloadStatus(result.status):
+			observer.ObserveRTT(result.latency, true)
+		case result.err == nil && result.status < 400:
+			observer.ObserveRTT(result.latency, false)
+		}
+	}
+	if observer, ok := ac.lb.(latencyObserver); ok {
+		observer.ObserveLatency(backend.URL, result.latency, result.status)
+	}
+	if ac.hedger != nil && result.status < 500 {
+		ac.hedger.observe(result.latency)
+	}
+	return result
 }
 
-func (p *Proxy) selectBackend(route *Route, lb LoadBalancer) (*Backend, error) {
-	backends := route.GetBackends()
+// Statuses of a backend that is overloaded or didn't answer in time
+func isOverloadStatus(status int) bool {
+	return status == http.StatusTooManyRequests || status == http.StatusServiceUnavailable || status == http.StatusGatewayTimeout
+}
+
+func (p *Proxy) selectBackend(snapshot *proxySnapshot, route *Route, selection *backendSelection) (*Backend, error) {
+	if group := selection.group; group != nil {
+		selection.outliers.track(route.Split.allBackends())
+
+		backend, err := p.selectFrom(route, group.Backends, selection)
+		if err == nil {
+			return backend, nil
+		}
+
+		log.Printf("⚠️ No backend available in group %s for route %s, falling back to the other groups", group.Name, route.Pattern)
+		return p.selectFrom(route, route.Split.allBackends(), selection)
+	}
+
+	backends := p.routeBackends(snapshot, route)
 	if len(backends) == 0 {
 		return nil, fmt.Errorf("no backends configured for route: %s", route.Pattern)
 	}
+	selection.outliers.track(backends)
+
+	return p.selectFrom(route, backends, selection)
+}
+
+func (p *Proxy) selectFrom(route *Route, backends []Backend, selection *backendSelection) (*Backend, error) {
+	backends = route.Subset.filter(backends, selection.subset)
+	if len(backends) == 0 {
+		return nil, fmt.Errorf("no backends match the subset for route: %s", route.Pattern)
+	}
 
-	return lb.SelectBackend(backends)
+	backends = selection.outliers.available(p.checkedBackends(route, backends))
+	if len(selection.exclude) > 0 {
+		backends = excludeBackends(backends, selection.exclude)
+	}
+	if keyed, ok := selection.lb.(keyedBalancer); ok {
+		return keyed.SelectBackendForKey(backends, selection.hashKey)
+	}
+	return selection.lb.SelectBackend(backends)
 }
 
-func (p *Proxy) executeRequest(w http.ResponseWriter, r *http.Request, backend *Backend, route *Route, lb LoadBalancer) (int, error) {
+// Marks excluded backends unavailable rather than dropping them, so hashing
+// balancers keep their lookup tables and walk on to the next backend
+func excludeBackends(backends []Backend, exclude map[string]bool) []Backend {
+	available := make([]Backend, len(backends))
+	copy(available, backends)
+	for i := range available {
+		if exclude[available[i].URL] {
+			available[i].Healthy = false
+		}
+	}
+	return available
+}
+
+func (p *Proxy) executeRequest(w http.ResponseWriter, r *http.Request, timeoutConfig TimeoutConfig, backend *Backend, match *RouteMatch, lb LoadBalancer) (int, error) {
 
 	log.Printf("Select backend: %s (strategy %s)", backend.URL, lb.String())
 
-	ctx, cancel := p.timeoutConfig.WithBackendTimeout(r.Context())
+	ctx, cancel := timeoutConfig.WithBackendTimeout(r.Context())
 	defer cancel()
 
 	r = r.WithContext(ctx)
@@ -198,8 +456,21 @@ func (p *Proxy) executeRequest(w http.ResponseWriter, r *http.Request, backend *
 
 	originalDirector := proxy.Director
 	proxy.Director = func(req *http.Request) {
+		rewritePath(req, match)
 		originalDirector(req)
-		p.customizeRequest(req, route, backend, lb)
+		p.customizeRequest(req, match, backend, lb)
+	}
+
+	// keeps the error itself so retries can go by what went wrong
+	var backendErr error
+	proxy.ErrorHandler = func(w http.ResponseWriter, req *http.Request, err error) {
+		log.Printf("⚠️ Proxy error for %s: %v", backend.URL, err)
+		backendErr = err
+		status := http.StatusBadGateway
+		if errors.Is(err, context.DeadlineExceeded) {
+			status = http.StatusGatewayTimeout
+		}
+		w.WriteHeader(status)
 	}
 
 	statusTracker := &statusTracker{
@@ -209,6 +480,9 @@ func (p *Proxy) executeRequest(w http.ResponseWriter, r *http.Request, backend *
 
 	proxy.ServeHTTP(statusTracker, r)
 
+	if backendErr != nil {
+		return statusTracker.status, fmt.Errorf("backend %s: %w", backend.URL, backendErr)
+	}
 	if statusTracker.status >= 500 {
 		return statusTracker.status, fmt.Errorf("server error: %d", statusTracker.status)
 	}
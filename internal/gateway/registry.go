@@ -1,8 +1,15 @@
 package gateway
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"log"
+	"maps"
+	"reflect"
+	"sort"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -12,21 +19,63 @@ type ServiceInstance struct {
 	Health   string            `json:"health"`
 	Route    string            `json:"route"`
 	Metadata map[string]string `json:"metadata"`
-	LastSeen time.Time         `json:"last_seen"`
+	LastSeen time.Time         `json:"last_seen"`     // last registration, heartbeat or passing health check
+	TTL      Duration          `json:"ttl,omitempty"` // expires when LastHeartbeat is older than this, 0 never expires
+
+	LastHeartbeat time.Time `json:"last_heartbeat"` // last registration or heartbeat, health checks don't count
+
+	HealthCheck *HealthCheckSpec `json:"health_check,omitempty"` // how the health checker probes this instance
 }
 
 type ServiceRegistry struct {
 	mutex    sync.RWMutex
 	services map[string]map[string]*ServiceInstance // route -> service_id -> instance
+	revision atomic.Uint64                          // bumped on every change
+	store    RegistryStore                          // every change is written through
+	watch    registryWatchers                       // change stream for GET /registry/watch
 }
 
+// Returned when a service ID is used on several routes and no route was given
+var errAmbiguousService = errors.New("ambiguous service ID, pass its route")
+
+// How often Sync reloads the store when local changes keep racing it
+const registrySyncAttempts = 3
+
+// How long a single write to the registry store may take
+const registryStoreTimeout = 5 * time.Second
+
 func NewServiceRegistry() *ServiceRegistry {
 	return &ServiceRegistry{
 		services: make(map[string]map[string]*ServiceInstance),
+		store:    NewMemoryRegistryStore(),
+	}
+}
+
+// Creates a registry backed by store, starting from the instances already persisted in it
+func NewServiceRegistryWithStore(ctx context.Context, store RegistryStore) (*ServiceRegistry, error) {
+	registry := &ServiceRegistry{
+		services: make(map[string]map[string]*ServiceInstance),
+		store:    store,
 	}
+
+	if err := registry.Sync(ctx); err != nil {
+		return nil, err
+	}
+	return registry, nil
 }
 
 func (sr *ServiceRegistry) RegisterService(instance *ServiceInstance) error {
+	instance.LastSeen = time.Now()
+	instance.LastHeartbeat = instance.LastSeen
+
+	ctx, cancel := context.WithTimeout(context.Background(), registryStoreTimeout)
+	defer cancel()
+
+	// persisted first so a failed write leaves the registry unchanged
+	if err := sr.store.SaveInstance(ctx, *instance); err != nil {
+		return fmt.Errorf("error saving service %s: %w", instance.ID, err)
+	}
+
 	sr.mutex.Lock()
 	defer sr.mutex.Unlock()
 
@@ -34,28 +83,182 @@ func (sr *ServiceRegistry) RegisterService(instance *ServiceInstance) error {
 		sr.services[instance.Route] = make(map[string]*ServiceInstance)
 	}
 
-	instance.LastSeen = time.Now()
 	sr.services[instance.Route][instance.ID] = instance
+	sr.publishLocked(EventRegister, "", *instance)
 	return nil
 }
 
 func (sr *ServiceRegistry) DeregisterService(route, serviceID string) error {
-	sr.mutex.Lock()
-	defer sr.mutex.Unlock()
+	sr.mutex.RLock()
+	_, routeExists := sr.services[route]
+	_, serviceExists := sr.services[route][serviceID]
+	sr.mutex.RUnlock()
 
-	if _, exists := sr.services[route]; !exists {
+	if !routeExists {
 		return fmt.Errorf("no services registered for route: %s", route)
 	}
 
-	if _, exists := sr.services[route][serviceID]; !exists {
+	if !serviceExists {
 		return fmt.Errorf("service ID %s not found for route: %s", serviceID, route)
 	}
 
+	ctx, cancel := context.WithTimeout(context.Background(), registryStoreTimeout)
+	defer cancel()
+
+	if err := sr.store.DeleteInstance(ctx, route, serviceID); err != nil {
+		return fmt.Errorf("error deleting service %s: %w", serviceID, err)
+	}
+
+	sr.mutex.Lock()
+	defer sr.mutex.Unlock()
+
+	instance, exists := sr.services[route][serviceID]
+	if !exists {
+		return nil // removed concurrently, e.g. by the reaper
+	}
+
+	sr.removeLocked(route, serviceID)
+	sr.publishLocked(EventDeregister, "", *instance)
+	return nil
+}
+
+func (sr *ServiceRegistry) removeLocked(route, serviceID string) {
 	delete(sr.services[route], serviceID)
 
 	if len(sr.services[route]) == 0 {
 		delete(sr.services, route)
 	}
+}
+
+// Replaces the in-memory view with the store's contents, picking up changes
+// made by other gateway replicas sharing the same store
+func (sr *ServiceRegistry) Sync(ctx context.Context) error {
+	for attempt := 1; ; attempt++ {
+		// a local change between loading and swapping would be lost, the load is redone then
+		revision := sr.revision.Load()
+
+		instances, err := sr.store.LoadInstances(ctx)
+		if err != nil {
+			return fmt.Errorf("error loading registry: %w", err)
+		}
+
+		services := make(map[string]map[string]*ServiceInstance)
+		for i := range instances {
+			instance := &instances[i]
+			if _, exists := services[instance.Route]; !exists {
+				services[instance.Route] = make(map[string]*ServiceInstance)
+			}
+			services[instance.Route][instance.ID] = instance
+		}
+
+		sr.mutex.Lock()
+		if sr.revision.Load() != revision {
+			sr.mutex.Unlock()
+			if attempt == registrySyncAttempts {
+				return fmt.Errorf("registry kept changing during %d sync attempts", attempt)
+			}
+			continue
+		}
+
+		previous := sr.services
+		keepNewerSightings(previous, services)
+		sr.services = services
+		sr.publishDiffLocked(previous, services)
+		sr.mutex.Unlock()
+		return nil
+	}
+}
+
+// Heartbeats change no revision and are persisted after memory, so one can be
+// missing from a load that started before it was written
+func keepNewerSightings(previous, current map[string]map[string]*ServiceInstance) {
+	for route, services := range current {
+		for id, instance := range services {
+			if old, exists := previous[route][id]; exists {
+				if old.LastSeen.After(instance.LastSeen) {
+					instance.LastSeen = old.LastSeen
+				}
+				if old.LastHeartbeat.After(instance.LastHeartbeat) {
+					instance.LastHeartbeat = old.LastHeartbeat
+				}
+			}
+		}
+	}
+}
+
+// Periodically reloads the registry from its store
+func (sr *ServiceRegistry) StartSync(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := sr.Sync(ctx); err != nil {
+				log.Printf("❌ Registry sync failed, keeping current view: %v", err)
+			}
+		}
+	}
+}
+
+// Publishes the changes between two views of the registry, ignoring LastSeen and LastHeartbeat
+func (sr *ServiceRegistry) publishDiffLocked(previous, current map[string]map[string]*ServiceInstance) {
+	var removed, added, changed []ServiceInstance
+
+	for route, services := range previous {
+		for id, instance := range services {
+			if _, exists := current[route][id]; !exists {
+				removed = append(removed, *instance)
+			}
+		}
+	}
+
+	for route, services := range current {
+		for id, instance := range services {
+			old, exists := previous[route][id]
+			switch {
+			case !exists || !sameRegistration(old, instance):
+				// a re-registration with other settings is sent like the original one
+				added = append(added, *instance)
+			case old.Health != instance.Health:
+				changed = append(changed, *instance)
+			}
+		}
+	}
+
+	sortInstances(removed)
+	sortInstances(added)
+	sortInstances(changed)
+
+	for _, instance := range removed {
+		sr.publishLocked(EventDeregister, "", instance)
+	}
+	for _, instance := range added {
+		sr.publishLocked(EventRegister, "", instance)
+	}
+	for _, instance := range changed {
+		sr.publishLocked(EventHealth, "", instance)
+	}
+}
+
+// Whether two versions of an instance were registered with the same settings
+func sameRegistration(a, b *ServiceInstance) bool {
+	return a.URL == b.URL &&
+		a.TTL == b.TTL &&
+		maps.Equal(a.Metadata, b.Metadata) &&
+		reflect.DeepEqual(a.HealthCheck, b.HealthCheck)
+}
+
+// Writes an instance that was changed in memory through to the store
+func (sr *ServiceRegistry) persist(instance ServiceInstance) error {
+	ctx, cancel := context.WithTimeout(context.Background(), registryStoreTimeout)
+	defer cancel()
+
+	if err := sr.store.SaveInstance(ctx, instance); err != nil {
+		return fmt.Errorf("error saving service %s: %w", instance.ID, err)
+	}
 	return nil
 }
 
@@ -74,6 +277,50 @@ func (sr *ServiceRegistry) GetServices(route string) []*ServiceInstance {
 	return instances
 }
 
+// Returns copies of the instances registered for route, sorted by ID, safe to read without the registry lock
+func (sr *ServiceRegistry) ListInstances(route string) []ServiceInstance {
+	sr.mutex.RLock()
+	defer sr.mutex.RUnlock()
+
+	instances := make([]ServiceInstance, 0, len(sr.services[route]))
+	for _, instance := range sr.services[route] {
+		instances = append(instances, *instance)
+	}
+
+	sort.Slice(instances, func(i, j int) bool {
+		return instances[i].ID < instances[j].ID
+	})
+	return instances
+}
+
+// Returns copies of every registered instance, safe to read without the registry lock
+func (sr *ServiceRegistry) allInstances() []ServiceInstance {
+	sr.mutex.RLock()
+	defer sr.mutex.RUnlock()
+
+	var instances []ServiceInstance
+	for _, services := range sr.services {
+		for _, instance := range services {
+			instances = append(instances, *instance)
+		}
+	}
+	return instances
+}
+
+func (sr *ServiceRegistry) healthOf(route, serviceID string) string {
+	sr.mutex.RLock()
+	defer sr.mutex.RUnlock()
+
+	if instance, exists := sr.services[route][serviceID]; exists {
+		return instance.Health
+	}
+	return ""
+}
+
+func (sr *ServiceRegistry) Revision() uint64 {
+	return sr.revision.Load()
+}
+
 func (sr *ServiceRegistry) GetAllRoutes() []string {
 	sr.mutex.RLock()
 	defer sr.mutex.RUnlock()
@@ -86,15 +333,141 @@ func (sr *ServiceRegistry) GetAllRoutes() []string {
 }
 
 func (sr *ServiceRegistry) UpdateServiceHealth(route, serviceID, health string) error {
+	sr.mutex.Lock()
+
+	instance, exists := sr.services[route][serviceID]
+	if !exists {
+		sr.mutex.Unlock()
+		return fmt.Errorf("service %s not found for route %s", serviceID, route)
+	}
+
+	now := time.Now()
+	if instance.Health == health {
+		// only a passing check proves the instance is alive
+		if health == "healthy" {
+			instance.LastSeen = now
+		}
+		sr.mutex.Unlock()
+		return nil
+	}
+
+	updated := *instance
+	sr.mutex.Unlock()
+
+	updated.Health = health
+	if health == "healthy" {
+		updated.LastSeen = now
+	}
+
+	// persisted first so a failed write leaves the registry unchanged
+	if err := sr.persist(updated); err != nil {
+		return err
+	}
+
 	sr.mutex.Lock()
 	defer sr.mutex.Unlock()
 
-	if services, exists := sr.services[route]; exists {
+	instance, exists = sr.services[route][serviceID]
+	if !exists {
+		return nil // removed concurrently, e.g. by the reaper
+	}
+
+	instance.Health = health
+	if health == "healthy" {
+		instance.LastSeen = now
+	}
+	sr.publishLocked(EventHealth, "", *instance)
+	return nil
+}
+
+// Records that an instance is still alive, pushing back its TTL expiry. With route
+// "" the instance is looked up by ID alone, which fails if several routes use the ID.
+func (sr *ServiceRegistry) Heartbeat(route, serviceID string) (ServiceInstance, error) {
+	sr.mutex.Lock()
+
+	instance, err := sr.findLocked(route, serviceID)
+	if err != nil {
+		sr.mutex.Unlock()
+		return ServiceInstance{}, err
+	}
+
+	instance.LastSeen = time.Now()
+	instance.LastHeartbeat = instance.LastSeen
+	updated := *instance
+	sr.mutex.Unlock()
+
+	// other replicas only see the heartbeat once it is in the store
+	return updated, sr.persist(updated)
+}
+
+func (sr *ServiceRegistry) findLocked(route, serviceID string) (*ServiceInstance, error) {
+	if route != "" {
+		instance, exists := sr.services[route][serviceID]
+		if !exists {
+			return nil, fmt.Errorf("service ID %s not found for route: %s", serviceID, route)
+		}
+		return instance, nil
+	}
+
+	var found *ServiceInstance
+	for route, services := range sr.services {
 		if instance, exists := services[serviceID]; exists {
-			instance.Health = health
-			instance.LastSeen = time.Now()
-			return nil
+			if found != nil {
+				return nil, fmt.Errorf("%w: service ID %s is registered on %s and %s", errAmbiguousService, serviceID, found.Route, route)
+			}
+			found = instance
+		}
+	}
+	if found == nil {
+		return nil, fmt.Errorf("service ID %s not found", serviceID)
+	}
+	return found, nil
+}
+
+// Deregisters every instance whose TTL lapsed before now and returns them
+func (sr *ServiceRegistry) ExpireStale(now time.Time) []ServiceInstance {
+	sr.mutex.Lock()
+
+	var expired []ServiceInstance
+	for route, services := range sr.services {
+		for id, instance := range services {
+			// the health checker reaching an instance doesn't prove it still wants traffic
+			if instance.TTL <= 0 || now.Sub(instance.LastHeartbeat) <= time.Duration(instance.TTL) {
+				continue
+			}
+
+			expired = append(expired, *instance)
+			sr.removeLocked(route, id)
+			sr.publishLocked(EventDeregister, "ttl_expired", *instance)
+			log.Printf("⌛ Expired service %s on route %s, no heartbeat for %v (ttl %v)",
+				id, route, now.Sub(instance.LastHeartbeat).Round(time.Millisecond), time.Duration(instance.TTL))
+		}
+	}
+
+	sr.mutex.Unlock()
+
+	for _, instance := range expired {
+		ctx, cancel := context.WithTimeout(context.Background(), registryStoreTimeout)
+		if err := sr.store.DeleteInstance(ctx, instance.Route, instance.ID); err != nil {
+			log.Printf("❌ Failed to delete expired service %s from registry store: %v", instance.ID, err)
+		}
+		cancel()
+	}
+
+	return expired
+}
+
+// Periodically removes instances that stopped sending heartbeats
+func (sr *ServiceRegistry) StartReaper(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			sr.ExpireStale(now)
 		}
 	}
-	return fmt.Errorf("service %s not found for route %s", serviceID, route)
 }
@@ -2,8 +2,12 @@ package gateway
 
 import (
 	"encoding/json"
+	"errors"
+	"fmt"
 	"net/http"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/aishahsofea/go-ai-gateway/internal/utils"
 )
@@ -21,6 +25,16 @@ func (sr *ServiceRegistry) RegisterHandler(w http.ResponseWriter, r *http.Reques
 		return
 	}
 
+	if instance.TTL < 0 {
+		http.Error(w, "ttl must not be negative", http.StatusBadRequest)
+		return
+	}
+
+	if err := instance.HealthCheck.Validate(); err != nil {
+		http.Error(w, "invalid health_check: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
 	err = sr.RegisterService(&instance)
 	if err != nil {
 		http.Error(w, "failed to register service", http.StatusInternalServerError)
@@ -60,6 +74,36 @@ func (sr *ServiceRegistry) DeregisterHandler(w http.ResponseWriter, r *http.Requ
 
 }
 
+func (sr *ServiceRegistry) HeartbeatHandler(w http.ResponseWriter, r *http.Request) {
+	serviceID := r.PathValue("id")
+	if serviceID == "" {
+		http.Error(w, "missing service ID", http.StatusBadRequest)
+		return
+	}
+
+	// only needed when the ID is used on several routes
+	route := r.URL.Query().Get("route")
+
+	instance, err := sr.Heartbeat(route, serviceID)
+	if err != nil {
+
+		if errors.Is(err, errAmbiguousService) {
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		}
+
+		if strings.Contains(err.Error(), "not found") {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+
+		http.Error(w, "failed to record heartbeat", http.StatusInternalServerError)
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, utils.Envelope{"data": instance})
+}
+
 func (sr *ServiceRegistry) GetAllServicesHandler(w http.ResponseWriter, r *http.Request) {
 	sr.mutex.RLock()
 	defer sr.mutex.RUnlock()
@@ -94,3 +138,93 @@ func (sr *ServiceRegistry) GetServicesByRouteHandler(w http.ResponseWriter, r *h
 
 	utils.WriteJSON(w, http.StatusOK, utils.Envelope{"data": services})
 }
+
+// How often an idle watch stream gets a comment line so proxies don't close it
+const registryWatchKeepAlive = 15 * time.Second
+
+// Streams registry changes as Server-Sent Events. Resumes after the revision in the
+// Last-Event-ID header (or last_event_id query param) and filters by ?route= when given.
+func (sr *ServiceRegistry) WatchHandler(w http.ResponseWriter, r *http.Request) {
+	route := r.URL.Query().Get("route")
+
+	lastEventID := r.Header.Get("Last-Event-ID")
+	if lastEventID == "" {
+		lastEventID = r.URL.Query().Get("last_event_id")
+	}
+
+	var revision uint64
+	resume := lastEventID != ""
+	if resume {
+		parsed, err := strconv.ParseUint(lastEventID, 10, 64)
+		if err != nil {
+			http.Error(w, "invalid Last-Event-ID", http.StatusBadRequest)
+			return
+		}
+		revision = parsed
+	}
+
+	controller := http.NewResponseController(w)
+	// the stream outlives the server's write timeout
+	if err := controller.SetWriteDeadline(time.Time{}); err != nil && !errors.Is(err, http.ErrNotSupported) {
+		http.Error(w, "streaming not supported", http.StatusInternalServerError)
+		return
+	}
+
+	watch := sr.Watch(revision, resume)
+	defer sr.Unwatch(watch)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+
+	send := func(event RegistryEvent) error {
+		event, ok := event.forRoute(route)
+		if !ok {
+			return nil
+		}
+
+		data, err := json.Marshal(event)
+		if err != nil {
+			return err
+		}
+
+		if _, err := fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", event.Revision, event.Type, data); err != nil {
+			return err
+		}
+		return controller.Flush()
+	}
+
+	for _, event := range watch.backlog {
+		if err := send(event); err != nil {
+			return
+		}
+	}
+	if err := controller.Flush(); err != nil {
+		return
+	}
+
+	keepAlive := time.NewTicker(registryWatchKeepAlive)
+	defer keepAlive.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case event, ok := <-watch.events:
+			if !ok {
+				return // fell behind, the client reconnects with its Last-Event-ID
+			}
+			if err := send(event); err != nil {
+				return
+			}
+		case <-keepAlive.C:
+			if _, err := fmt.Fprint(w, ": keepalive\n\n"); err != nil {
+				return
+			}
+			if err := controller.Flush(); err != nil {
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,136 @@
+package gateway
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/aishahsofea/go-ai-gateway/internal/models"
+)
+
+// Persists registry instances so they survive restarts and can be shared by
+// several gateway replicas. The registry keeps its own in-memory view for
+// routing and writes every change through to the store.
+type RegistryStore interface {
+	LoadInstances(ctx context.Context) ([]ServiceInstance, error)
+	SaveInstance(ctx context.Context, instance ServiceInstance) error // inserts or replaces by route and ID
+	DeleteInstance(ctx context.Context, route, serviceID string) error
+}
+
+// Keeps instances in process memory, nothing survives a restart
+type MemoryRegistryStore struct {
+	mutex     sync.RWMutex
+	instances map[string]ServiceInstance // route + " " + service ID -> instance
+}
+
+func NewMemoryRegistryStore() *MemoryRegistryStore {
+	return &MemoryRegistryStore{
+		instances: make(map[string]ServiceInstance),
+	}
+}
+
+func (ms *MemoryRegistryStore) LoadInstances(ctx context.Context) ([]ServiceInstance, error) {
+	ms.mutex.RLock()
+	defer ms.mutex.RUnlock()
+
+	instances := make([]ServiceInstance, 0, len(ms.instances))
+	for _, instance := range ms.instances {
+		instances = append(instances, instance)
+	}
+
+	sortInstances(instances)
+	return instances, nil
+}
+
+func (ms *MemoryRegistryStore) SaveInstance(ctx context.Context, instance ServiceInstance) error {
+	ms.mutex.Lock()
+	defer ms.mutex.Unlock()
+
+	ms.instances[instance.Route+" "+instance.ID] = instance
+	return nil
+}
+
+func (ms *MemoryRegistryStore) DeleteInstance(ctx context.Context, route, serviceID string) error {
+	ms.mutex.Lock()
+	defer ms.mutex.Unlock()
+
+	delete(ms.instances, route+" "+serviceID)
+	return nil
+}
+
+// Row-level storage of registry instances, e.g. db.RegistryRepository
+type RegistryRecordStore interface {
+	LoadInstances(ctx context.Context) ([]models.ServiceInstance, error)
+	SaveInstance(ctx context.Context, instance models.ServiceInstance) error
+	DeleteInstance(ctx context.Context, route, serviceID string) error
+}
+
+// Adapts a RegistryRecordStore to a RegistryStore, converting between stored rows and instances
+type recordRegistryStore struct {
+	records RegistryRecordStore
+}
+
+func NewRecordRegistryStore(records RegistryRecordStore) RegistryStore {
+	return &recordRegistryStore{records: records}
+}
+
+func (rs *recordRegistryStore) LoadInstances(ctx context.Context) ([]ServiceInstance, error) {
+	records, err := rs.records.LoadInstances(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	instances := make([]ServiceInstance, 0, len(records))
+	for _, record := range records {
+		instance := ServiceInstance{
+			ID:       record.ID,
+			URL:      record.URL,
+			Health:   record.Health,
+			Route:    record.Route,
+			Metadata: record.Metadata,
+			LastSeen: record.LastSeen,
+			TTL:      Duration(record.TTL),
+
+			LastHeartbeat: record.LastHeartbeat,
+		}
+
+		if record.HealthCheck != nil {
+			if err := json.Unmarshal(record.HealthCheck, &instance.HealthCheck); err != nil {
+				return nil, fmt.Errorf("error decoding health check of service %s: %w", record.ID, err)
+			}
+		}
+
+		instances = append(instances, instance)
+	}
+	return instances, nil
+}
+
+func (rs *recordRegistryStore) SaveInstance(ctx context.Context, instance ServiceInstance) error {
+	record := models.ServiceInstance{
+		Route:    instance.Route,
+		ID:       instance.ID,
+		URL:      instance.URL,
+		Health:   instance.Health,
+		Metadata: instance.Metadata,
+		TTL:      time.Duration(instance.TTL),
+		LastSeen: instance.LastSeen,
+
+		LastHeartbeat: instance.LastHeartbeat,
+	}
+
+	if instance.HealthCheck != nil {
+		healthCheck, err := json.Marshal(instance.HealthCheck)
+		if err != nil {
+			return fmt.Errorf("error encoding health check of service %s: %w", instance.ID, err)
+		}
+		record.HealthCheck = healthCheck
+	}
+
+	return rs.records.SaveInstance(ctx, record)
+}
+
+func (rs *recordRegistryStore) DeleteInstance(ctx context.Context, route, serviceID string) error {
+	return rs.records.DeleteInstance(ctx, route, serviceID)
+}
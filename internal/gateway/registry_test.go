@@ -1,10 +1,14 @@
 package gateway
 
 import (
+	"bufio"
 	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
 	"time"
 )
@@ -79,6 +83,104 @@ func TestServiceRegistry(t *testing.T) {
 	})
 }
 
+func TestRegistryTTL(t *testing.T) {
+	t.Run("HeartbeatKeepsInstanceAlive", func(t *testing.T) {
+		registry := NewServiceRegistry()
+		registry.RegisterService(&ServiceInstance{
+			ID: "ttl-alive", URL: "http://localhost:8001", Route: "/api/ttl/*", TTL: Duration(time.Second),
+		})
+
+		registry.ExpireStale(time.Now().Add(500 * time.Millisecond))
+
+		_, err := registry.Heartbeat("/api/ttl/*", "ttl-alive")
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+
+		expired := registry.ExpireStale(time.Now().Add(900 * time.Millisecond))
+		if len(expired) != 0 {
+			t.Errorf("Expected instance with recent heartbeat to stay registered, got %d expired", len(expired))
+		}
+	})
+
+	t.Run("LapsedTTLExpires", func(t *testing.T) {
+		registry := NewServiceRegistry()
+		registry.RegisterService(&ServiceInstance{
+			ID: "ttl-stale", URL: "http://localhost:8002", Route: "/api/stale/*", TTL: Duration(time.Second),
+		})
+		registry.RegisterService(&ServiceInstance{
+			ID: "no-ttl", URL: "http://localhost:8003", Route: "/api/stale/*",
+		})
+
+		revision := registry.Revision()
+		expired := registry.ExpireStale(time.Now().Add(2 * time.Second))
+
+		if len(expired) != 1 || expired[0].ID != "ttl-stale" {
+			t.Fatalf("Expected only ttl-stale to expire, got %+v", expired)
+		}
+
+		if services := registry.GetServices("/api/stale/*"); len(services) != 1 || services[0].ID != "no-ttl" {
+			t.Errorf("Expected instance without ttl to stay registered, got %d services", len(services))
+		}
+
+		if registry.Revision() == revision {
+			t.Error("Expected registry revision to change after expiry")
+		}
+	})
+
+	t.Run("HealthChecksDontReplaceHeartbeats", func(t *testing.T) {
+		registry := NewServiceRegistry()
+		registry.RegisterService(&ServiceInstance{
+			ID: "ttl-silent", URL: "http://localhost:8004", Route: "/api/silent/*", TTL: Duration(time.Second),
+		})
+
+		registry.mutex.Lock()
+		registry.services["/api/silent/*"]["ttl-silent"].LastHeartbeat = time.Now().Add(-2 * time.Second)
+		registry.mutex.Unlock()
+
+		registry.UpdateServiceHealth("/api/silent/*", "ttl-silent", "healthy")
+
+		if expired := registry.ExpireStale(time.Now()); len(expired) != 1 {
+			t.Errorf("Expected an instance without heartbeats to expire despite passing health checks, got %d expired", len(expired))
+		}
+	})
+
+	t.Run("HeartbeatHandler", func(t *testing.T) {
+		registry := NewServiceRegistry()
+		registry.RegisterService(&ServiceInstance{
+			ID: "ttl-alive", URL: "http://localhost:8001", Route: "/api/ttl/*", TTL: Duration(time.Second),
+		})
+
+		heartbeat := func(target, id string) int {
+			req := httptest.NewRequest("PUT", target, nil)
+			req.SetPathValue("id", id)
+			w := httptest.NewRecorder()
+			registry.HeartbeatHandler(w, req)
+			return w.Code
+		}
+
+		if code := heartbeat("/registry/heartbeat/ttl-alive", "ttl-alive"); code != http.StatusOK {
+			t.Errorf("Expected status 200 without a route, got %d", code)
+		}
+
+		if code := heartbeat("/registry/heartbeat/unknown", "unknown"); code != http.StatusNotFound {
+			t.Errorf("Expected status 404 for unknown instance, got %d", code)
+		}
+
+		registry.RegisterService(&ServiceInstance{
+			ID: "ttl-alive", URL: "http://localhost:8002", Route: "/api/other/*", TTL: Duration(time.Second),
+		})
+
+		if code := heartbeat("/registry/heartbeat/ttl-alive", "ttl-alive"); code != http.StatusConflict {
+			t.Errorf("Expected status 409 for an ID used on two routes, got %d", code)
+		}
+
+		if code := heartbeat("/registry/heartbeat/ttl-alive?route=/api/other/*", "ttl-alive"); code != http.StatusOK {
+			t.Errorf("Expected status 200 with the route given, got %d", code)
+		}
+	})
+}
+
 func TestRegistryHandlers(t *testing.T) {
 	registry := NewServiceRegistry()
 
@@ -110,6 +212,24 @@ func TestRegistryHandlers(t *testing.T) {
 		}
 	})
 
+	t.Run("RegisterHandlerWithTTL", func(t *testing.T) {
+		body := `{"id": "test-svc-ttl", "url": "http://localhost:8002", "route": "/api/ttl/*", "ttl": "30s"}`
+
+		req := httptest.NewRequest("POST", "/registry/register", bytes.NewBufferString(body))
+		w := httptest.NewRecorder()
+
+		registry.RegisterHandler(w, req)
+
+		if w.Code != http.StatusCreated {
+			t.Fatalf("Expected status 201, got %d", w.Code)
+		}
+
+		services := registry.GetServices("/api/ttl/*")
+		if len(services) != 1 || time.Duration(services[0].TTL) != 30*time.Second {
+			t.Errorf("Expected service registered with ttl 30s, got %+v", services)
+		}
+	})
+
 	t.Run("GetAllServicesHandler", func(t *testing.T) {
 		// Register a test service first
 		registry.RegisterService(&ServiceInstance{
@@ -198,3 +318,325 @@ func TestHealthChecker(t *testing.T) {
 		}
 	})
 }
+
+func TestHealthCheckSpecs(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/ready":
+			w.WriteHeader(http.StatusNoContent)
+		case "/status":
+			w.Write([]byte(`{"status": "ok", "checks": {"db": "down"}}`))
+		default:
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer server.Close()
+
+	config := DefaultHealthCheckConfig()
+	config.Timeout = time.Second
+
+	tests := []struct {
+		name    string
+		url     string
+		spec    *HealthCheckSpec
+		healthy bool
+	}{
+		{name: "DefaultEndpointUnavailable", url: server.URL, healthy: false},
+		{name: "StatusRange", url: server.URL, spec: &HealthCheckSpec{Path: "/ready", ExpectedStatus: []string{"200-204"}}, healthy: true},
+		{name: "StatusClass", url: server.URL, spec: &HealthCheckSpec{Path: "/ready", ExpectedStatus: []string{"5xx"}}, healthy: false},
+		{name: "UnavailableExpected", url: server.URL, spec: &HealthCheckSpec{ExpectedStatus: []string{"503"}}, healthy: true},
+		{name: "BodyContains", url: server.URL, spec: &HealthCheckSpec{Path: "/status", BodyContains: `"ok"`}, healthy: true},
+		{name: "JSONFieldMatches", url: server.URL, spec: &HealthCheckSpec{Path: "/status", JSONField: "status", JSONValue: "ok"}, healthy: true},
+		{name: "NestedJSONFieldMismatch", url: server.URL, spec: &HealthCheckSpec{Path: "/status", JSONField: "checks.db", JSONValue: "up"}, healthy: false},
+		{name: "TCPConnect", url: server.URL, spec: &HealthCheckSpec{Type: HealthCheckTCP}, healthy: true},
+		{name: "TCPRefused", url: "http://127.0.0.1:1", spec: &HealthCheckSpec{Type: HealthCheckTCP}, healthy: false},
+	}
+
+	healthChecker := NewHealthChecker(NewServiceRegistry(), config)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			instance := &ServiceInstance{ID: "svc", URL: tt.url, Route: "/api/*", HealthCheck: tt.spec}
+
+			if got := healthChecker.checkService(instance); got != tt.healthy {
+				t.Errorf("Expected healthy %v, got %v", tt.healthy, got)
+			}
+		})
+	}
+
+	t.Run("Thresholds", func(t *testing.T) {
+		registry := NewServiceRegistry()
+		healthChecker := NewHealthChecker(registry, config)
+		instance := &ServiceInstance{
+			ID:          "svc",
+			URL:         server.URL,
+			Route:       "/api/*",
+			Health:      "healthy",
+			HealthCheck: &HealthCheckSpec{FailureLimit: 2, SuccessLimit: 3},
+		}
+		registry.RegisterService(instance)
+
+		steps := []struct {
+			healthy bool
+			want    string
+		}{
+			{false, "healthy"},
+			{true, "healthy"}, // a success resets the failure count
+			{false, "healthy"},
+			{false, "unhealthy"},
+			{true, "unhealthy"},
+			{true, "unhealthy"},
+			{true, "healthy"},
+		}
+
+		for i, step := range steps {
+			healthChecker.updateServiceHealth(instance, step.healthy)
+			if got := registry.healthOf("/api/*", "svc"); got != step.want {
+				t.Fatalf("Step %d: expected health %s, got %s", i, step.want, got)
+			}
+		}
+	})
+
+	t.Run("RejectsInvalidSpec", func(t *testing.T) {
+		registry := NewServiceRegistry()
+		body := `{"id": "svc", "url": "http://localhost:8001", "route": "/api/*", "health_check": {"expected_status": ["2xy"]}}`
+
+		w := httptest.NewRecorder()
+		registry.RegisterHandler(w, httptest.NewRequest("POST", "/registry/register", strings.NewReader(body)))
+
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("Expected status 400, got %d", w.Code)
+		}
+	})
+}
+
+type failingRegistryStore struct {
+	*MemoryRegistryStore
+}
+
+func (fs failingRegistryStore) SaveInstance(ctx context.Context, instance ServiceInstance) error {
+	return errors.New("connection refused")
+}
+
+// Runs beforeReturn once, after loading but before handing the instances back
+type racingRegistryStore struct {
+	*MemoryRegistryStore
+	beforeReturn func()
+}
+
+func (rs *racingRegistryStore) LoadInstances(ctx context.Context) ([]ServiceInstance, error) {
+	instances, err := rs.MemoryRegistryStore.LoadInstances(ctx)
+	if hook := rs.beforeReturn; hook != nil {
+		rs.beforeReturn = nil
+		hook()
+	}
+	return instances, err
+}
+
+func TestRegistryStore(t *testing.T) {
+	t.Run("WritesThrough", func(t *testing.T) {
+		store := NewMemoryRegistryStore()
+		registry, err := NewServiceRegistryWithStore(context.Background(), store)
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+
+		registry.RegisterService(&ServiceInstance{ID: "svc-1", URL: "http://localhost:8001", Route: "/api/users/*"})
+		registry.UpdateServiceHealth("/api/users/*", "svc-1", "unhealthy")
+
+		instances, _ := store.LoadInstances(context.Background())
+		if len(instances) != 1 || instances[0].Health != "unhealthy" {
+			t.Fatalf("Expected stored instance with health change, got %+v", instances)
+		}
+
+		registry.DeregisterService("/api/users/*", "svc-1")
+
+		instances, _ = store.LoadInstances(context.Background())
+		if len(instances) != 0 {
+			t.Errorf("Expected deregistered instance to be deleted from store, got %+v", instances)
+		}
+	})
+
+	t.Run("ReplicasShareOneStore", func(t *testing.T) {
+		store := NewMemoryRegistryStore()
+		store.SaveInstance(context.Background(), ServiceInstance{ID: "svc-1", URL: "http://localhost:8001", Route: "/api/users/*"})
+
+		first, _ := NewServiceRegistryWithStore(context.Background(), store)
+		second, _ := NewServiceRegistryWithStore(context.Background(), store)
+
+		if len(first.ListInstances("/api/users/*")) != 1 {
+			t.Fatal("Expected persisted instance to be loaded on startup")
+		}
+
+		first.RegisterService(&ServiceInstance{ID: "svc-2", URL: "http://localhost:8002", Route: "/api/users/*"})
+
+		revision := second.Revision()
+		if err := second.Sync(context.Background()); err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+
+		if len(second.ListInstances("/api/users/*")) != 2 {
+			t.Errorf("Expected second replica to see both instances after sync, got %d", len(second.ListInstances("/api/users/*")))
+		}
+
+		if second.Revision() == revision {
+			t.Error("Expected sync with new instances to bump the revision")
+		}
+	})
+
+	t.Run("SyncPublishesChangedRegistrations", func(t *testing.T) {
+		store := NewMemoryRegistryStore()
+		first, _ := NewServiceRegistryWithStore(context.Background(), store)
+		first.RegisterService(&ServiceInstance{ID: "svc-1", URL: "http://localhost:8001", Route: "/api/users/*", Metadata: map[string]string{"version": "v1"}})
+
+		second, _ := NewServiceRegistryWithStore(context.Background(), store)
+		watch := second.Watch(second.Revision(), true)
+		defer second.Unwatch(watch)
+
+		first.RegisterService(&ServiceInstance{ID: "svc-1", URL: "http://localhost:8001", Route: "/api/users/*", Metadata: map[string]string{"version": "v2"}})
+		if err := second.Sync(context.Background()); err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+
+		select {
+		case event := <-watch.events:
+			if event.Type != EventRegister || event.Instance.Metadata["version"] != "v2" {
+				t.Errorf("Expected a register event with the new metadata, got %+v", event)
+			}
+		default:
+			t.Fatal("Expected the metadata change to be published, got nothing")
+		}
+	})
+
+	t.Run("SyncKeepsChangesMadeWhileLoading", func(t *testing.T) {
+		store := &racingRegistryStore{MemoryRegistryStore: NewMemoryRegistryStore()}
+		registry, _ := NewServiceRegistryWithStore(context.Background(), store)
+		watch := registry.Watch(registry.Revision(), true)
+		defer registry.Unwatch(watch)
+
+		store.beforeReturn = func() {
+			registry.RegisterService(&ServiceInstance{ID: "svc-1", URL: "http://localhost:8001", Route: "/api/users/*"})
+		}
+		if err := registry.Sync(context.Background()); err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+
+		if len(registry.ListInstances("/api/users/*")) != 1 {
+			t.Fatal("Expected the instance registered during the sync to be kept")
+		}
+		for len(watch.events) > 0 {
+			if event := <-watch.events; event.Type == EventDeregister {
+				t.Errorf("Expected no deregister event, got %+v", event)
+			}
+		}
+	})
+
+	t.Run("FailedWriteLeavesRegistryUnchanged", func(t *testing.T) {
+		registry, _ := NewServiceRegistryWithStore(context.Background(), failingRegistryStore{NewMemoryRegistryStore()})
+
+		err := registry.RegisterService(&ServiceInstance{ID: "svc-1", URL: "http://localhost:8001", Route: "/api/users/*"})
+		if err == nil {
+			t.Fatal("Expected error from failing store, got nil")
+		}
+
+		if len(registry.ListInstances("/api/users/*")) != 0 {
+			t.Error("Expected instance not to be registered when the store write fails")
+		}
+	})
+
+	t.Run("FailedHealthWriteLeavesRegistryUnchanged", func(t *testing.T) {
+		store := NewMemoryRegistryStore()
+		registry, _ := NewServiceRegistryWithStore(context.Background(), store)
+		registry.RegisterService(&ServiceInstance{ID: "svc-1", URL: "http://localhost:8001", Route: "/api/users/*", Health: "healthy"})
+
+		revision := registry.Revision()
+		registry.store = failingRegistryStore{store}
+
+		if err := registry.UpdateServiceHealth("/api/users/*", "svc-1", "unhealthy"); err == nil {
+			t.Fatal("Expected error from failing store, got nil")
+		}
+
+		if health := registry.ListInstances("/api/users/*")[0].Health; health != "healthy" {
+			t.Errorf("Expected health to stay healthy when the store write fails, got %s", health)
+		}
+		if registry.Revision() != revision {
+			t.Error("Expected no event for a health change that wasn't stored")
+		}
+	})
+}
+
+func TestRegistryWatch(t *testing.T) {
+	t.Run("ResumesFromLastEventID", func(t *testing.T) {
+		registry := NewServiceRegistry()
+		registry.RegisterService(&ServiceInstance{ID: "svc-1", URL: "http://localhost:8001", Route: "/api/users/*"})
+		resumeFrom := registry.Revision()
+
+		registry.UpdateServiceHealth("/api/users/*", "svc-1", "unhealthy")
+		registry.DeregisterService("/api/users/*", "svc-1")
+
+		watch := registry.Watch(resumeFrom, true)
+		defer registry.Unwatch(watch)
+
+		if len(watch.backlog) != 2 || watch.backlog[0].Type != EventHealth || watch.backlog[1].Type != EventDeregister {
+			t.Fatalf("Expected health and deregister events to be replayed, got %+v", watch.backlog)
+		}
+
+		if watch.backlog[0].Revision != resumeFrom+1 || watch.backlog[1].Revision != resumeFrom+2 {
+			t.Errorf("Expected consecutive revisions after %d, got %d and %d", resumeFrom, watch.backlog[0].Revision, watch.backlog[1].Revision)
+		}
+
+		fresh := registry.Watch(0, false)
+		defer registry.Unwatch(fresh)
+
+		if len(fresh.backlog) != 1 || fresh.backlog[0].Type != EventSnapshot {
+			t.Errorf("Expected a new watch to start with a snapshot, got %+v", fresh.backlog)
+		}
+	})
+
+	t.Run("HandlerStreamsFilteredEvents", func(t *testing.T) {
+		registry := NewServiceRegistry()
+		registry.RegisterService(&ServiceInstance{ID: "svc-1", URL: "http://localhost:8001", Route: "/api/users/*"})
+
+		server := httptest.NewServer(http.HandlerFunc(registry.WatchHandler))
+		defer server.Close()
+
+		resp, err := http.Get(server.URL + "/registry/watch?route=/api/products/*")
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		defer resp.Body.Close()
+
+		if resp.Header.Get("Content-Type") != "text/event-stream" {
+			t.Errorf("Expected text/event-stream, got %s", resp.Header.Get("Content-Type"))
+		}
+
+		events := make(chan string, 10)
+		go func() {
+			scanner := bufio.NewScanner(resp.Body)
+			for scanner.Scan() {
+				if line := scanner.Text(); strings.HasPrefix(line, "data: ") {
+					events <- strings.TrimPrefix(line, "data: ")
+				}
+			}
+		}()
+
+		expectEvent := func(expected string) {
+			t.Helper()
+			select {
+			case got := <-events:
+				if !strings.Contains(got, expected) {
+					t.Errorf("Expected event containing %s, got %s", expected, got)
+				}
+			case <-time.After(2 * time.Second):
+				t.Fatalf("Expected event containing %s, got nothing", expected)
+			}
+		}
+
+		expectEvent(`"type":"snapshot"`)
+
+		registry.RegisterService(&ServiceInstance{ID: "svc-2", URL: "http://localhost:8002", Route: "/api/users/*"})
+		registry.RegisterService(&ServiceInstance{ID: "svc-3", URL: "http://localhost:8003", Route: "/api/products/*"})
+
+		// the users route event is filtered out
+		expectEvent(`"id":"svc-3"`)
+	})
+}
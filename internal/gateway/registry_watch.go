@@ -0,0 +1,175 @@
+package gateway
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	EventRegister   = "register"
+	EventDeregister = "deregister"
+	EventHealth     = "health"
+	EventSnapshot   = "snapshot" // full state, sent when there is nothing to resume from
+)
+
+// How many past events are kept for clients resuming with Last-Event-ID
+const registryHistorySize = 1024
+
+// Buffered events per watcher, a watcher that falls further behind is disconnected
+const registryWatchBuffer = 64
+
+type RegistryEvent struct {
+	Revision  uint64            `json:"revision"`
+	Type      string            `json:"type"`
+	Reason    string            `json:"reason,omitempty"` // why an instance was deregistered, e.g. ttl_expired
+	Instance  *ServiceInstance  `json:"instance,omitempty"`
+	Instances []ServiceInstance `json:"instances,omitempty"` // snapshot events only
+	Time      time.Time         `json:"time"`
+}
+
+type registryWatch struct {
+	events  chan RegistryEvent
+	backlog []RegistryEvent // sent before anything from events
+}
+
+type registryWatchers struct {
+	mutex    sync.Mutex
+	history  []RegistryEvent // oldest first, at most registryHistorySize
+	watchers map[*registryWatch]struct{}
+}
+
+// Records a change and fans it out. Callers hold sr.mutex for writing, which
+// keeps events in revision order.
+func (sr *ServiceRegistry) publishLocked(eventType, reason string, instance ServiceInstance) {
+	event := RegistryEvent{
+		Revision: sr.revision.Add(1),
+		Type:     eventType,
+		Reason:   reason,
+		Instance: &instance,
+		Time:     time.Now(),
+	}
+
+	sr.watch.mutex.Lock()
+	defer sr.watch.mutex.Unlock()
+
+	sr.watch.history = append(sr.watch.history, event)
+	if len(sr.watch.history) > registryHistorySize {
+		sr.watch.history = sr.watch.history[len(sr.watch.history)-registryHistorySize:]
+	}
+
+	for watch := range sr.watch.watchers {
+		select {
+		case watch.events <- event:
+		default:
+			// too slow to keep up, it can reconnect and resume from its last event
+			close(watch.events)
+			delete(sr.watch.watchers, watch)
+		}
+	}
+}
+
+// Subscribes to registry changes. With resume set, events after lastEventID are
+// replayed if they are still in the history, otherwise the backlog starts with a snapshot.
+func (sr *ServiceRegistry) Watch(lastEventID uint64, resume bool) *registryWatch {
+	// holding the read lock means no event can be published between the backlog and the subscription
+	sr.mutex.RLock()
+	defer sr.mutex.RUnlock()
+
+	sr.watch.mutex.Lock()
+	defer sr.watch.mutex.Unlock()
+
+	watch := &registryWatch{events: make(chan RegistryEvent, registryWatchBuffer)}
+
+	if backlog, ok := sr.replayLocked(lastEventID, resume); ok {
+		watch.backlog = backlog
+	} else {
+		watch.backlog = []RegistryEvent{sr.snapshotLocked()}
+	}
+
+	if sr.watch.watchers == nil {
+		sr.watch.watchers = make(map[*registryWatch]struct{})
+	}
+	sr.watch.watchers[watch] = struct{}{}
+	return watch
+}
+
+func (sr *ServiceRegistry) Unwatch(watch *registryWatch) {
+	sr.watch.mutex.Lock()
+	defer sr.watch.mutex.Unlock()
+
+	if _, exists := sr.watch.watchers[watch]; exists {
+		delete(sr.watch.watchers, watch)
+		close(watch.events)
+	}
+}
+
+func (sr *ServiceRegistry) replayLocked(lastEventID uint64, resume bool) ([]RegistryEvent, bool) {
+	if !resume {
+		return nil, false
+	}
+
+	current := sr.revision.Load()
+	if lastEventID == current {
+		return nil, true
+	}
+	if lastEventID > current {
+		return nil, false
+	}
+
+	history := sr.watch.history
+	if len(history) == 0 || history[0].Revision > lastEventID+1 {
+		return nil, false // the events in between were already dropped
+	}
+
+	start := sort.Search(len(history), func(i int) bool {
+		return history[i].Revision > lastEventID
+	})
+	return append([]RegistryEvent(nil), history[start:]...), true
+}
+
+func (sr *ServiceRegistry) snapshotLocked() RegistryEvent {
+	instances := make([]ServiceInstance, 0)
+	for _, services := range sr.services {
+		for _, instance := range services {
+			instances = append(instances, *instance)
+		}
+	}
+	sortInstances(instances)
+
+	return RegistryEvent{
+		Revision:  sr.revision.Load(),
+		Type:      EventSnapshot,
+		Instances: instances,
+		Time:      time.Now(),
+	}
+}
+
+func sortInstances(instances []ServiceInstance) {
+	sort.Slice(instances, func(i, j int) bool {
+		if instances[i].Route != instances[j].Route {
+			return instances[i].Route < instances[j].Route
+		}
+		return instances[i].ID < instances[j].ID
+	})
+}
+
+// Only the instances of route, or the event itself when it is about another route
+func (e RegistryEvent) forRoute(route string) (RegistryEvent, bool) {
+	if route == "" {
+		return e, true
+	}
+
+	if e.Type != EventSnapshot {
+		return e, e.Instance != nil && e.Instance.Route == route
+	}
+
+	filtered := make([]ServiceInstance, 0)
+	for _, instance := range e.Instances {
+		if instance.Route == route {
+			filtered = append(filtered, instance)
+		}
+	}
+	e.Instances = filtered
+	return e, true
+}
@@ -0,0 +1,240 @@
+package gateway
+
+import (
+	"log"
+)
+
+// Routing state a request sees from start to finish. A snapshot is never
+// mutated after it is published; reloads build a new one and swap it in.
+type proxySnapshot struct {
+	config        *GatewayConfig
+	defaults      ResiliencePolicy
+	policies      map[*Route]ResiliencePolicy   // effective policy of each route in config
+	loadBalancers map[string]LoadBalancer       // route key -> load balancer
+	bulkheads     map[string]ConcurrencyLimiter // backend state key -> bulkhead
+	mirrors       map[string]*requestMirror     // route key -> shadow traffic for routes with a mirror
+	outliers      map[string]*outlierDetector   // route key -> ejections for routes with outlier detection
+	retryBudgets  map[string]*retryBudget       // route key -> retry budget for routes with one
+	hedgers       map[string]*hedger            // route key -> hedging state for routes with hedging
+}
+
+// Builds a snapshot for config, carrying over circuit breakers, bulkheads and
+// load balancers from previous for backends and routes that still exist.
+func newProxySnapshot(config *GatewayConfig, timeoutConfig TimeoutConfig, previous *proxySnapshot) *proxySnapshot {
+	defaults := ResiliencePolicy{
+		Timeouts:       timeoutConfig,
+		Retry:          config.Retry,
+		CircuitBreaker: config.CircuitBreaker,
+		Bulkhead:       config.Bulkhead,
+	}
+	if defaults.Retry.MaxAttempts == 0 {
+		defaults.Retry = DefaultRetryConfig()
+	}
+	if defaults.CircuitBreaker.FailureThreshold == 0 {
+		defaults.CircuitBreaker = DefaultCircuitBreakerConfig()
+	}
+	if defaults.Bulkhead.MaxConcurrentRequests == 0 {
+		defaults.Bulkhead = DefaultBulkheadConfig()
+	}
+
+	snapshot := &proxySnapshot{
+		config:        config,
+		defaults:      defaults,
+		policies:      make(map[*Route]ResiliencePolicy, len(config.Routes)),
+		loadBalancers: make(map[string]LoadBalancer),
+		bulkheads:     make(map[string]ConcurrencyLimiter),
+		mirrors:       make(map[string]*requestMirror),
+		outliers:      make(map[string]*outlierDetector),
+		retryBudgets:  make(map[string]*retryBudget),
+		hedgers:       make(map[string]*hedger),
+	}
+
+	previousRoutes := make(map[string]*Route) // route key -> route
+	previousBreakers := make(map[string]*CircuitBreaker)
+	if previous != nil {
+		for i := range previous.config.Routes {
+			route := &previous.config.Routes[i]
+			previousRoutes[route.key()] = route
+			for _, backend := range route.declaredBackends() {
+				stateKey := backendStateKey(route, backend.URL)
+				if _, exists := previousBreakers[stateKey]; !exists && backend.CircuitBreaker != nil {
+					previousBreakers[stateKey] = backend.CircuitBreaker
+				}
+			}
+		}
+	}
+
+	breakers := make(map[string]*CircuitBreaker) // backend state key -> breaker, shared by routes without their own
+	for i := range config.Routes {
+		route := &config.Routes[i]
+		policy := defaults.withRouteOverrides(route)
+		snapshot.policies[route] = policy
+
+		for _, backend := range route.declaredBackends() {
+			stateKey := backendStateKey(route, backend.URL)
+
+			// a backend keeps its breaker state across reloads unless its breaker settings changed
+			if breaker, exists := breakers[stateKey]; exists {
+				backend.CircuitBreaker = breaker
+			} else if breaker, exists := previousBreakers[stateKey]; exists && breaker.config == policy.CircuitBreaker {
+				backend.CircuitBreaker = breaker
+			} else if backend.CircuitBreaker == nil {
+				backend.CircuitBreaker = NewCircuitBreaker(policy.CircuitBreaker)
+			}
+			breakers[stateKey] = backend.CircuitBreaker
+
+			if _, exists := snapshot.bulkheads[stateKey]; exists {
+				continue
+			}
+
+			if previous != nil {
+				old, exists := previous.bulkheads[stateKey]
+				if exists && limiterConfig(old) == policy.Bulkhead {
+					snapshot.bulkheads[stateKey] = old
+					continue
+				}
+			}
+
+			snapshot.bulkheads[stateKey] = NewConcurrencyLimiter(policy.Bulkhead)
+		}
+
+		if route.Mirror != nil {
+			// mirror stats survive reloads as long as the mirror is unchanged
+			if old, exists := previous.mirrorFor(route.key()); exists && old.config == *route.Mirror {
+				snapshot.mirrors[route.key()] = old
+			} else {
+				snapshot.mirrors[route.key()] = newRequestMirror(*route.Mirror)
+			}
+		}
+
+		if route.OutlierDetection != nil {
+			// ejections stay in effect across reloads as long as the settings are unchanged
+			if old, exists := previous.outlierDetectorFor(route.key()); exists && old.config == *route.OutlierDetection {
+				snapshot.outliers[route.key()] = old
+			} else {
+				snapshot.outliers[route.key()] = newOutlierDetector(*route.OutlierDetection)
+			}
+		}
+
+		if policy.Retry.Budget.enabled() {
+			// recent requests and retries keep counting across reloads as long as the budget is unchanged
+			if old, exists := previous.retryBudgetFor(route.key()); exists && old.config == policy.Retry.Budget {
+				snapshot.retryBudgets[route.key()] = old
+			} else {
+				snapshot.retryBudgets[route.key()] = newRetryBudget(policy.Retry.Budget)
+			}
+		}
+
+		if route.Hedge != nil {
+			// the latency samples and hedge rate carry over as long as the settings are unchanged
+			if old, exists := previous.hedgerFor(route.key()); exists && old.config == *route.Hedge {
+				snapshot.hedgers[route.key()] = old
+			} else {
+				snapshot.hedgers[route.key()] = newHedger(*route.Hedge)
+			}
+		}
+
+		if old, exists := previousRoutes[route.key()]; exists && old.LoadBalancer == route.LoadBalancer {
+			lb := previous.loadBalancers[route.key()]
+			forgetRemovedBackends(lb, old, route)
+			snapshot.loadBalancers[route.key()] = lb
+			continue
+		}
+
+		lb := NewLoadBalancer(route.LoadBalancer)
+		snapshot.loadBalancers[route.key()] = lb
+		log.Printf("Created %s load balancer for route : %s", lb.String(), route.Pattern)
+	}
+
+	// compile the route table up front so the first request doesn't pay for it
+	config.routeTable()
+
+	if previous != nil {
+		for stateKey := range previous.bulkheads {
+			if _, exists := snapshot.bulkheads[stateKey]; !exists {
+				log.Printf("🧹 Tearing down state for removed backend %s", stateKey)
+			}
+		}
+	}
+
+	return snapshot
+}
+
+// Drops the carried over balancer's state for backends the route no longer declares
+func forgetRemovedBackends(lb LoadBalancer, previous, route *Route) {
+	forgetter, ok := lb.(backendForgetter)
+	if !ok {
+		return
+	}
+
+	declared := make(map[string]bool)
+	for _, backend := range route.declaredBackends() {
+		declared[backend.URL] = true
+	}
+	for _, backend := range previous.declaredBackends() {
+		if !declared[backend.URL] {
+			forgetter.ForgetBackend(backend.URL)
+		}
+	}
+}
+
+// Returns the effective resilience policy for a route of this snapshot
+func (s *proxySnapshot) policyFor(route *Route) ResiliencePolicy {
+	if policy, exists := s.policies[route]; exists {
+		return policy
+	}
+	return s.defaults.withRouteOverrides(route)
+}
+
+func (s *proxySnapshot) mirrorFor(routeKey string) (*requestMirror, bool) {
+	if s == nil {
+		return nil, false
+	}
+	mirror, exists := s.mirrors[routeKey]
+	return mirror, exists
+}
+
+func (s *proxySnapshot) outlierDetectorFor(routeKey string) (*outlierDetector, bool) {
+	if s == nil {
+		return nil, false
+	}
+	detector, exists := s.outliers[routeKey]
+	return detector, exists
+}
+
+func (s *proxySnapshot) retryBudgetFor(routeKey string) (*retryBudget, bool) {
+	if s == nil {
+		return nil, false
+	}
+	budget, exists := s.retryBudgets[routeKey]
+	return budget, exists
+}
+
+func (s *proxySnapshot) hedgerFor(routeKey string) (*hedger, bool) {
+	if s == nil {
+		return nil, false
+	}
+	hedge, exists := s.hedgers[routeKey]
+	return hedge, exists
+}
+
+func (s *proxySnapshot) getLoadBalancer(match *RouteMatch) LoadBalancer {
+	lb, exists := s.loadBalancers[match.key]
+	if !exists {
+		return NewLoadBalancer(match.Route.LoadBalancer)
+	}
+	return lb
+}
+
+// Atomically swaps in a new routing table. Requests already in flight finish
+// on the snapshot they started with; new requests see the new config.
+func (p *Proxy) Reload(config *GatewayConfig, timeoutConfig TimeoutConfig) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	next := newProxySnapshot(config, timeoutConfig, p.snapshot.Load())
+	p.attachBreakers(next)
+	p.snapshot.Store(next)
+
+	log.Printf("♻️ Gateway config reloaded (%d routes)", len(config.Routes))
+}
@@ -0,0 +1,145 @@
+package gateway
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func testConfig(routes ...Route) *GatewayConfig {
+	config := &GatewayConfig{
+		Routes:         routes,
+		Timeouts:       DefaultTimeoutConfig(),
+		CircuitBreaker: DefaultCircuitBreakerConfig(),
+		Bulkhead:       DefaultBulkheadConfig(),
+		Retry:          DefaultRetryConfig(),
+	}
+	config.normalizeBackends()
+	return config
+}
+
+func TestProxyReload(t *testing.T) {
+	t.Run("CarriesOverBackendState", func(t *testing.T) {
+		proxy := NewProxy(testConfig(
+			Route{Pattern: "/api/*", Backends: []Backend{
+				{URL: "http://backend-a:8000", Healthy: true, Weight: 1},
+				{URL: "http://backend-b:8000", Healthy: true, Weight: 1},
+			}},
+		), DefaultTimeoutConfig())
+
+		before := proxy.snapshot.Load()
+		breakerA := before.config.Routes[0].Backends[0].CircuitBreaker
+		for i := 0; i < DefaultCircuitBreakerConfig().FailureThreshold; i++ {
+			breakerA.RecordFailure()
+		}
+
+		proxy.Reload(testConfig(
+			Route{Pattern: "/api/*", Backends: []Backend{
+				{URL: "http://backend-a:8000", Healthy: true, Weight: 1},
+				{URL: "http://backend-c:8000", Healthy: true, Weight: 1},
+			}},
+		), DefaultTimeoutConfig())
+
+		after := proxy.snapshot.Load()
+
+		if after.config.Routes[0].Backends[0].CircuitBreaker != breakerA {
+			t.Error("Expected circuit breaker for backend-a to be carried over")
+		}
+
+		if after.config.Routes[0].Backends[0].CircuitBreaker.GetState() != StateOpen {
+			t.Errorf("Expected carried over breaker to stay OPEN, got %s", breakerA.GetState())
+		}
+
+		if after.bulkheads["http://backend-a:8000"] != before.bulkheads["http://backend-a:8000"] {
+			t.Error("Expected bulkhead for backend-a to be carried over")
+		}
+
+		if _, exists := after.bulkheads["http://backend-b:8000"]; exists {
+			t.Error("Expected state for removed backend-b to be torn down")
+		}
+
+		match := &RouteMatch{Route: &after.config.Routes[0], key: after.config.Routes[0].key()}
+		if after.getLoadBalancer(match) != before.getLoadBalancer(match) {
+			t.Error("Expected load balancer to be reused when strategy is unchanged")
+		}
+	})
+
+	t.Run("ForgetsRemovedBackendsInBalancer", func(t *testing.T) {
+		proxy := NewProxy(testConfig(
+			Route{Pattern: "/api/*", LoadBalancer: LeastConnections, Backends: []Backend{
+				{URL: "http://backend-a:8000", Healthy: true, Weight: 1},
+				{URL: "http://backend-b:8000", Healthy: true, Weight: 1},
+			}},
+		), DefaultTimeoutConfig())
+
+		lb := proxy.snapshot.Load().loadBalancers["/api/*"].(*LeastConnectionsBalancer)
+		lb.IncrementConnections("http://backend-a:8000")
+		lb.IncrementConnections("http://backend-b:8000")
+
+		proxy.Reload(testConfig(
+			Route{Pattern: "/api/*", LoadBalancer: LeastConnections, Backends: []Backend{
+				{URL: "http://backend-a:8000", Healthy: true, Weight: 1},
+			}},
+		), DefaultTimeoutConfig())
+
+		if proxy.snapshot.Load().loadBalancers["/api/*"] != lb {
+			t.Fatal("Expected load balancer to be reused when strategy is unchanged")
+		}
+		if _, kept := lb.connections.counters.Load("http://backend-b:8000"); kept {
+			t.Error("Expected the connection count of removed backend-b to be dropped")
+		}
+		if got := lb.connections.get("http://backend-a:8000"); got != 1 {
+			t.Errorf("Expected backend-a to keep 1 connection, got %d", got)
+		}
+
+		// a request to backend-b that was still in flight ends without recreating it
+		lb.DecrementConnections("http://backend-b:8000")
+		if _, kept := lb.connections.counters.Load("http://backend-b:8000"); kept {
+			t.Error("Expected a late decrement not to recreate backend-b's count")
+		}
+	})
+
+	t.Run("InFlightRequestsFinishOnOldSnapshot", func(t *testing.T) {
+		started := make(chan struct{})
+		release := make(chan struct{})
+		backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			close(started)
+			<-release
+			w.WriteHeader(http.StatusOK)
+		}))
+		defer backend.Close()
+
+		proxy := NewProxy(testConfig(
+			Route{Pattern: "/slow", Target: backend.URL},
+		), DefaultTimeoutConfig())
+
+		done := make(chan int)
+		go func() {
+			w := httptest.NewRecorder()
+			proxy.ServeHTTP(w, httptest.NewRequest("GET", "/slow", nil))
+			done <- w.Code
+		}()
+
+		<-started
+		proxy.Reload(testConfig(
+			Route{Pattern: "/other", Target: "http://elsewhere:8000"},
+		), DefaultTimeoutConfig())
+		close(release)
+
+		select {
+		case code := <-done:
+			if code != http.StatusOK {
+				t.Errorf("Expected in-flight request to complete with 200, got %d", code)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("In-flight request did not complete")
+		}
+
+		w := httptest.NewRecorder()
+		proxy.ServeHTTP(w, httptest.NewRequest("GET", "/slow", nil))
+		if w.Code != http.StatusNotFound {
+			t.Errorf("Expected removed route to return 404 after reload, got %d", w.Code)
+		}
+	})
+}
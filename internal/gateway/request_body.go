@@ -0,0 +1,145 @@
+package gateway
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"sync"
+)
+
+// Returned for a failed request that could not be retried because its body
+// was too large to keep around for another attempt
+var ErrBodyNotReplayable = errors.New("request body is larger than the replay limit and was not retried")
+
+// A request body read once and handed to every attempt and the mirror. Bodies up
+// to the memory limit stay in memory, larger ones are spilled to a temp file.
+// Bodies over the replay limit are passed through as they are, once.
+type requestBody struct {
+	memory []byte
+	file   *os.File // spilled body, removed once the last reference is released
+	size   int64
+
+	oneShot io.Reader // bodies over the replay limit, nil for replayable ones
+
+	mutex sync.Mutex
+	refs  int
+}
+
+// Reads r's body so it can be replayed, nil when there is none
+func newRequestBody(r *http.Request, memoryLimit, replayLimit int64) (*requestBody, error) {
+	if r.Body == nil || r.Body == http.NoBody {
+		return nil, nil
+	}
+
+	body := &requestBody{refs: 1}
+
+	// known to be too large, don't read any of it up front
+	if r.ContentLength > replayLimit {
+		body.oneShot = r.Body
+		return body, nil
+	}
+
+	memory, err := io.ReadAll(io.LimitReader(r.Body, memoryLimit+1))
+	if err != nil {
+		r.Body.Close()
+		return nil, err
+	}
+	if int64(len(memory)) <= memoryLimit {
+		r.Body.Close()
+		body.memory = memory
+		body.size = int64(len(memory))
+		return body, nil
+	}
+
+	body.file, err = os.CreateTemp("", "gateway-body-*")
+	if err != nil {
+		r.Body.Close()
+		return nil, fmt.Errorf("could not spill request body: %w", err)
+	}
+
+	// one byte past the limit tells a body that fits from one that doesn't
+	written, err := io.Copy(body.file, io.MultiReader(bytes.NewReader(memory), io.LimitReader(r.Body, replayLimit+1-int64(len(memory)))))
+	if err != nil {
+		r.Body.Close()
+		body.release()
+		return nil, fmt.Errorf("could not spill request body: %w", err)
+	}
+	body.size = written
+
+	if written > replayLimit {
+		log.Printf("⚠️ Request body of %s %s is over the replay limit of %d bytes, it won't be retried", r.Method, r.URL.Path, replayLimit)
+		body.oneShot = io.MultiReader(io.NewSectionReader(body.file, 0, written), r.Body)
+		return body, nil
+	}
+
+	r.Body.Close()
+	return body, nil
+}
+
+// Hands r's body to a single attempt as it is, without reading any of it up
+// front. For requests that won't be retried, hedged or mirrored.
+func streamedBody(r *http.Request) *requestBody {
+	if r.Body == nil || r.Body == http.NoBody {
+		return nil
+	}
+	return &requestBody{oneShot: r.Body, refs: 1}
+}
+
+// Whether every attempt can be sent the whole body. Nil bodies are.
+func (rb *requestBody) replayable() bool {
+	return rb == nil || rb.oneShot == nil
+}
+
+// A fresh reader over the body for one attempt. A body that isn't replayable
+// can only be read by the first attempt.
+func (rb *requestBody) reader() io.ReadCloser {
+	if rb == nil {
+		return http.NoBody
+	}
+	if rb.oneShot != nil {
+		return io.NopCloser(rb.oneShot)
+	}
+	if rb.file != nil {
+		return io.NopCloser(io.NewSectionReader(rb.file, 0, rb.size))
+	}
+	return io.NopCloser(bytes.NewReader(rb.memory))
+}
+
+// Takes another reference for a reader that outlives the request, such as the
+// mirror. Returns false for bodies that can't be read twice.
+func (rb *requestBody) retain() bool {
+	if rb == nil {
+		return true
+	}
+	if !rb.replayable() {
+		return false
+	}
+
+	rb.mutex.Lock()
+	defer rb.mutex.Unlock()
+	rb.refs++
+	return true
+}
+
+// Drops a reference, the spilled file goes with the last one. Safe to call on nil.
+func (rb *requestBody) release() {
+	if rb == nil {
+		return
+	}
+
+	rb.mutex.Lock()
+	defer rb.mutex.Unlock()
+
+	rb.refs--
+	if rb.refs > 0 || rb.file == nil {
+		return
+	}
+
+	rb.file.Close()
+	os.Remove(rb.file.Name())
+	rb.file = nil
+}
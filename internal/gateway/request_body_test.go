@@ -0,0 +1,206 @@
+package gateway
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestRequestBody(t *testing.T) {
+	t.Run("SmallBodyStaysInMemory", func(t *testing.T) {
+		body, err := newRequestBody(httptest.NewRequest("POST", "/", strings.NewReader("hello")), 16, 64)
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		defer body.release()
+
+		if body.file != nil || !body.replayable() {
+			t.Errorf("Expected a replayable in-memory body, got file %v", body.file)
+		}
+		for i := 0; i < 2; i++ {
+			if data, _ := io.ReadAll(body.reader()); string(data) != "hello" {
+				t.Errorf("Expected read %d to return the whole body, got %q", i+1, data)
+			}
+		}
+	})
+
+	t.Run("LargeBodySpillsToFile", func(t *testing.T) {
+		payload := strings.Repeat("x", 40)
+		body, err := newRequestBody(httptest.NewRequest("POST", "/", strings.NewReader(payload)), 16, 64)
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+
+		if body.file == nil || !body.replayable() {
+			t.Fatal("Expected a replayable body spilled to a file")
+		}
+		name := body.file.Name()
+
+		for i := 0; i < 2; i++ {
+			if data, _ := io.ReadAll(body.reader()); string(data) != payload {
+				t.Errorf("Expected read %d to return the whole body, got %d bytes", i+1, len(data))
+			}
+		}
+
+		// the mirror keeps the file alive until it is done
+		body.retain()
+		body.release()
+		if _, err := os.Stat(name); err != nil {
+			t.Errorf("Expected the file to survive while referenced, got %v", err)
+		}
+
+		body.release()
+		if _, err := os.Stat(name); !os.IsNotExist(err) {
+			t.Errorf("Expected the file to be removed with the last reference, got %v", err)
+		}
+	})
+
+	t.Run("OversizedBodyIsReadOnce", func(t *testing.T) {
+		payload := strings.Repeat("x", 100)
+		req := httptest.NewRequest("POST", "/", io.NopCloser(strings.NewReader(payload)))
+		req.ContentLength = -1 // chunked, the size only shows while reading
+
+		body, err := newRequestBody(req, 16, 64)
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		defer body.release()
+
+		if body.replayable() || body.retain() {
+			t.Fatal("Expected a body over the replay limit not to be replayable")
+		}
+		if data, _ := io.ReadAll(body.reader()); string(data) != payload {
+			t.Errorf("Expected the single read to return the whole body, got %d bytes", len(data))
+		}
+	})
+}
+
+func TestReplayedRetries(t *testing.T) {
+	newFlakyBackend := func() (*httptest.Server, func() []string) {
+		var mutex sync.Mutex
+		var bodies []string
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			data, _ := io.ReadAll(r.Body)
+
+			mutex.Lock()
+			bodies = append(bodies, string(data))
+			first := len(bodies) == 1
+			mutex.Unlock()
+
+			if first {
+				w.WriteHeader(http.StatusBadGateway)
+				return
+			}
+			w.WriteHeader(http.StatusOK)
+		}))
+
+		return server, func() []string {
+			mutex.Lock()
+			defer mutex.Unlock()
+			return append([]string(nil), bodies...)
+		}
+	}
+
+	retryConfig := func() *RetryConfig {
+		retry := DefaultRetryConfig()
+		retry.InitialDelay = time.Millisecond
+		retry.MaxMemoryBody = 16
+		retry.MaxReplayBody = 64
+		return &retry
+	}
+
+	t.Run("RetryResendsSpilledBody", func(t *testing.T) {
+		backend, bodies := newFlakyBackend()
+		defer backend.Close()
+
+		proxy := NewProxy(testConfig(Route{Pattern: "/api/*", Target: backend.URL, Retry: retryConfig()}), DefaultTimeoutConfig())
+
+		payload := strings.Repeat("y", 40)
+		req := httptest.NewRequest("POST", "/api/orders", strings.NewReader(payload))
+		req.Header.Set("Idempotency-Key", "order-42")
+		w := httptest.NewRecorder()
+		proxy.ServeHTTP(w, req)
+
+		if w.Code != http.StatusOK {
+			t.Fatalf("Expected the retry to succeed, got %d", w.Code)
+		}
+		got := bodies()
+		if len(got) != 2 || got[0] != payload || got[1] != payload {
+			t.Errorf("Expected both attempts to get the whole body, got %q", got)
+		}
+	})
+
+	t.Run("PostWithoutIdempotencyKeyIsNotRetried", func(t *testing.T) {
+		backend, bodies := newFlakyBackend()
+		defer backend.Close()
+
+		proxy := NewProxy(testConfig(Route{Pattern: "/api/*", Target: backend.URL, Retry: retryConfig()}), DefaultTimeoutConfig())
+
+		w := httptest.NewRecorder()
+		proxy.ServeHTTP(w, httptest.NewRequest("POST", "/api/orders", strings.NewReader("order")))
+
+		if len(bodies()) != 1 || w.Code != http.StatusServiceUnavailable {
+			t.Errorf("Expected a single failed attempt, got %d attempts and status %d", len(bodies()), w.Code)
+		}
+	})
+
+	t.Run("BodyWithoutRetriesIsStreamed", func(t *testing.T) {
+		started := make(chan struct{})
+		backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			first := make([]byte, 5)
+			io.ReadFull(r.Body, first)
+			close(started)
+			io.Copy(io.Discard, r.Body)
+		}))
+		defer backend.Close()
+
+		proxy := NewProxy(testConfig(Route{Pattern: "/api/*", Target: backend.URL, Retry: retryConfig()}), DefaultTimeoutConfig())
+
+		// the rest of the body only comes once the backend has the start of it
+		reader, writer := io.Pipe()
+		streamed := false
+		go func() {
+			writer.Write([]byte("first"))
+			select {
+			case <-started:
+				streamed = true
+			case <-time.After(2 * time.Second):
+			}
+			writer.Write([]byte(strings.Repeat("z", 100)))
+			writer.Close()
+		}()
+
+		req := httptest.NewRequest("POST", "/api/uploads", reader)
+		req.ContentLength = -1
+		w := httptest.NewRecorder()
+		proxy.ServeHTTP(w, req)
+
+		if w.Code != http.StatusOK || !streamed {
+			t.Errorf("Expected a POST that won't be retried to reach the backend before its body is complete, got %d, streamed %v", w.Code, streamed)
+		}
+	})
+
+	t.Run("OversizedBodyFailsClearly", func(t *testing.T) {
+		backend, bodies := newFlakyBackend()
+		defer backend.Close()
+
+		proxy := NewProxy(testConfig(Route{Pattern: "/api/*", Target: backend.URL, Retry: retryConfig()}), DefaultTimeoutConfig())
+
+		payload := strings.Repeat("z", 100)
+		w := httptest.NewRecorder()
+		proxy.ServeHTTP(w, httptest.NewRequest("PUT", "/api/orders/42", strings.NewReader(payload)))
+
+		got := bodies()
+		if len(got) != 1 || got[0] != payload {
+			t.Errorf("Expected one attempt with the whole body, got %d attempts", len(got))
+		}
+		if w.Code != http.StatusServiceUnavailable || !strings.Contains(w.Body.String(), ErrBodyNotReplayable.Error()) {
+			t.Errorf("Expected a 503 explaining the body could not be replayed, got %d %q", w.Code, w.Body.String())
+		}
+	})
+}
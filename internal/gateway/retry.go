@@ -2,20 +2,41 @@ package gateway
 
 import (
 	"context"
+	"crypto/tls"
+	"encoding/json"
 	"errors"
+	"fmt"
+	"io"
 	"log"
 	"math"
 	"math/rand"
+	"net"
+	"net/http"
+	"slices"
+	"strconv"
 	"strings"
+	"syscall"
 	"time"
 )
 
 type RetryConfig struct {
-	MaxAttempts  int
-	InitialDelay time.Duration
-	MaxDelay     time.Duration
-	Multiplier   float64
-	Jitter       bool
+	MaxAttempts  int               `json:"max_attempts"`
+	InitialDelay time.Duration     `json:"initial_delay"`
+	MaxDelay     time.Duration     `json:"max_delay"`
+	Multiplier   float64           `json:"multiplier"`
+	Jitter       bool              `json:"jitter"`
+	Methods      []string          `json:"methods"` // methods that may be retried, empty means the idempotent ones
+	Budget       RetryBudgetConfig `json:"budget"`
+
+	// Requests carrying this header may be retried whatever their method, "" turns it off
+	IdempotencyKeyHeader string `json:"idempotency_key_header"`
+	MaxMemoryBody        int64  `json:"max_memory_body"` // bytes of a request body kept in memory for retries, the rest goes to a temp file
+	MaxReplayBody        int64  `json:"max_replay_body"` // larger bodies are streamed to the backend once and never retried
+
+	RetryOn           []int         `json:"retry_on"`            // response statuses that are retried
+	RetryOnErrors     []string      `json:"retry_on_errors"`     // classes of backend errors that are retried, see ErrorClasses
+	PerTryTimeout     time.Duration `json:"per_try_timeout"`     // limit for each attempt, 0 uses timeouts.backend_timeout
+	RespectRetryAfter bool          `json:"respect_retry_after"` // wait as long as the backend's Retry-After asks, if the request deadline allows
 }
 
 func DefaultRetryConfig() RetryConfig {
@@ -25,47 +46,172 @@ func DefaultRetryConfig() RetryConfig {
 		MaxDelay:     5 * time.Second,
 		Multiplier:   2.0,
 		Jitter:       true,
+		Budget:       DefaultRetryBudgetConfig(),
+
+		IdempotencyKeyHeader: "Idempotency-Key",
+		MaxMemoryBody:        1 << 20,  // 1 MiB
+		MaxReplayBody:        32 << 20, // 32 MiB
+
+		RetryOn:           []int{429, 500, 502, 503, 504},
+		RetryOnErrors:     []string{ErrorConnectRefused, ErrorConnectionReset, ErrorDNS},
+		RespectRetryAfter: true,
+	}
+}
+
+func (r *RetryConfig) UnmarshalJSON(data []byte) error {
+	type alias RetryConfig
+	aux := struct {
+		*alias
+		InitialDelay  Duration `json:"initial_delay"`
+		MaxDelay      Duration `json:"max_delay"`
+		PerTryTimeout Duration `json:"per_try_timeout"`
+	}{
+		alias:         (*alias)(r),
+		InitialDelay:  Duration(r.InitialDelay),
+		MaxDelay:      Duration(r.MaxDelay),
+		PerTryTimeout: Duration(r.PerTryTimeout),
+	}
+
+	// route blocks are decoded over a copy of the global block, lists decoded in
+	// place would otherwise overwrite the global ones
+	r.Methods = slices.Clone(r.Methods)
+	r.RetryOn = slices.Clone(r.RetryOn)
+	r.RetryOnErrors = slices.Clone(r.RetryOnErrors)
+
+	if err := decodeStrict(data, &aux); err != nil {
+		return err
+	}
+
+	r.InitialDelay = time.Duration(aux.InitialDelay)
+	r.MaxDelay = time.Duration(aux.MaxDelay)
+	r.PerTryTimeout = time.Duration(aux.PerTryTimeout)
+	return nil
+}
+
+func (r RetryConfig) MarshalJSON() ([]byte, error) {
+	type alias RetryConfig
+	return json.Marshal(struct {
+		alias
+		InitialDelay  Duration `json:"initial_delay"`
+		MaxDelay      Duration `json:"max_delay"`
+		PerTryTimeout Duration `json:"per_try_timeout"`
+	}{
+		alias:         alias(r),
+		InitialDelay:  Duration(r.InitialDelay),
+		MaxDelay:      Duration(r.MaxDelay),
+		PerTryTimeout: Duration(r.PerTryTimeout),
+	})
+}
+
+// Methods that are safe to send twice, retried when no methods are configured
+var idempotentMethods = []string{"GET", "HEAD", "OPTIONS", "TRACE", "PUT", "DELETE"}
+
+func (r *RetryConfig) allowsMethod(method string) bool {
+	methods := r.Methods
+	if len(methods) == 0 {
+		methods = idempotentMethods
+	}
+	for _, allowed := range methods {
+		if strings.EqualFold(allowed, method) {
+			return true
+		}
+	}
+	return false
+}
+
+// Whether a failed request may be sent again at all: its method allows it, or
+// the client marked it safe to repeat with an idempotency key
+func (r *RetryConfig) allowsRequest(req *http.Request) bool {
+	if r.allowsMethod(req.Method) {
+		return true
 	}
+	return r.IdempotencyKeyHeader != "" && req.Header.Get(r.IdempotencyKeyHeader) != ""
+}
+
+// What an attempt came back with, for deciding whether to retry it
+type retryOutcome struct {
+	status     int
+	err        error
+	retryAfter time.Duration // asked for by the backend, 0 when it didn't
 }
 
 func (r *RetryConfig) ExecuteWithRetry(ctx context.Context, operation func() (int, error)) error {
+	return r.executeWithRetry(ctx, func() retryOutcome {
+		status, err := operation()
+		return retryOutcome{status: status, err: err}
+	}, nil)
+}
+
+// Like ExecuteWithRetry, but asks allowRetry before each retry, which can turn it down.
+// Returns the error of the last attempt, nil when it succeeded or only got a
+// retryable status that the caller can pass on.
+func (r *RetryConfig) executeWithRetry(ctx context.Context, operation func() retryOutcome, allowRetry func() bool) error {
 	var lastErr error
 
 	for attempt := 0; attempt < r.MaxAttempts; attempt++ {
 
-		statusCode, err := operation()
-		if err == nil && !isRetryableStatusCode(statusCode) { // success
-			return nil
+		outcome := operation()
+		lastErr = outcome.err
+
+		reason := r.retryReason(ctx, outcome)
+		if reason == "" {
+			break
 		}
+		log.Printf("⚠️ Retryable %s: status=%d, error=%v", reason, outcome.status, outcome.err)
 
-		// check if error is retryable
-		shouldRetry := false
-		if err != nil && isRetryableError(err) {
-			log.Printf("⚠️ Retryable error encountered: %v", err)
-			shouldRetry = true
+		if attempt == r.MaxAttempts-1 {
+			break
 		}
 
-		if err != nil && isRetryableStatusCode(statusCode) {
-			log.Printf("⚠️ Retryable server error: status=%d, error=%v", statusCode, err)
-			shouldRetry = true
-			lastErr = err
+		delay := r.calculateDelay(attempt)
+		if r.RespectRetryAfter && outcome.retryAfter > delay {
+			delay = outcome.retryAfter
 		}
 
-		if attempt == r.MaxAttempts-1 {
+		// a retry that can't start before the request times out isn't worth it
+		if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) <= delay {
+			log.Printf("⏳ Not retrying, waiting %v would pass the request deadline", delay)
 			break
 		}
 
-		if !shouldRetry {
+		if allowRetry != nil && !allowRetry() {
 			break
 		}
 
-		delay := r.calculateDelay(attempt)
-		time.Sleep(delay)
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return lastErr
+		}
 	}
 
 	return lastErr
 }
 
+// Why an outcome is worth another attempt, "" when it isn't. Errors that can be
+// classified are retried by their class, anything else by the response status.
+// With a per_try_timeout, attempts it cut off are always retried, that's what it is for.
+func (r *RetryConfig) retryReason(ctx context.Context, outcome retryOutcome) string {
+	// the request itself timed out or went away
+	if ctx.Err() != nil {
+		return ""
+	}
+
+	if outcome.err != nil {
+		if class := classifyError(outcome.err); class != "" {
+			if slices.Contains(r.RetryOnErrors, class) || (class == ErrorTimeout && r.PerTryTimeout > 0) {
+				return class + " error"
+			}
+			return ""
+		}
+	}
+
+	if slices.Contains(r.RetryOn, outcome.status) {
+		return fmt.Sprintf("status %d", outcome.status)
+	}
+	return ""
+}
+
 func (r *RetryConfig) calculateDelay(attempt int) time.Duration {
 	delay := time.Duration(float64(r.InitialDelay) * math.Pow(r.Multiplier, float64(attempt)))
 
@@ -82,40 +228,54 @@ func (r *RetryConfig) calculateDelay(attempt int) time.Duration {
 	return delay
 }
 
-func isRetryableStatusCode(statusCode int) bool {
-	return statusCode >= 500
-}
+// Classes of backend errors a retry policy can list
+const (
+	ErrorConnectRefused  = "connect_refused"  // the connection was refused or the backend unreachable
+	ErrorConnectionReset = "connection_reset" // the connection broke before the response came back
+	ErrorTLS             = "tls"              // the TLS handshake or certificate check failed
+	ErrorDNS             = "dns"              // the backend's host name didn't resolve
+	ErrorTimeout         = "timeout"          // the attempt ran into its per-try timeout
+)
 
-func isRetryableError(err error) bool {
-	if err == nil {
-		return false
-	}
+var ErrorClasses = []string{ErrorConnectRefused, ErrorConnectionReset, ErrorTLS, ErrorDNS, ErrorTimeout}
 
-	if IsTimeoutError(err) {
-		return false
-	}
+// Sorts a backend error into one of the error classes by its type, "" for
+// errors that aren't about reaching the backend
+func classifyError(err error) string {
+	var dnsErr *net.DNSError
+	var certErr *tls.CertificateVerificationError
+	var recordErr tls.RecordHeaderError
+	var alertErr tls.AlertError
+	var opErr *net.OpError
 
-	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
-		return false
+	switch {
+	case errors.As(err, &dnsErr):
+		return ErrorDNS
+	case errors.As(err, &certErr), errors.As(err, &recordErr), errors.As(err, &alertErr):
+		return ErrorTLS
+	case errors.Is(err, syscall.ECONNREFUSED), errors.Is(err, syscall.EHOSTUNREACH), errors.Is(err, syscall.ENETUNREACH):
+		return ErrorConnectRefused
+	case errors.Is(err, syscall.ECONNRESET), errors.Is(err, syscall.EPIPE), errors.Is(err, io.EOF), errors.Is(err, io.ErrUnexpectedEOF):
+		return ErrorConnectionReset
+	case errors.Is(err, context.DeadlineExceeded):
+		return ErrorTimeout
+	case errors.As(err, &opErr) && opErr.Op == "dial":
+		return ErrorConnectRefused
 	}
+	return ""
+}
 
-	errStr := err.Error()
-
-	retryablePatterns := []string{
-		"connection refused",
-		"connection reset",
-		"connection timeout",
-		"no such host",
-		"network is unreachable",
-		"i/o timeout",
-		"EOF",
+// Parses a Retry-After header, given in seconds or as an HTTP date. 0 when
+// there is none or it is already past.
+func parseRetryAfter(value string, now time.Time) time.Duration {
+	if value == "" {
+		return 0
 	}
-
-	for _, pattern := range retryablePatterns {
-		if strings.Contains(strings.ToLower(errStr), pattern) {
-			return true
-		}
+	if seconds, err := strconv.Atoi(strings.TrimSpace(value)); err == nil {
+		return max(time.Duration(seconds)*time.Second, 0)
 	}
-
-	return false
+	if date, err := http.ParseTime(value); err == nil {
+		return max(date.Sub(now), 0)
+	}
+	return 0
 }
@@ -0,0 +1,203 @@
+package gateway
+
+import (
+	"encoding/json"
+	"sync"
+	"time"
+)
+
+// Caps retries at a share of recent traffic so a degraded backend doesn't get
+// hit with MaxAttempts times its normal load. A route's budget and the budget of
+// the backend the retry goes to both need room for it. Percent 0 disables it.
+type RetryBudgetConfig struct {
+	Percent             float64       `json:"percent"`                // retries allowed per 100 requests
+	MinRetriesPerSecond int           `json:"min_retries_per_second"` // allowed regardless of traffic, keeps low-traffic routes retrying
+	Window              time.Duration `json:"window"`                 // how far back requests and retries are counted, whole seconds
+}
+
+func DefaultRetryBudgetConfig() RetryBudgetConfig {
+	return RetryBudgetConfig{
+		MinRetriesPerSecond: 10,
+		Window:              10 * time.Second,
+	}
+}
+
+func (c *RetryBudgetConfig) UnmarshalJSON(data []byte) error {
+	type alias RetryBudgetConfig
+	aux := struct {
+		*alias
+		Window Duration `json:"window"`
+	}{
+		alias:  (*alias)(c),
+		Window: Duration(c.Window),
+	}
+
+	if err := decodeStrict(data, &aux); err != nil {
+		return err
+	}
+
+	c.Window = time.Duration(aux.Window)
+	return nil
+}
+
+func (c RetryBudgetConfig) MarshalJSON() ([]byte, error) {
+	type alias RetryBudgetConfig
+	return json.Marshal(struct {
+		alias
+		Window Duration `json:"window"`
+	}{
+		alias:  alias(c),
+		Window: Duration(c.Window),
+	})
+}
+
+func (c RetryBudgetConfig) enabled() bool {
+	return c.Percent > 0
+}
+
+// Which budget turned a retry down, sent in this header on the response
+const (
+	RetryBudgetHeader  = "X-Retry-Budget-Exhausted"
+	RetryBudgetRoute   = "route"
+	RetryBudgetBackend = "backend"
+)
+
+// Requests and the retries sent for them
+type retryCounts struct {
+	requests int
+	retries  int
+}
+
+func (rc retryCounts) plus(other retryCounts) retryCounts {
+	return retryCounts{requests: rc.requests + other.requests, retries: rc.retries + other.retries}
+}
+
+// Whether one more retry stays under percent of the requests, or under floor retries
+func (rc retryCounts) allows(percent, floor float64) bool {
+	return withinShare(rc.retries, rc.requests, percent, floor)
+}
+
+type retryBudget struct {
+	config    RetryBudgetConfig
+	mutex     sync.Mutex
+	route     *secondsWindow[retryCounts]
+	backends  map[string]*secondsWindow[retryCounts] // backend URL -> attempts it got and the retries among them
+	exhausted map[string]int64                       // RetryBudgetRoute or RetryBudgetBackend -> retries turned down
+}
+
+func newRetryBudget(config RetryBudgetConfig) *retryBudget {
+	return &retryBudget{
+		config:    config,
+		route:     newSecondsWindow[retryCounts](config.Window),
+		backends:  make(map[string]*secondsWindow[retryCounts]),
+		exhausted: make(map[string]int64),
+	}
+}
+
+func (rb *retryBudget) backendLocked(url string) *secondsWindow[retryCounts] {
+	window, exists := rb.backends[url]
+	if !exists {
+		// backends come and go with the registry, drop the ones that went quiet
+		rb.pruneLocked(time.Now())
+		window = newSecondsWindow[retryCounts](rb.config.Window)
+		rb.backends[url] = window
+	}
+	return window
+}
+
+// Counts an incoming request. Safe to call on nil.
+func (rb *retryBudget) recordRequest() {
+	if rb == nil {
+		return
+	}
+
+	rb.mutex.Lock()
+	defer rb.mutex.Unlock()
+
+	rb.route.bucket(time.Now()).requests++
+}
+
+// Counts an attempt sent to a backend, retries included. Safe to call on nil.
+func (rb *retryBudget) recordAttempt(url string) {
+	if rb == nil {
+		return
+	}
+
+	rb.mutex.Lock()
+	defer rb.mutex.Unlock()
+
+	rb.backendLocked(url).bucket(time.Now()).requests++
+}
+
+// Takes a retry to backend url out of the budget. With url "" only the route's
+// budget is used. Returns "" when the retry may go ahead, otherwise the budget
+// that ran out. Safe to call on nil.
+func (rb *retryBudget) withdraw(url string) string {
+	if rb == nil {
+		return ""
+	}
+
+	rb.mutex.Lock()
+	defer rb.mutex.Unlock()
+
+	now := time.Now()
+	var backend *secondsWindow[retryCounts]
+	if url != "" {
+		backend = rb.backendLocked(url)
+	}
+
+	floor := float64(rb.config.MinRetriesPerSecond) * rb.config.Window.Seconds()
+	exhausted := ""
+	switch {
+	case !rb.route.totals(now).allows(rb.config.Percent, floor):
+		exhausted = RetryBudgetRoute
+	case backend != nil && !backend.totals(now).allows(rb.config.Percent, floor):
+		exhausted = RetryBudgetBackend
+	}
+
+	if exhausted != "" {
+		rb.exhausted[exhausted]++
+		return exhausted
+	}
+
+	rb.route.bucket(now).retries++
+	if backend != nil {
+		backend.bucket(now).retries++
+	}
+	return ""
+}
+
+// Forgets backends without traffic in the window
+func (rb *retryBudget) pruneLocked(now time.Time) {
+	for url, window := range rb.backends {
+		if total := window.totals(now); total.requests == 0 && total.retries == 0 {
+			delete(rb.backends, url)
+		}
+	}
+}
+
+func (rb *retryBudget) GetStats() map[string]any {
+	rb.mutex.Lock()
+	defer rb.mutex.Unlock()
+
+	now := time.Now()
+	rb.pruneLocked(now)
+
+	total := rb.route.totals(now)
+	backends := make(map[string]any, len(rb.backends))
+	for url, window := range rb.backends {
+		backendTotal := window.totals(now)
+		backends[url] = map[string]int{
+			"requests": backendTotal.requests,
+			"retries":  backendTotal.retries,
+		}
+	}
+
+	return map[string]any{
+		"requests":          total.requests,
+		"retries":           total.retries,
+		"exhausted_route":   rb.exhausted[RetryBudgetRoute],
+		"exhausted_backend": rb.exhausted[RetryBudgetBackend],
+		"backends":          backends,
+	}
+}
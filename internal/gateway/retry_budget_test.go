@@ -0,0 +1,199 @@
+package gateway
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestRetryBudget(t *testing.T) {
+	t.Run("FloorAllowsRetriesWithoutTraffic", func(t *testing.T) {
+		budget := newRetryBudget(RetryBudgetConfig{Percent: 20, MinRetriesPerSecond: 1, Window: 3 * time.Second})
+
+		for i := 0; i < 3; i++ {
+			if exhausted := budget.withdraw("http://backend-a:8000"); exhausted != "" {
+				t.Fatalf("Expected retry %d to fit the floor of 3, got %s exhausted", i+1, exhausted)
+			}
+		}
+		if exhausted := budget.withdraw("http://backend-a:8000"); exhausted != RetryBudgetRoute {
+			t.Errorf("Expected the route budget to run out, got %q", exhausted)
+		}
+	})
+
+	t.Run("PercentOfRecentRequests", func(t *testing.T) {
+		budget := newRetryBudget(RetryBudgetConfig{Percent: 10, Window: 10 * time.Second})
+
+		for i := 0; i < 100; i++ {
+			budget.recordRequest()
+			budget.recordAttempt("http://backend-a:8000")
+		}
+
+		allowed := 0
+		for budget.withdraw("http://backend-a:8000") == "" {
+			allowed++
+		}
+		if allowed != 10 {
+			t.Errorf("Expected 10 retries for 100 requests, got %d", allowed)
+		}
+
+		stats := budget.GetStats()
+		if stats["retries"] != 10 || stats["exhausted_route"] != int64(1) {
+			t.Errorf("Expected 10 retries and 1 turned down, got %v", stats)
+		}
+	})
+
+	t.Run("BackendBudgetProtectsOneBackend", func(t *testing.T) {
+		budget := newRetryBudget(RetryBudgetConfig{Percent: 50, Window: 10 * time.Second})
+
+		// plenty of route traffic, but only 4 attempts reached backend-b
+		for i := 0; i < 100; i++ {
+			budget.recordRequest()
+			budget.recordAttempt("http://backend-a:8000")
+		}
+		for i := 0; i < 4; i++ {
+			budget.recordAttempt("http://backend-b:8000")
+		}
+
+		allowed := 0
+		for budget.withdraw("http://backend-b:8000") == "" {
+			allowed++
+		}
+		if allowed != 2 {
+			t.Errorf("Expected 2 retries for backend-b's 4 attempts, got %d", allowed)
+		}
+		if exhausted := budget.withdraw("http://backend-b:8000"); exhausted != RetryBudgetBackend {
+			t.Errorf("Expected the backend budget to run out, got %q", exhausted)
+		}
+	})
+
+	t.Run("OldTrafficExpires", func(t *testing.T) {
+		window := newSecondsWindow[retryCounts](2 * time.Second)
+		start := time.Unix(1000, 0)
+
+		window.bucket(start).requests = 50
+		window.bucket(start.Add(time.Second)).retries = 5
+
+		if total := window.totals(start.Add(time.Second)); total.requests != 50 || total.retries != 5 {
+			t.Errorf("Expected 50 requests and 5 retries in the window, got %+v", total)
+		}
+		if total := window.totals(start.Add(2 * time.Second)); total.requests != 0 || total.retries != 5 {
+			t.Errorf("Expected the requests of the first second to expire, got %+v", total)
+		}
+	})
+
+	t.Run("NoBackendChargesOnlyTheRoute", func(t *testing.T) {
+		budget := newRetryBudget(RetryBudgetConfig{Percent: 10, MinRetriesPerSecond: 1, Window: 10 * time.Second})
+
+		if exhausted := budget.withdraw(""); exhausted != "" {
+			t.Fatalf("Expected the retry to fit the floor, got %s exhausted", exhausted)
+		}
+
+		stats := budget.GetStats()
+		if stats["retries"] != 1 || len(stats["backends"].(map[string]any)) != 0 {
+			t.Errorf("Expected 1 route retry and no backend charged, got %v", stats)
+		}
+	})
+
+	t.Run("ProxyChargesTheBackendRetriedOn", func(t *testing.T) {
+		failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusBadGateway)
+		}))
+		defer failing.Close()
+		healthy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+		defer healthy.Close()
+
+		retry := DefaultRetryConfig()
+		retry.InitialDelay = time.Millisecond
+		retry.Budget = RetryBudgetConfig{Percent: 50, MinRetriesPerSecond: 10, Window: 10 * time.Second}
+
+		config := testConfig(Route{
+			Pattern:  "/api/llm/*",
+			Backends: []Backend{{URL: failing.URL, Healthy: true, Weight: 1}, {URL: healthy.URL, Healthy: true, Weight: 1}},
+			Retry:    &retry,
+		})
+		proxy := NewProxy(config, DefaultTimeoutConfig())
+
+		// round robin sends one of the two requests to the failing backend first
+		for i := 0; i < 2; i++ {
+			proxy.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/api/llm/models", nil))
+		}
+
+		snapshot := proxy.snapshot.Load()
+		backends := snapshot.retryBudgets[snapshot.config.Routes[0].key()].GetStats()["backends"].(map[string]any)
+		if got := backends[healthy.URL].(map[string]int)["retries"]; got != 1 {
+			t.Errorf("Expected the retry to be charged to the backend it went to, got %v", backends)
+		}
+		if got := backends[failing.URL].(map[string]int)["retries"]; got != 0 {
+			t.Errorf("Expected nothing charged to the backend that failed, got %v", backends)
+		}
+	})
+
+	t.Run("ProxyStopsRetryingWhenExhausted", func(t *testing.T) {
+		var calls atomic.Int32
+		backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			calls.Add(1)
+			w.WriteHeader(http.StatusBadGateway)
+		}))
+		defer backend.Close()
+
+		retry := DefaultRetryConfig()
+		retry.InitialDelay = time.Millisecond
+		retry.Budget = RetryBudgetConfig{Percent: 20, MinRetriesPerSecond: 0, Window: 10 * time.Second}
+
+		proxy := NewProxy(testConfig(
+			Route{Pattern: "/api/llm/*", Target: backend.URL, Retry: &retry},
+		), DefaultTimeoutConfig())
+
+		var exhausted int
+		for i := 0; i < 10; i++ {
+			w := httptest.NewRecorder()
+			proxy.ServeHTTP(w, httptest.NewRequest("GET", "/api/llm/models", nil))
+			if w.Header().Get(RetryBudgetHeader) != "" {
+				exhausted++
+			}
+		}
+
+		// 10 requests allow 2 retries, without a budget they would have made 20.
+		// Every request still ran out of budget before its last attempt.
+		if calls.Load() != 12 {
+			t.Errorf("Expected 12 attempts, got %d", calls.Load())
+		}
+		if exhausted != 10 {
+			t.Errorf("Expected 10 responses with %s, got %d", RetryBudgetHeader, exhausted)
+		}
+	})
+
+	t.Run("InvalidBudget", func(t *testing.T) {
+		data := `
+retry:
+  budget:
+    percent: 120
+    min_retries_per_second: -1
+routes:
+  - pattern: /api/llm/*
+    target: http://llm:9000
+    retry:
+      budget:
+        percent: 20
+        window: 500ms
+`
+
+		_, err := ParseConfig([]byte(data), "yaml")
+		if err == nil {
+			t.Fatal("Expected an error, got nil")
+		}
+
+		for _, expected := range []string{
+			"retry.budget.percent: must be between 0 and 100",
+			"retry.budget.min_retries_per_second",
+			"routes[0].retry.budget.window: must be at least 1s",
+		} {
+			if !strings.Contains(err.Error(), expected) {
+				t.Errorf("Expected error to mention %s, got %v", expected, err)
+			}
+		}
+	})
+}
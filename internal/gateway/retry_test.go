@@ -0,0 +1,267 @@
+package gateway
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestRetryClassification(t *testing.T) {
+	t.Run("ClassifiesTypedErrors", func(t *testing.T) {
+		closed := httptest.NewServer(http.NotFoundHandler())
+		closedURL := closed.URL
+		closed.Close()
+		_, refused := http.Get(closedURL)
+
+		tlsServer := httptest.NewTLSServer(http.NotFoundHandler())
+		defer tlsServer.Close()
+		_, untrusted := http.Get(tlsServer.URL)
+
+		cases := []struct {
+			name     string
+			err      error
+			expected string
+		}{
+			{"refused", refused, ErrorConnectRefused},
+			{"untrusted certificate", untrusted, ErrorTLS},
+			{"dns", &net.OpError{Op: "dial", Err: &net.DNSError{Err: "no such host", Name: "api.invalid", IsNotFound: true}}, ErrorDNS},
+			{"eof", &net.OpError{Op: "read", Err: io.ErrUnexpectedEOF}, ErrorConnectionReset},
+			{"timeout", context.DeadlineExceeded, ErrorTimeout},
+			{"canceled", context.Canceled, ""},
+			{"server error", errors.New("server error: 500"), ""},
+		}
+
+		for _, c := range cases {
+			if got := classifyError(c.err); got != c.expected {
+				t.Errorf("Expected %s (%v) to be classified as %q, got %q", c.name, c.err, c.expected, got)
+			}
+		}
+	})
+
+	t.Run("ParsesRetryAfter", func(t *testing.T) {
+		now := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+
+		if got := parseRetryAfter("2", now); got != 2*time.Second {
+			t.Errorf("Expected 2s, got %v", got)
+		}
+		if got := parseRetryAfter(now.Add(3*time.Second).Format(http.TimeFormat), now); got != 3*time.Second {
+			t.Errorf("Expected 3s from an HTTP date, got %v", got)
+		}
+		if got := parseRetryAfter("soon", now); got != 0 {
+			t.Errorf("Expected 0 for an invalid value, got %v", got)
+		}
+	})
+
+	t.Run("ErrorClassNotListedIsNotRetried", func(t *testing.T) {
+		retry := DefaultRetryConfig()
+		retry.InitialDelay = time.Millisecond
+		retry.RetryOnErrors = []string{ErrorDNS}
+
+		calls := 0
+		retry.ExecuteWithRetry(context.Background(), func() (int, error) {
+			calls++
+			return 502, &net.OpError{Op: "read", Err: io.ErrUnexpectedEOF}
+		})
+
+		if calls != 1 {
+			t.Errorf("Expected a reset connection not to be retried, got %d calls", calls)
+		}
+	})
+}
+
+func TestRetryPolicy(t *testing.T) {
+	retryingProxy := func(backend *httptest.Server, retry RetryConfig, timeouts *TimeoutConfig) *Proxy {
+		retry.InitialDelay = time.Millisecond
+		retry.Jitter = false
+		return NewProxy(testConfig(Route{Pattern: "/api/*", Target: backend.URL, Retry: &retry, Timeouts: timeouts}), DefaultTimeoutConfig())
+	}
+
+	// answers the first call with status and header, the rest with 200
+	newBackend := func(status int, retryAfter string, calls *atomic.Int32) *httptest.Server {
+		return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if calls.Add(1) == 1 {
+				if retryAfter != "" {
+					w.Header().Set("Retry-After", retryAfter)
+				}
+				w.WriteHeader(status)
+				return
+			}
+			w.WriteHeader(http.StatusOK)
+		}))
+	}
+
+	t.Run("TooManyRequestsWaitsForRetryAfter", func(t *testing.T) {
+		var calls atomic.Int32
+		backend := newBackend(http.StatusTooManyRequests, "1", &calls)
+		defer backend.Close()
+
+		proxy := retryingProxy(backend, DefaultRetryConfig(), nil)
+
+		start := time.Now()
+		w := httptest.NewRecorder()
+		proxy.ServeHTTP(w, httptest.NewRequest("GET", "/api/items", nil))
+
+		if w.Code != http.StatusOK || calls.Load() != 2 {
+			t.Fatalf("Expected the retry to succeed, got %d after %d calls", w.Code, calls.Load())
+		}
+		if elapsed := time.Since(start); elapsed < time.Second {
+			t.Errorf("Expected the retry to wait for Retry-After, took %v", elapsed)
+		}
+	})
+
+	t.Run("RetryAfterPastDeadlineIsPassedOn", func(t *testing.T) {
+		var calls atomic.Int32
+		backend := newBackend(http.StatusTooManyRequests, "10", &calls)
+		defer backend.Close()
+
+		timeouts := DefaultTimeoutConfig()
+		timeouts.RequestTimeout = time.Second
+		proxy := retryingProxy(backend, DefaultRetryConfig(), &timeouts)
+
+		start := time.Now()
+		w := httptest.NewRecorder()
+		proxy.ServeHTTP(w, httptest.NewRequest("GET", "/api/items", nil))
+
+		if w.Code != http.StatusTooManyRequests || calls.Load() != 1 {
+			t.Errorf("Expected the 429 to be passed on after 1 call, got %d after %d calls", w.Code, calls.Load())
+		}
+		if w.Header().Get("Retry-After") != "10" {
+			t.Errorf("Expected the backend's Retry-After to be kept, got %q", w.Header().Get("Retry-After"))
+		}
+		if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+			t.Errorf("Expected no wait, took %v", elapsed)
+		}
+	})
+
+	t.Run("StatusNotListedIsNotRetried", func(t *testing.T) {
+		var calls atomic.Int32
+		backend := newBackend(http.StatusInternalServerError, "", &calls)
+		defer backend.Close()
+
+		retry := DefaultRetryConfig()
+		retry.RetryOn = []int{503}
+		proxy := retryingProxy(backend, retry, nil)
+
+		w := httptest.NewRecorder()
+		proxy.ServeHTTP(w, httptest.NewRequest("GET", "/api/items", nil))
+
+		if w.Code != http.StatusServiceUnavailable || calls.Load() != 1 {
+			t.Errorf("Expected a single failed attempt, got %d after %d calls", w.Code, calls.Load())
+		}
+	})
+
+	t.Run("PerTryTimeoutIsRetried", func(t *testing.T) {
+		var calls atomic.Int32
+		backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if calls.Add(1) == 1 {
+				select {
+				case <-time.After(2 * time.Second):
+				case <-r.Context().Done():
+				}
+				return
+			}
+			w.WriteHeader(http.StatusOK)
+		}))
+		defer backend.Close()
+
+		retry := DefaultRetryConfig()
+		retry.PerTryTimeout = 100 * time.Millisecond
+		retry.RetryOnErrors = append(retry.RetryOnErrors, ErrorTimeout)
+		proxy := retryingProxy(backend, retry, nil)
+
+		start := time.Now()
+		w := httptest.NewRecorder()
+		proxy.ServeHTTP(w, httptest.NewRequest("GET", "/api/items", nil))
+
+		if w.Code != http.StatusOK || calls.Load() != 2 {
+			t.Errorf("Expected the timed out attempt to be retried, got %d after %d calls", w.Code, calls.Load())
+		}
+		if elapsed := time.Since(start); elapsed > time.Second {
+			t.Errorf("Expected the first attempt to be cut short, took %v", elapsed)
+		}
+	})
+
+	t.Run("PerTryTimeoutIsRetriedByDefault", func(t *testing.T) {
+		var calls atomic.Int32
+		backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if calls.Add(1) == 1 {
+				select {
+				case <-time.After(2 * time.Second):
+				case <-r.Context().Done():
+				}
+				return
+			}
+			w.WriteHeader(http.StatusOK)
+		}))
+		defer backend.Close()
+
+		retry := DefaultRetryConfig()
+		retry.PerTryTimeout = 100 * time.Millisecond
+		proxy := retryingProxy(backend, retry, nil)
+
+		w := httptest.NewRecorder()
+		proxy.ServeHTTP(w, httptest.NewRequest("GET", "/api/items", nil))
+
+		if w.Code != http.StatusOK || calls.Load() != 2 {
+			t.Errorf("Expected the attempt cut off by per_try_timeout to be retried without listing timeout, got %d after %d calls", w.Code, calls.Load())
+		}
+	})
+
+	t.Run("RouteListsDontChangeGlobal", func(t *testing.T) {
+		data := `
+retry:
+  retry_on: [502, 503]
+routes:
+  - pattern: /api/*
+    target: http://api:8000
+    retry:
+      retry_on: [429]
+`
+
+		config, err := ParseConfig([]byte(data), "yaml")
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+
+		if got := config.Retry.RetryOn; len(got) != 2 || got[0] != 502 || got[1] != 503 {
+			t.Errorf("Expected the global retry_on to stay [502 503], got %v", got)
+		}
+		if got := config.Routes[0].Retry.RetryOn; len(got) != 1 || got[0] != 429 {
+			t.Errorf("Expected the route retry_on to be [429], got %v", got)
+		}
+	})
+
+	t.Run("InvalidPolicy", func(t *testing.T) {
+		data := `
+retry:
+  retry_on: [200]
+  retry_on_errors: [broken_pipe]
+  per_try_timeout: -1s
+routes:
+  - pattern: /api/*
+    target: http://api:8000
+`
+
+		_, err := ParseConfig([]byte(data), "yaml")
+		if err == nil {
+			t.Fatal("Expected an error, got nil")
+		}
+
+		for _, expected := range []string{
+			"retry.retry_on[0]",
+			`retry.retry_on_errors[0]: unknown error class "broken_pipe"`,
+			"retry.per_try_timeout",
+		} {
+			if !strings.Contains(err.Error(), expected) {
+				t.Errorf("Expected error to mention %s, got %v", expected, err)
+			}
+		}
+	})
+}
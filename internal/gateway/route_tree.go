@@ -0,0 +1,163 @@
+package gateway
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"sort"
+)
+
+// Segment trie over compiled routes. Lookups only visit the branches a path can
+// actually take, so the cost depends on path depth rather than route count.
+type routeNode struct {
+	literals  map[string]*routeNode
+	params    []*paramEdge     // {id} and {id:regex} children
+	exact     []*compiledRoute // routes whose pattern ends at this node, most specific first
+	wildcards []*compiledRoute // routes ending at this node with a trailing /*, most specific first
+}
+
+type paramEdge struct {
+	segment patternSegment
+	node    *routeNode
+}
+
+// Compiled route index built from a GatewayConfig, rebuilt whenever the config changes
+type routeTable struct {
+	root *routeNode
+}
+
+func newRouteNode() *routeNode {
+	return &routeNode{literals: make(map[string]*routeNode)}
+}
+
+func newRouteTable(routes []Route) *routeTable {
+	table := &routeTable{root: newRouteNode()}
+
+	for i := range routes {
+		compiled, err := compileRoute(&routes[i], i)
+		if err != nil {
+			log.Printf("⚠️ Skipping route %s: %v", routes[i].Pattern, err)
+			continue
+		}
+		table.insert(compiled)
+	}
+
+	table.root.sortRoutes()
+	return table
+}
+
+func (rt *routeTable) insert(compiled *compiledRoute) {
+	node := rt.root
+
+	for _, segment := range compiled.segments {
+		if segment.kind == segmentLiteral {
+			child, exists := node.literals[segment.literal]
+			if !exists {
+				child = newRouteNode()
+				node.literals[segment.literal] = child
+			}
+			node = child
+			continue
+		}
+
+		node = node.paramChild(segment)
+	}
+
+	if compiled.wildcard {
+		node.wildcards = append(node.wildcards, compiled)
+	} else {
+		node.exact = append(node.exact, compiled)
+	}
+}
+
+// Params with the same regex share a branch, their names are resolved once a route is chosen
+func (n *routeNode) paramChild(segment patternSegment) *routeNode {
+	for _, edge := range n.params {
+		if edge.segment.kind != segment.kind {
+			continue
+		}
+		if segment.kind == segmentParam || edge.segment.regex.String() == segment.regex.String() {
+			return edge.node
+		}
+	}
+
+	child := newRouteNode()
+	n.params = append(n.params, &paramEdge{segment: segment, node: child})
+	return child
+}
+
+func (n *routeNode) sortRoutes() {
+	for _, routes := range [][]*compiledRoute{n.exact, n.wildcards} {
+		sort.SliceStable(routes, func(i, j int) bool {
+			return moreSpecific(routes[i], routes[j])
+		})
+	}
+
+	for _, child := range n.literals {
+		child.sortRoutes()
+	}
+	for _, edge := range n.params {
+		edge.node.sortRoutes()
+	}
+}
+
+var errNoMatchingRoute = errors.New("no matching route")
+
+func (rt *routeTable) match(r *http.Request) (*RouteMatch, error) {
+	var buf [16]string
+	parts := splitPath(r.URL.Path, buf[:])
+
+	var best *compiledRoute
+	rt.root.collect(parts, 0, r, &best)
+
+	if best == nil {
+		return nil, errNoMatchingRoute
+	}
+
+	params := best.captureParams(r.URL.Path, parts)
+	return &RouteMatch{Route: best.route, Params: params, key: best.key}, nil
+}
+
+// Walks every branch the path can take and keeps the most specific route whose predicates hold
+func (n *routeNode) collect(parts []string, depth int, r *http.Request, best **compiledRoute) {
+	if depth == len(parts) {
+		considerRoutes(n.exact, r, best)
+	}
+	considerRoutes(n.wildcards, r, best)
+
+	if depth == len(parts) {
+		return
+	}
+
+	part := parts[depth]
+	if child, exists := n.literals[part]; exists {
+		child.collect(parts, depth+1, r, best)
+	}
+
+	for _, edge := range n.params {
+		switch edge.segment.kind {
+		case segmentParam:
+			if part == "" {
+				continue
+			}
+		case segmentRegex:
+			if !edge.segment.regex.MatchString(part) {
+				continue
+			}
+		}
+		edge.node.collect(parts, depth+1, r, best)
+	}
+}
+
+func considerRoutes(routes []*compiledRoute, r *http.Request, best **compiledRoute) {
+	for _, candidate := range routes {
+		// routes are sorted, once one loses to the current best the rest will too
+		if *best != nil && !moreSpecific(candidate, *best) {
+			return
+		}
+		if candidate.matchPredicates(r) {
+			*best = candidate
+			return
+		}
+	}
+}
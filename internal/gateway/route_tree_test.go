@@ -0,0 +1,105 @@
+package gateway
+
+import (
+	"fmt"
+	"net/http/httptest"
+	"sort"
+	"testing"
+)
+
+func tenantRoutes(count int) []Route {
+	routes := []Route{
+		{Pattern: "/api/*"},
+		{Pattern: "/api/users/{id:[0-9]+}"},
+		{Pattern: "/api/users/{id}"},
+		{Pattern: "/api/{version:v[0-9]+}/users/*"},
+	}
+
+	for i := 0; i < count; i++ {
+		routes = append(routes,
+			Route{Pattern: fmt.Sprintf("/tenants/t%d/api/*", i)},
+			Route{Pattern: fmt.Sprintf("/tenants/t%d/api/orders/{id}", i), Methods: []string{"GET"}},
+		)
+	}
+
+	return routes
+}
+
+// reference implementation: try every route in priority order
+func linearMatch(routes []Route, path string) *Route {
+	var compiled []*compiledRoute
+	for i := range routes {
+		c, err := compileRoute(&routes[i], i)
+		if err == nil {
+			compiled = append(compiled, c)
+		}
+	}
+	sort.SliceStable(compiled, func(i, j int) bool {
+		return moreSpecific(compiled[i], compiled[j])
+	})
+
+	req := httptest.NewRequest("GET", path, nil)
+	for _, c := range compiled {
+		if _, ok := c.matchPath(path, splitPath(path, nil)); ok && c.matchPredicates(req) {
+			return c.route
+		}
+	}
+	return nil
+}
+
+func TestRouteTableMatchesLinearScan(t *testing.T) {
+	routes := tenantRoutes(50)
+	table := newRouteTable(routes)
+
+	paths := []string{
+		"/", "/api", "/api/users", "/api/users/42", "/api/users/alice", "/api/users/42/orders",
+		"/api/v2/users/42", "/api/vx/users/42", "/tenants/t7/api/orders/9", "/tenants/t7/api/orders",
+		"/tenants/t49/api", "/tenants/t50/api/orders/1", "/tenants",
+	}
+
+	for _, path := range paths {
+		want := linearMatch(routes, path)
+
+		match, err := table.match(httptest.NewRequest("GET", path, nil))
+		if want == nil {
+			if err == nil {
+				t.Errorf("%s: expected no match, got %s", path, match.Route.key())
+			}
+			continue
+		}
+
+		if err != nil {
+			t.Errorf("%s: expected %s, got error %v", path, want.key(), err)
+			continue
+		}
+
+		if match.Route != want {
+			t.Errorf("%s: expected %s, got %s", path, want.key(), match.Route.key())
+		}
+	}
+}
+
+func BenchmarkRouteTableMatch(b *testing.B) {
+	for _, count := range []int{100, 1000, 5000} {
+		table := newRouteTable(tenantRoutes(count))
+
+		paths := []struct{ name, path string }{
+			{"TenantExact", fmt.Sprintf("/tenants/t%d/api/orders/42", count-1)},
+			{"TenantWildcard", fmt.Sprintf("/tenants/t%d/api/invoices/7", count/2)},
+			{"Regex", "/api/users/42"},
+			{"Miss", "/unknown/path"},
+		}
+
+		for _, p := range paths {
+			req := httptest.NewRequest("GET", p.path, nil)
+
+			// every tenant contributes two routes
+			b.Run(fmt.Sprintf("%dRoutes/%s", count*2, p.name), func(b *testing.B) {
+				b.ReportAllocs()
+				for i := 0; i < b.N; i++ {
+					table.match(req)
+				}
+			})
+		}
+	}
+}
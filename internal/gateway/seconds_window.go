@@ -0,0 +1,60 @@
+package gateway
+
+import "time"
+
+// Counters that can be summed over a window's buckets
+type windowCounts[T any] interface {
+	plus(other T) T
+}
+
+// Counts of the last len(buckets) seconds, one bucket per second. Used for the
+// circuit breaker's time window, retry budgets and the hedge rate limit.
+type secondsWindow[T windowCounts[T]] struct {
+	buckets []T
+	seconds []int64 // unix second each bucket holds, stale buckets are cleared on use
+}
+
+func newSecondsWindow[T windowCounts[T]](window time.Duration) *secondsWindow[T] {
+	size := int(window / time.Second)
+	return &secondsWindow[T]{
+		buckets: make([]T, size),
+		seconds: make([]int64, size),
+	}
+}
+
+// The bucket of the current second, to count into
+func (sw *secondsWindow[T]) bucket(now time.Time) *T {
+	second := now.Unix()
+	i := int(second % int64(len(sw.buckets)))
+	if sw.seconds[i] != second {
+		var zero T
+		sw.buckets[i] = zero
+		sw.seconds[i] = second
+	}
+	return &sw.buckets[i]
+}
+
+func (sw *secondsWindow[T]) totals(now time.Time) T {
+	var total T
+	oldest := now.Unix() - int64(len(sw.buckets))
+	for i, bucket := range sw.buckets {
+		if sw.seconds[i] > oldest {
+			total = total.plus(bucket)
+		}
+	}
+	return total
+}
+
+func (sw *secondsWindow[T]) reset() {
+	var zero T
+	for i := range sw.buckets {
+		sw.buckets[i] = zero
+		sw.seconds[i] = 0
+	}
+}
+
+// Whether one more of used stays under percent of total, or under floor
+func withinShare(used, total int, percent, floor float64) bool {
+	share := percent / 100 * float64(total)
+	return float64(used+1) <= max(floor, share)
+}
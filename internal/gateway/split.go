@@ -0,0 +1,104 @@
+package gateway
+
+import (
+	"hash/fnv"
+	"math/rand"
+	"net/http"
+
+	"github.com/aishahsofea/go-ai-gateway/internal/middleware"
+)
+
+// Splits a route's traffic between named backend groups, e.g. stable and canary
+type TrafficSplit struct {
+	Groups []BackendGroup `json:"groups"`
+	Sticky bool           `json:"sticky"` // keep an authenticated user on the same group, keyed by the JWT user ID
+}
+
+type BackendGroup struct {
+	Name     string    `json:"name"`
+	Percent  int       `json:"percent"` // share of traffic, the groups of a split add up to 100
+	Backends []Backend `json:"backends"`
+
+	// Requests carrying any of these headers or cookies always go to this group, "*" means any value
+	Headers map[string]string `json:"headers"`
+	Cookies map[string]string `json:"cookies"`
+}
+
+// Picks the group a request is sent to. Overrides win, then sticky users, then a random draw by percent.
+func (ts *TrafficSplit) chooseGroup(r *http.Request) *BackendGroup {
+	if ts == nil || len(ts.Groups) == 0 {
+		return nil
+	}
+
+	for i := range ts.Groups {
+		if ts.Groups[i].forcedBy(r) {
+			return &ts.Groups[i]
+		}
+	}
+
+	bucket := rand.Intn(100)
+	if ts.Sticky {
+		if user, ok := middleware.LookupUser(r); ok && !user.IsAnonymous() {
+			bucket = stickyBucket(user.ID.String())
+		}
+	}
+
+	return ts.groupForBucket(bucket)
+}
+
+// Groups own consecutive ranges of [0, 100) in declaration order
+func (ts *TrafficSplit) groupForBucket(bucket int) *BackendGroup {
+	cumulative := 0
+	for i := range ts.Groups {
+		cumulative += ts.Groups[i].Percent
+		if bucket < cumulative {
+			return &ts.Groups[i]
+		}
+	}
+	return &ts.Groups[len(ts.Groups)-1]
+}
+
+func (bg *BackendGroup) forcedBy(r *http.Request) bool {
+	for name, expected := range bg.Headers {
+		if matchesValue(expected, r.Header.Values(name)) {
+			return true
+		}
+	}
+
+	for name, expected := range bg.Cookies {
+		cookie, err := r.Cookie(name)
+		if err == nil && matchesValue(expected, []string{cookie.Value}) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Maps a user to a fixed bucket in [0, 100), so they stay on one group while the percentages are unchanged
+func stickyBucket(userID string) int {
+	hash := fnv.New32a()
+	hash.Write([]byte(userID))
+	return int(hash.Sum32() % 100)
+}
+
+// Every backend of the split, used when the chosen group has nothing available
+func (ts *TrafficSplit) allBackends() []Backend {
+	var backends []Backend
+	for _, group := range ts.Groups {
+		backends = append(backends, group.Backends...)
+	}
+	return backends
+}
+
+// Whether any group gives its backends different weights
+func (ts *TrafficSplit) weighted() bool {
+	for _, group := range ts.Groups {
+		for _, backend := range group.Backends {
+			if backend.Weight != group.Backends[0].Weight {
+				return true
+			}
+		}
+	}
+	return false
+}
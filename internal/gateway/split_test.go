@@ -0,0 +1,162 @@
+package gateway
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/aishahsofea/go-ai-gateway/internal/middleware"
+	"github.com/aishahsofea/go-ai-gateway/internal/models"
+	"github.com/google/uuid"
+)
+
+func canarySplit() *TrafficSplit {
+	return &TrafficSplit{
+		Sticky: true,
+		Groups: []BackendGroup{
+			{Name: "stable", Percent: 90, Backends: []Backend{{URL: "http://stable:8000", Healthy: true, Weight: 1}}},
+			{
+				Name:     "canary",
+				Percent:  10,
+				Backends: []Backend{{URL: "http://canary:8000", Healthy: true, Weight: 1}},
+				Headers:  map[string]string{"X-Canary": "true"},
+				Cookies:  map[string]string{"canary": "*"},
+			},
+		},
+	}
+}
+
+func TestTrafficSplit(t *testing.T) {
+	t.Run("SplitsByPercent", func(t *testing.T) {
+		split := canarySplit()
+
+		canary := 0
+		for i := 0; i < 10000; i++ {
+			if split.chooseGroup(httptest.NewRequest("GET", "/", nil)).Name == "canary" {
+				canary++
+			}
+		}
+
+		if canary < 800 || canary > 1200 {
+			t.Errorf("Expected about 10%% of requests on canary, got %d of 10000", canary)
+		}
+	})
+
+	t.Run("HeaderAndCookieOverrides", func(t *testing.T) {
+		split := canarySplit()
+
+		byHeader := httptest.NewRequest("GET", "/", nil)
+		byHeader.Header.Set("X-Canary", "true")
+
+		byCookie := httptest.NewRequest("GET", "/", nil)
+		byCookie.AddCookie(&http.Cookie{Name: "canary", Value: "1"})
+
+		for name, r := range map[string]*http.Request{"header": byHeader, "cookie": byCookie} {
+			for i := 0; i < 20; i++ {
+				if group := split.chooseGroup(r); group.Name != "canary" {
+					t.Fatalf("Expected %s override to force canary, got %s", name, group.Name)
+				}
+			}
+		}
+	})
+
+	t.Run("StickyPerUser", func(t *testing.T) {
+		split := canarySplit()
+
+		for i := 0; i < 50; i++ {
+			user := &models.User{ID: uuid.New()}
+			r := middleware.SetUser(httptest.NewRequest("GET", "/", nil), user)
+
+			first := split.chooseGroup(r)
+			for j := 0; j < 10; j++ {
+				if group := split.chooseGroup(r); group != first {
+					t.Fatalf("Expected user %s to stay on %s, got %s", user.ID, first.Name, group.Name)
+				}
+			}
+		}
+	})
+
+	t.Run("FallsBackWhenGroupUnavailable", func(t *testing.T) {
+		stable := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusOK)
+		}))
+		defer stable.Close()
+
+		split := canarySplit()
+		split.Groups[0].Backends[0].URL = stable.URL
+		split.Groups[1].Backends[0].Healthy = false
+
+		proxy := NewProxy(testConfig(Route{Pattern: "/api/*", Split: split}), DefaultTimeoutConfig())
+
+		r := httptest.NewRequest("GET", "/api/orders", nil)
+		r.Header.Set("X-Canary", "true")
+
+		w := httptest.NewRecorder()
+		proxy.ServeHTTP(w, r)
+
+		if w.Code != http.StatusOK {
+			t.Errorf("Expected request to fall back to stable with 200, got %d", w.Code)
+		}
+	})
+
+	t.Run("ValidationErrors", func(t *testing.T) {
+		data := `
+routes:
+  - pattern: /api/orders/*
+    target: http://orders:8000
+    split:
+      groups:
+        - name: stable
+          percent: 80
+          backends:
+            - url: http://stable:8000
+        - name: stable
+          percent: 10
+`
+
+		_, err := ParseConfig([]byte(data), "yaml")
+		if err == nil {
+			t.Fatal("Expected validation error, got nil")
+		}
+
+		expected := []string{
+			`routes[0]: split replaces target and backends`,
+			`routes[0].split.groups[1].name: duplicate group "stable"`,
+			`routes[0].split.groups[1].backends: at least one backend is required`,
+			`routes[0].split.groups: percentages must add up to 100, got 90`,
+		}
+
+		for _, msg := range expected {
+			if !strings.Contains(err.Error(), msg) {
+				t.Errorf("Expected error to contain %q, got:\n%v", msg, err)
+			}
+		}
+	})
+
+	t.Run("WeightsNeedAWeightedStrategy", func(t *testing.T) {
+		split := `
+    split:
+      groups:
+        - name: stable
+          percent: 100
+          backends:
+            - url: http://stable-a:8000
+              weight: 3
+            - url: http://stable-b:8000
+`
+
+		config, err := ParseConfig([]byte("routes:\n  - pattern: /api/orders/*"+split), "yaml")
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		if config.Routes[0].LoadBalancer != WeightedRoundRobin {
+			t.Errorf("Expected split routes to default to %s, got %s", WeightedRoundRobin, config.Routes[0].LoadBalancer)
+		}
+
+		_, err = ParseConfig([]byte("routes:\n  - pattern: /api/orders/*\n    load_balancer: round_robin"+split), "yaml")
+		if err == nil || !strings.Contains(err.Error(), "routes[0].load_balancer: round_robin ignores the weights of split backends") {
+			t.Errorf("Expected round_robin with uneven weights to be rejected, got %v", err)
+		}
+	})
+}
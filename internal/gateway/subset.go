@@ -0,0 +1,110 @@
+package gateway
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/aishahsofea/go-ai-gateway/internal/middleware"
+)
+
+// Restricts a route's backends to those whose metadata matches a selector.
+// Registry instances carry their registered metadata, static backends declare theirs.
+type SubsetConfig struct {
+	Selector map[string]string `json:"selector"` // always applied, e.g. zone: eu
+	Request  []SubsetSelector  `json:"request"`  // derived from each request, e.g. X-Api-Version -> version
+	Fallback map[string]string `json:"fallback"` // used instead of the request selectors when no backend matches them
+}
+
+// Maps a request header or JWT claim onto a metadata key
+type SubsetSelector struct {
+	Header string `json:"header"`
+	Claim  string `json:"claim"`
+	Key    string `json:"key"`
+	Value  string `json:"value"` // template for the metadata value, e.g. "v{value}", defaults to the raw value
+}
+
+// Builds the metadata selector for a request: the static selector plus every request selector that has a value
+func (sc *SubsetConfig) requestSelector(r *http.Request) map[string]string {
+	if sc == nil {
+		return nil
+	}
+
+	selector := make(map[string]string, len(sc.Selector)+len(sc.Request))
+	for key, value := range sc.Selector {
+		selector[key] = value
+	}
+
+	for _, source := range sc.Request {
+		value := source.valueFrom(r)
+		if value == "" {
+			continue
+		}
+		if source.Value != "" {
+			value = strings.ReplaceAll(source.Value, "{value}", value)
+		}
+		selector[source.Key] = value
+	}
+
+	return selector
+}
+
+func (ss *SubsetSelector) valueFrom(r *http.Request) string {
+	if ss.Header != "" {
+		return r.Header.Get(ss.Header)
+	}
+
+	claims, ok := middleware.LookupClaims(r)
+	if !ok {
+		return ""
+	}
+
+	value, exists := claims[ss.Claim]
+	if !exists || value == nil {
+		return ""
+	}
+	return fmt.Sprint(value)
+}
+
+// Narrows backends down to those matching selector, then to the fallback subset.
+// Returns nothing when neither matches.
+func (sc *SubsetConfig) filter(backends []Backend, selector map[string]string) []Backend {
+	if sc == nil {
+		return backends
+	}
+
+	if matched := matchingBackends(backends, selector); len(matched) > 0 {
+		return matched
+	}
+
+	fallback := make(map[string]string, len(sc.Selector)+len(sc.Fallback))
+	for key, value := range sc.Selector {
+		fallback[key] = value
+	}
+	for key, value := range sc.Fallback {
+		fallback[key] = value
+	}
+
+	log.Printf("⚠️ No backend matches subset %v, falling back to %v", selector, fallback)
+	return matchingBackends(backends, fallback)
+}
+
+func matchingBackends(backends []Backend, selector map[string]string) []Backend {
+	var matched []Backend
+	for _, backend := range backends {
+		if metadataMatches(backend.Metadata, selector) {
+			matched = append(matched, backend)
+		}
+	}
+	return matched
+}
+
+func metadataMatches(metadata, selector map[string]string) bool {
+	for key, expected := range selector {
+		if metadata[key] != expected {
+			return false
+		}
+	}
+	return true
+}
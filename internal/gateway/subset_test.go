@@ -0,0 +1,97 @@
+package gateway
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/aishahsofea/go-ai-gateway/internal/middleware"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+func TestSubsetRouting(t *testing.T) {
+	newBackend := func(name string) *httptest.Server {
+		return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte(name))
+		}))
+	}
+
+	v1, v2, v2US := newBackend("v1"), newBackend("v2"), newBackend("v2-us")
+	defer v1.Close()
+	defer v2.Close()
+	defer v2US.Close()
+
+	registry := NewServiceRegistry()
+	registry.RegisterService(&ServiceInstance{ID: "v1", URL: v1.URL, Route: "/api/*", Metadata: map[string]string{"version": "v1", "zone": "eu"}})
+	registry.RegisterService(&ServiceInstance{ID: "v2", URL: v2.URL, Route: "/api/*", Metadata: map[string]string{"version": "v2", "zone": "eu", "tier": "pro"}})
+	registry.RegisterService(&ServiceInstance{ID: "v2-us", URL: v2US.URL, Route: "/api/*", Metadata: map[string]string{"version": "v2", "zone": "us"}})
+
+	proxy := NewProxy(testConfig(Route{
+		Pattern:   "/api/*",
+		Discovery: DiscoveryRegistry,
+		Subset: &SubsetConfig{
+			Selector: map[string]string{"zone": "eu"},
+			Request: []SubsetSelector{
+				{Header: "X-Api-Version", Key: "version", Value: "v{value}"},
+				{Claim: "tier", Key: "tier"},
+			},
+			Fallback: map[string]string{"version": "v1"},
+		},
+	}), DefaultTimeoutConfig())
+	proxy.SetRegistry(registry)
+
+	tests := []struct {
+		name    string
+		version string
+		claims  jwt.MapClaims
+		want    string
+	}{
+		{name: "HeaderSelectsSubset", version: "2", want: "v2"},
+		{name: "ClaimSelectsSubset", claims: jwt.MapClaims{"tier": "pro"}, want: "v2"},
+		{name: "NoMatchUsesFallback", version: "3", want: "v1"},
+		{name: "StaticSelectorAlwaysApplies", version: "2", claims: jwt.MapClaims{"tier": "free"}, want: "v1"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for i := 0; i < 5; i++ {
+				r := httptest.NewRequest("GET", "/api/items", nil)
+				if tt.version != "" {
+					r.Header.Set("X-Api-Version", tt.version)
+				}
+				if tt.claims != nil {
+					r = middleware.SetClaims(r, tt.claims)
+				}
+
+				w := httptest.NewRecorder()
+				proxy.ServeHTTP(w, r)
+
+				if got := w.Body.String(); got != tt.want {
+					t.Fatalf("Expected backend %s, got %q (status %d)", tt.want, got, w.Code)
+				}
+			}
+		})
+	}
+
+	t.Run("ValidationErrors", func(t *testing.T) {
+		data := `{"routes": [{"pattern": "/x", "target": "http://x:1", "subset": {"request": [{"header": "X-V", "claim": "v"}, {"header": "X-V", "key": "version", "value": "v"}]}}]}`
+
+		_, err := ParseConfig([]byte(data), "json")
+		if err == nil {
+			t.Fatal("Expected validation error, got nil")
+		}
+
+		expected := []string{
+			`routes[0].subset.request[0]: exactly one of header or claim is required`,
+			`routes[0].subset.request[0].key: is required`,
+			`routes[0].subset.request[1].value: template must contain {value}`,
+		}
+
+		for _, msg := range expected {
+			if !strings.Contains(err.Error(), msg) {
+				t.Errorf("Expected error to contain %q, got:\n%v", msg, err)
+			}
+		}
+	})
+}
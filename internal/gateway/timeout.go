@@ -2,13 +2,14 @@ package gateway
 
 import (
 	"context"
+	"encoding/json"
 	"time"
 )
 
 type TimeoutConfig struct {
-	RequestTimeout time.Duration // overall request timeout (client -> gateway -> backend -> client)
-	BackendTimeout time.Duration // per-backend timeout (gateway -> backend)
-	ConnectTimeout time.Duration // TCP connection timeout
+	RequestTimeout time.Duration `json:"request_timeout"` // overall request timeout (client -> gateway -> backend -> client)
+	BackendTimeout time.Duration `json:"backend_timeout"` // per-backend timeout (gateway -> backend)
+	ConnectTimeout time.Duration `json:"connect_timeout"` // TCP connection timeout
 }
 
 func DefaultTimeoutConfig() TimeoutConfig {
@@ -19,6 +20,46 @@ func DefaultTimeoutConfig() TimeoutConfig {
 	}
 }
 
+// Accepts durations as Go duration strings ("5s") in config files
+func (tc *TimeoutConfig) UnmarshalJSON(data []byte) error {
+	type alias TimeoutConfig
+	aux := struct {
+		*alias
+		RequestTimeout Duration `json:"request_timeout"`
+		BackendTimeout Duration `json:"backend_timeout"`
+		ConnectTimeout Duration `json:"connect_timeout"`
+	}{
+		alias:          (*alias)(tc),
+		RequestTimeout: Duration(tc.RequestTimeout),
+		BackendTimeout: Duration(tc.BackendTimeout),
+		ConnectTimeout: Duration(tc.ConnectTimeout),
+	}
+
+	if err := decodeStrict(data, &aux); err != nil {
+		return err
+	}
+
+	tc.RequestTimeout = time.Duration(aux.RequestTimeout)
+	tc.BackendTimeout = time.Duration(aux.BackendTimeout)
+	tc.ConnectTimeout = time.Duration(aux.ConnectTimeout)
+	return nil
+}
+
+func (tc TimeoutConfig) MarshalJSON() ([]byte, error) {
+	type alias TimeoutConfig
+	return json.Marshal(struct {
+		alias
+		RequestTimeout Duration `json:"request_timeout"`
+		BackendTimeout Duration `json:"backend_timeout"`
+		ConnectTimeout Duration `json:"connect_timeout"`
+	}{
+		alias:          alias(tc),
+		RequestTimeout: Duration(tc.RequestTimeout),
+		BackendTimeout: Duration(tc.BackendTimeout),
+		ConnectTimeout: Duration(tc.ConnectTimeout),
+	})
+}
+
 func (tc *TimeoutConfig) WithBackendTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
 	return context.WithTimeout(ctx, tc.BackendTimeout)
 }
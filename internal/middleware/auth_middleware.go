@@ -2,6 +2,8 @@ package middleware
 
 import (
 	"context"
+	"crypto/subtle"
+	"errors"
 	"fmt"
 	"net/http"
 	"os"
@@ -16,7 +18,8 @@ import (
 type contextkey string
 
 const (
-	UserContextKey = contextkey("user")
+	UserContextKey   = contextkey("user")
+	ClaimsContextKey = contextkey("claims")
 )
 
 func SetUser(r *http.Request, user *models.User) *http.Request {
@@ -32,6 +35,23 @@ func GetUser(r *http.Request) *models.User {
 	return user
 }
 
+func SetClaims(r *http.Request, claims jwt.MapClaims) *http.Request {
+	ctx := context.WithValue(r.Context(), ClaimsContextKey, claims)
+	return r.WithContext(ctx)
+}
+
+// Returns the claims of a valid token seen by Authenticate or IdentifyUser, if any
+func LookupClaims(r *http.Request) (jwt.MapClaims, bool) {
+	claims, ok := r.Context().Value(ClaimsContextKey).(jwt.MapClaims)
+	return claims, ok
+}
+
+// Returns the user set by Authenticate or IdentifyUser, if any
+func LookupUser(r *http.Request) (*models.User, bool) {
+	user, ok := r.Context().Value(UserContextKey).(*models.User)
+	return user, ok
+}
+
 func Authenticate(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 
@@ -52,54 +72,103 @@ func Authenticate(next http.Handler) http.Handler {
 
 		bearerToken := strings.TrimPrefix(authHeader, "Bearer ")
 
-		// Pre-check for SECRET
-		secret := os.Getenv("SECRET")
-		if secret == "" {
-			utils.WriteJSON(w, http.StatusUnauthorized, utils.Envelope{"error": "server misconfiguration: missing JWT secret"})
+		user, claims, err := userFromToken(bearerToken)
+		if err != nil {
+			utils.WriteJSON(w, http.StatusUnauthorized, utils.Envelope{"error": err.Error()})
 			return
 		}
 
-		// Validate JWT token
-		token, err := jwt.Parse(bearerToken, func(token *jwt.Token) (interface{}, error) {
-			_, ok := token.Method.(*jwt.SigningMethodHMAC)
-			if !ok {
-				return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+		// Add user to request context
+		r = SetUser(r, user)
+		r = SetClaims(r, claims)
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// Only lets through requests carrying adminToken as their bearer token. User
+// tokens are not enough, anyone can sign up and pick their own role. Signed-in
+// users get a 403, everyone else a 401. An empty adminToken turns every request away.
+func RequireAdmin(adminToken string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Add("Vary", "Authorization")
+
+			bearerToken, found := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+			if !found {
+				utils.WriteJSON(w, http.StatusUnauthorized, utils.Envelope{"error": "authentication required"})
+				return
+			}
+
+			if adminToken != "" && subtle.ConstantTimeCompare([]byte(bearerToken), []byte(adminToken)) == 1 {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			if _, _, err := userFromToken(bearerToken); err == nil {
+				utils.WriteJSON(w, http.StatusForbidden, utils.Envelope{"error": "admin access required"})
+				return
 			}
-			return []byte(secret), nil
-		})
 
-		if err != nil || !token.Valid {
 			utils.WriteJSON(w, http.StatusUnauthorized, utils.Envelope{"error": "invalid token"})
-			return
-		}
+		})
+	}
+}
 
-		// Extract user claims
-		claims, ok := token.Claims.(jwt.MapClaims)
-		if !ok {
-			utils.WriteJSON(w, http.StatusUnauthorized, utils.Envelope{"error": "invalid token claims"})
-			return
+// Like Authenticate, but requests without a valid token are passed on as the
+// anonymous user instead of being rejected. For handlers that only use the user as a hint.
+func IdentifyUser(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		bearerToken, found := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if found {
+			if user, claims, err := userFromToken(bearerToken); err == nil {
+				next.ServeHTTP(w, SetClaims(SetUser(r, user), claims))
+				return
+			}
 		}
 
-		// Extract user ID from claims
-		userIDString, ok := claims["sub"].(string)
+		next.ServeHTTP(w, SetUser(r, models.AnonymousUser))
+	})
+}
+
+func userFromToken(bearerToken string) (*models.User, jwt.MapClaims, error) {
+	// Pre-check for SECRET
+	secret := os.Getenv("SECRET")
+	if secret == "" {
+		return nil, nil, errors.New("server misconfiguration: missing JWT secret")
+	}
+
+	// Validate JWT token
+	token, err := jwt.Parse(bearerToken, func(token *jwt.Token) (interface{}, error) {
+		_, ok := token.Method.(*jwt.SigningMethodHMAC)
 		if !ok {
-			utils.WriteJSON(w, http.StatusUnauthorized, utils.Envelope{"error": "invalid user ID in token"})
-			return
+			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
 		}
+		return []byte(secret), nil
+	})
 
-		userID, err := uuid.Parse(userIDString)
-		if err != nil {
-			utils.WriteJSON(w, http.StatusUnauthorized, utils.Envelope{"error": "invalid user ID format in token"})
-			return
-		}
+	if err != nil || !token.Valid {
+		return nil, nil, errors.New("invalid token")
+	}
 
-		user := &models.User{
-			ID: userID,
-		}
+	// Extract user claims
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok {
+		return nil, nil, errors.New("invalid token claims")
+	}
 
-		// Add user to request context
-		r = SetUser(r, user)
+	// Extract user ID from claims
+	userIDString, ok := claims["sub"].(string)
+	if !ok {
+		return nil, nil, errors.New("invalid user ID in token")
+	}
 
-		next.ServeHTTP(w, r)
-	})
+	userID, err := uuid.Parse(userIDString)
+	if err != nil {
+		return nil, nil, errors.New("invalid user ID format in token")
+	}
+
+	return &models.User{
+		ID: userID,
+	}, claims, nil
 }
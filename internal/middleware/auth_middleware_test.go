@@ -0,0 +1,67 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+)
+
+func TestRequireAdmin(t *testing.T) {
+	t.Setenv("SECRET", "test-secret")
+
+	userToken, err := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+		"sub":  uuid.New().String(),
+		"role": "admin", // chosen by the user at sign-up, must not count
+		"exp":  time.Now().Add(time.Hour).Unix(),
+	}).SignedString([]byte("test-secret"))
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	handler := RequireAdmin("admin-token")(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+
+	cases := []struct {
+		name          string
+		authorization string
+		expected      int
+	}{
+		{"AdminToken", "Bearer admin-token", http.StatusNoContent},
+		{"SignedInUser", "Bearer " + userToken, http.StatusForbidden},
+		{"InvalidToken", "Bearer forged", http.StatusUnauthorized},
+		{"NoToken", "", http.StatusUnauthorized},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			r := httptest.NewRequest("POST", "/admin/circuit-breakers/reset", nil)
+			if c.authorization != "" {
+				r.Header.Set("Authorization", c.authorization)
+			}
+
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, r)
+
+			if w.Code != c.expected {
+				t.Errorf("Expected status %d, got %d", c.expected, w.Code)
+			}
+		})
+	}
+
+	t.Run("NoAdminTokenConfigured", func(t *testing.T) {
+		r := httptest.NewRequest("GET", "/admin/routes", nil)
+		r.Header.Set("Authorization", "Bearer ")
+
+		w := httptest.NewRecorder()
+		RequireAdmin("")(handler).ServeHTTP(w, r)
+
+		if w.Code != http.StatusUnauthorized {
+			t.Errorf("Expected status 401, got %d", w.Code)
+		}
+	})
+}
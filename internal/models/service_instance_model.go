@@ -0,0 +1,18 @@
+package models
+
+import (
+	"time"
+)
+
+// A registered service instance as it is stored in service_instances
+type ServiceInstance struct {
+	Route         string            `db:"route"`
+	ID            string            `db:"id"`
+	URL           string            `db:"url"`
+	Health        string            `db:"health"`
+	Metadata      map[string]string `db:"metadata"`
+	TTL           time.Duration     `db:"ttl_ms"`
+	LastSeen      time.Time         `db:"last_seen"`
+	LastHeartbeat time.Time         `db:"last_heartbeat"`
+	HealthCheck   []byte            `db:"health_check"` // JSON, nil when the instance uses the checker's defaults
+}
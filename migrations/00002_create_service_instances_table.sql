@@ -0,0 +1,27 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE TABLE IF NOT EXISTS service_instances (
+    route VARCHAR(255) NOT NULL,
+    id VARCHAR(255) NOT NULL,
+    url VARCHAR(2048) NOT NULL,
+    health VARCHAR(50) NOT NULL DEFAULT '',
+    metadata JSONB NOT NULL DEFAULT '{}',
+    ttl_ms BIGINT NOT NULL DEFAULT 0,
+    last_seen TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (route, id)
+);
+
+-- reuses the trigger function from the users migration
+CREATE TRIGGER update_service_instances_updated_at
+    BEFORE UPDATE ON service_instances
+    FOR EACH ROW
+    EXECUTE FUNCTION update_updated_at_column();
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TRIGGER IF EXISTS update_service_instances_updated_at ON service_instances;
+DROP TABLE IF EXISTS service_instances;
+-- +goose StatementEnd
@@ -0,0 +1,9 @@
+-- +goose Up
+-- +goose StatementBegin
+ALTER TABLE service_instances ADD COLUMN IF NOT EXISTS health_check JSONB;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+ALTER TABLE service_instances DROP COLUMN IF EXISTS health_check;
+-- +goose StatementEnd
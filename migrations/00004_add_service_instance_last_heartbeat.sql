@@ -0,0 +1,10 @@
+-- +goose Up
+-- +goose StatementBegin
+ALTER TABLE service_instances ADD COLUMN IF NOT EXISTS last_heartbeat TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP;
+UPDATE service_instances SET last_heartbeat = last_seen;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+ALTER TABLE service_instances DROP COLUMN IF EXISTS last_heartbeat;
+-- +goose StatementEnd